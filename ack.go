@@ -0,0 +1,199 @@
+package kuromi
+
+import (
+	"bytes"
+	"context"
+	"strconv"
+	"sync"
+
+	"github.com/coder/websocket"
+)
+
+// Messages sent with BroadcastWithAck are prefixed with AckRequestPrefix, the
+// message id and SequenceSeparator, e.g. "kuromi.ackreq:7\nhello". Clients
+// confirm receipt by sending the text message AckPrefix followed by the id,
+// e.g. "kuromi.ack:7". Confirming the same message more than once is harmless.
+const (
+	AckRequestPrefix = "kuromi.ackreq:"
+	AckPrefix        = "kuromi.ack:"
+)
+
+// AckReport is the result of BroadcastWithAck.
+type AckReport struct {
+	ID      string     // Id of the message.
+	Acked   []*Session // Sessions that confirmed receipt.
+	Unacked []*Session // Sessions that did not confirm receipt before the last retry timed out, they are passed to the dead-letter handler.
+}
+
+type ackTracker struct {
+	mu      sync.Mutex
+	waiting map[*Session]struct{}
+	acked   []*Session
+	done    chan struct{}
+}
+
+func (at *ackTracker) ack(s *Session) {
+	at.mu.Lock()
+	defer at.mu.Unlock()
+
+	if _, ok := at.waiting[s]; !ok {
+		return
+	}
+
+	delete(at.waiting, s)
+	at.acked = append(at.acked, s)
+
+	if len(at.waiting) == 0 {
+		close(at.done)
+	}
+}
+
+func (at *ackTracker) unacked() []*Session {
+	at.mu.Lock()
+	defer at.mu.Unlock()
+
+	sessions := make([]*Session, 0, len(at.waiting))
+	for s := range at.waiting {
+		sessions = append(sessions, s)
+	}
+
+	return sessions
+}
+
+type ackRegistry struct {
+	mu       sync.Mutex
+	next     uint64
+	trackers map[string]*ackTracker
+}
+
+func newAckRegistry() *ackRegistry {
+	return &ackRegistry{
+		trackers: make(map[string]*ackTracker),
+	}
+}
+
+func (ar *ackRegistry) add(sessions []*Session) (string, *ackTracker) {
+	at := &ackTracker{
+		waiting: make(map[*Session]struct{}, len(sessions)),
+		done:    make(chan struct{}),
+	}
+
+	for _, s := range sessions {
+		at.waiting[s] = struct{}{}
+	}
+
+	if len(sessions) == 0 {
+		close(at.done)
+	}
+
+	ar.mu.Lock()
+	defer ar.mu.Unlock()
+
+	ar.next++
+	id := strconv.FormatUint(ar.next, 10)
+	ar.trackers[id] = at
+
+	return id, at
+}
+
+func (ar *ackRegistry) get(id string) (*ackTracker, bool) {
+	ar.mu.Lock()
+	defer ar.mu.Unlock()
+
+	at, ok := ar.trackers[id]
+
+	return at, ok
+}
+
+func (ar *ackRegistry) del(id string) {
+	ar.mu.Lock()
+	defer ar.mu.Unlock()
+
+	delete(ar.trackers, id)
+}
+
+// BroadcastWithAck writes a text message to all sessions and waits for them to
+// confirm receipt, see AckPrefix. The message is written again to sessions that
+// did not confirm it within Config.AckTimeout, up to Config.AckRetries times.
+// It returns ctx.Err() along with the report so far if ctx is done first.
+func (k *Kuromi) BroadcastWithAck(ctx context.Context, msg []byte) (*AckReport, error) {
+	return k.BroadcastFilterWithAck(ctx, msg, nil)
+}
+
+// BroadcastFilterWithAck does the same as BroadcastWithAck for the sessions that fn returns true for.
+func (k *Kuromi) BroadcastFilterWithAck(ctx context.Context, msg []byte, fn func(*Session) bool) (*AckReport, error) {
+	if k.hub.closed() {
+		return nil, ErrClosed
+	}
+
+	var sessions []*Session
+	for _, s := range k.hub.all() {
+		if fn == nil || fn(s) {
+			sessions = append(sessions, s)
+		}
+	}
+
+	id, at := k.acks.add(sessions)
+	defer k.acks.del(id)
+
+	message := envelope{t: websocket.MessageText, msg: msg, ackID: id}
+
+	var err error
+
+	for attempt := 0; attempt <= k.Config.AckRetries; attempt++ {
+		for _, s := range at.unacked() {
+			s.writeMessage(message)
+		}
+
+		timer := k.clock().NewTimer(k.Config.AckTimeout)
+
+		select {
+		case <-at.done:
+		case <-timer.C():
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+
+		timer.Stop()
+
+		if err != nil || len(at.unacked()) == 0 {
+			break
+		}
+	}
+
+	at.mu.Lock()
+	report := &AckReport{ID: id, Acked: append([]*Session(nil), at.acked...)}
+	at.mu.Unlock()
+
+	report.Unacked = at.unacked()
+
+	if err == nil {
+		for _, s := range report.Unacked {
+			s.deadLetter(envelope{t: websocket.MessageText, msg: msg}, ErrAckTimeout)
+		}
+	}
+
+	return report, err
+}
+
+// handleAck records the confirmation in message and reports whether message was one.
+func (s *Session) handleAck(message []byte) bool {
+	if !bytes.HasPrefix(message, []byte(AckPrefix)) {
+		return false
+	}
+
+	if at, ok := s.kuromi.acks.get(string(message[len(AckPrefix):])); ok {
+		at.ack(s)
+	}
+
+	return true
+}
+
+func frameAckRequest(id string, msg []byte) []byte {
+	b := make([]byte, 0, len(AckRequestPrefix)+len(id)+1+len(msg))
+	b = append(b, AckRequestPrefix...)
+	b = append(b, id...)
+	b = append(b, SequenceSeparator)
+
+	return append(b, msg...)
+}
@@ -0,0 +1,70 @@
+package kuromi
+
+import (
+	"github.com/coder/websocket"
+)
+
+// RoomAction is an action of a session on a room checked by an Authorizer.
+type RoomAction string
+
+// Room actions.
+const (
+	RoomJoin    RoomAction = "join"    // Session.Join and Session.JoinWithMeta, also when a session resumes.
+	RoomPublish RoomAction = "publish" // Session.BroadcastRoom and Session.BroadcastRoomBinary.
+	RoomRead    RoomAction = "read"    // Receiving messages broadcast to a room and its history.
+)
+
+// Authorizer decides whether a session may perform an action on a room, see Kuromi.SetAuthorizer.
+type Authorizer interface {
+	// Authorize returns nil if s may perform action on room, and an error
+	// such as ErrForbidden otherwise. It is called concurrently, for RoomRead
+	// once per member and message broadcast to the room, so it should be fast.
+	Authorize(s *Session, room string, action RoomAction) error
+}
+
+// AuthorizerFunc is a function implementing Authorizer.
+type AuthorizerFunc func(s *Session, room string, action RoomAction) error
+
+// Authorize implements Authorizer.
+func (fn AuthorizerFunc) Authorize(s *Session, room string, action RoomAction) error {
+	return fn(s, room, action)
+}
+
+// SetAuthorizer sets the Authorizer checking the room actions of sessions.
+// Members not allowed to read a room stay in it but do not receive its messages.
+func (k *Kuromi) SetAuthorizer(a Authorizer) {
+	k.authorizer = a
+}
+
+// authorize returns nil if s may perform action on room.
+func (k *Kuromi) authorize(s *Session, room string, action RoomAction) error {
+	if k.authorizer == nil {
+		return nil
+	}
+
+	return k.authorizer.Authorize(s, room, action)
+}
+
+// BroadcastRoom broadcasts a text message to all sessions in room on behalf of
+// the session, if the Authorizer allows it to publish to room.
+func (s *Session) BroadcastRoom(room string, msg []byte) error {
+	return s.broadcastRoom(room, envelope{t: websocket.MessageText, msg: msg})
+}
+
+// BroadcastRoomBinary broadcasts a binary message to all sessions in room on
+// behalf of the session, if the Authorizer allows it to publish to room.
+func (s *Session) BroadcastRoomBinary(room string, msg []byte) error {
+	return s.broadcastRoom(room, envelope{t: websocket.MessageBinary, msg: msg})
+}
+
+func (s *Session) broadcastRoom(room string, message envelope) error {
+	if s.closed() {
+		return ErrSessionClosed
+	}
+
+	if err := s.kuromi.authorize(s, room, RoomPublish); err != nil {
+		return err
+	}
+
+	return s.kuromi.broadcastRoom(room, message)
+}
@@ -0,0 +1,74 @@
+package kuromi
+
+import (
+	"net"
+	"net/netip"
+	"strings"
+)
+
+// RemoteAddr returns the network address of the peer of the session, which is
+// the load balancer or reverse proxy in front of the server if there is one.
+func (s *Session) RemoteAddr() string {
+	return s.Request.RemoteAddr
+}
+
+// ClientIP returns the IP address of the client of the session. If the peer of
+// the session is listed in Config.TrustedProxies, the address is taken from the
+// first of Config.ClientIPHeaders set on the request, skipping trusted proxies
+// in X-Forwarded-For style lists from the right. Otherwise the headers are
+// ignored, since untrusted clients can set them to anything.
+func (s *Session) ClientIP() string {
+	return clientIP(s.Request.RemoteAddr, s.Request.Header.Values, s.kuromi.Config)
+}
+
+func clientIP(remoteAddr string, header func(string) []string, c *Config) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+
+	peer, err := netip.ParseAddr(host)
+	if err != nil || !trusted(peer, c.TrustedProxies) {
+		return host
+	}
+
+	for _, name := range c.ClientIPHeaders {
+		var hops []string
+		for _, v := range header(name) {
+			hops = append(hops, strings.Split(v, ",")...)
+		}
+
+		for i := len(hops) - 1; i >= 0; i-- {
+			addr, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+			if err != nil {
+				break
+			}
+
+			if i == 0 || !trusted(addr, c.TrustedProxies) {
+				return addr.Unmap().String()
+			}
+		}
+	}
+
+	return host
+}
+
+// trusted reports whether addr is one of proxies, given as IP addresses or CIDR prefixes.
+func trusted(addr netip.Addr, proxies []string) bool {
+	addr = addr.Unmap()
+
+	for _, proxy := range proxies {
+		if prefix, err := netip.ParsePrefix(proxy); err == nil {
+			if prefix.Contains(addr) {
+				return true
+			}
+			continue
+		}
+
+		if ip, err := netip.ParseAddr(proxy); err == nil && ip.Unmap() == addr {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,122 @@
+package kuromi
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"time"
+)
+
+// AdminSession describes a connected session in the admin API.
+type AdminSession struct {
+	ID            string    `json:"id"`
+	CorrelationID string    `json:"correlation_id"`
+	UserID        string    `json:"user_id,omitempty"`
+	RemoteAddr    string    `json:"remote_addr"`
+	ClientIP      string    `json:"client_ip"`
+	ConnectedAt   time.Time `json:"connected_at"`
+	Uptime        float64   `json:"uptime_seconds"`
+	Rooms         []string  `json:"rooms"`
+	Roles         []string  `json:"roles,omitempty"`
+	Pending       int       `json:"pending"`
+	Stats         Stats     `json:"stats"`
+}
+
+// AdminHandler returns an http.Handler exposing a JSON admin API for operational tooling:
+//
+//	GET    /sessions       lists the connected sessions
+//	DELETE /sessions/{id}  disconnects a session
+//	GET    /rooms          lists the metrics of the rooms
+//	GET    /hub            returns the hub statistics
+//	POST   /broadcast      broadcasts the request body as a text message
+//
+// The handler does no authentication, it must only be mounted behind the
+// application's own access control, e.g. with http.StripPrefix("/admin", ...).
+func (k *Kuromi) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /sessions", k.adminSessions)
+	mux.HandleFunc("DELETE /sessions/{id}", k.adminDisconnect)
+	mux.HandleFunc("GET /rooms", k.adminRooms)
+	mux.HandleFunc("GET /hub", k.adminHub)
+	mux.HandleFunc("POST /broadcast", k.adminBroadcast)
+
+	return mux
+}
+
+func (k *Kuromi) adminSessions(w http.ResponseWriter, r *http.Request) {
+	sessions, err := k.Sessions()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	now := k.now()
+
+	list := make([]AdminSession, 0, len(sessions))
+	for _, s := range sessions {
+		list = append(list, AdminSession{
+			ID:            s.ID(),
+			CorrelationID: s.CorrelationID(),
+			UserID:        s.UserID(),
+			RemoteAddr:    s.RemoteAddr(),
+			ClientIP:      s.ClientIP(),
+			ConnectedAt:   s.ConnectedAt(),
+			Uptime:        now.Sub(s.ConnectedAt()).Seconds(),
+			Rooms:         s.Rooms(),
+			Roles:         s.Roles(),
+			Pending:       s.Pending(),
+			Stats:         s.Stats(),
+		})
+	}
+
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (k *Kuromi) adminDisconnect(w http.ResponseWriter, r *http.Request) {
+	if err := k.Kick(r.PathValue("id"), StatusNormalClosure, ""); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (k *Kuromi) adminRooms(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, k.RoomMetrics())
+}
+
+func (k *Kuromi) adminHub(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, k.HubStats())
+}
+
+func (k *Kuromi) adminBroadcast(w http.ResponseWriter, r *http.Request) {
+	msg, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	k.audit(AuditEvent{Action: AuditBroadcast, ClientIP: clientIP(r.RemoteAddr, r.Header.Values, k.Config), Detail: string(msg)})
+
+	report, err := k.BroadcastReport(msg)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	delivered := 0
+	for _, d := range report {
+		if d.Status == Delivered {
+			delivered++
+		}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]int{"sessions": len(report), "delivered": delivered})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,149 @@
+package kuromi
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+
+	"github.com/coder/websocket"
+)
+
+// Defaults of AESGCM.
+const (
+	DefaultKeyParam  = "kuromi_key"
+	DefaultKeyHeader = "X-Kuromi-Key"
+)
+
+// aesgcmInfo is the HKDF info deriving AES-GCM keys from X25519 shared secrets.
+var aesgcmInfo = []byte("kuromi aes-256-gcm")
+
+// AESGCM is a Transform encrypting messages with AES-256-GCM, with a key per
+// session agreed on during the handshake, independent of where TLS terminates.
+//
+// The client generates an X25519 key pair and passes its public key, base64url
+// encoded without padding, in the KeyParam query parameter. The server answers
+// with its own public key in the KeyHeader response header. Both derive the
+// session key from the shared secret with HKDF-SHA256, an empty salt and the
+// info "kuromi aes-256-gcm".
+//
+// Encrypted messages are binary messages holding a random 12 byte nonce
+// followed by the sealed plaintext, which is the message type (1 for text,
+// 2 for binary) as a single byte followed by the message.
+type AESGCM struct {
+	KeyParam  string // Query parameter carrying the public key of the client, DefaultKeyParam if empty.
+	KeyHeader string // Response header carrying the public key of the server, DefaultKeyHeader if empty.
+}
+
+// Handshake implements Transform.
+func (a AESGCM) Handshake(w http.ResponseWriter, r *http.Request) (SessionTransform, error) {
+	param, header := a.KeyParam, a.KeyHeader
+	if param == "" {
+		param = DefaultKeyParam
+	}
+	if header == "" {
+		header = DefaultKeyHeader
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(r.URL.Query().Get(param))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrKeyExchange, err)
+	}
+
+	peer, err := ecdh.X25519().NewPublicKey(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrKeyExchange, err)
+	}
+
+	priv, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := priv.ECDH(peer)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrKeyExchange, err)
+	}
+
+	aead, err := NewAESGCMCipher(hkdfSHA256(secret, aesgcmInfo))
+	if err != nil {
+		return nil, err
+	}
+
+	w.Header().Set(header, base64.RawURLEncoding.EncodeToString(priv.PublicKey().Bytes()))
+
+	return aead, nil
+}
+
+// AESGCMCipher is the SessionTransform of AESGCM, encrypting messages with a single key.
+type AESGCMCipher struct {
+	aead cipher.AEAD
+}
+
+// NewAESGCMCipher returns a cipher encrypting messages with key, which must be
+// 16, 24 or 32 bytes long, e.g. for clients written in Go.
+func NewAESGCMCipher(key []byte) (*AESGCMCipher, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AESGCMCipher{aead: aead}, nil
+}
+
+// Outbound implements SessionTransform.
+func (c *AESGCMCipher) Outbound(t websocket.MessageType, msg []byte) (websocket.MessageType, []byte, error) {
+	size := c.aead.NonceSize()
+
+	out := make([]byte, size, size+1+len(msg)+c.aead.Overhead())
+	if _, err := rand.Read(out); err != nil {
+		return 0, nil, err
+	}
+
+	plain := append([]byte{byte(t)}, msg...)
+
+	return websocket.MessageBinary, c.aead.Seal(out, out[:size], plain, nil), nil
+}
+
+// Inbound implements SessionTransform.
+func (c *AESGCMCipher) Inbound(t websocket.MessageType, msg []byte) (websocket.MessageType, []byte, error) {
+	size := c.aead.NonceSize()
+
+	if t != websocket.MessageBinary || len(msg) < size {
+		return 0, nil, ErrDecryptFailed
+	}
+
+	plain, err := c.aead.Open(nil, msg[:size], msg[size:], nil)
+	if err != nil || len(plain) == 0 {
+		return 0, nil, ErrDecryptFailed
+	}
+
+	typ := websocket.MessageType(plain[0])
+	if typ != websocket.MessageText && typ != websocket.MessageBinary {
+		return 0, nil, ErrDecryptFailed
+	}
+
+	return typ, plain[1:], nil
+}
+
+// hkdfSHA256 derives a 32 byte key from secret with HKDF-SHA256 and an empty salt.
+func hkdfSHA256(secret, info []byte) []byte {
+	extract := hmac.New(sha256.New, make([]byte, sha256.Size))
+	extract.Write(secret)
+
+	expand := hmac.New(sha256.New, extract.Sum(nil))
+	expand.Write(info)
+	expand.Write([]byte{1})
+
+	return expand.Sum(nil)
+}
@@ -0,0 +1,185 @@
+package kuromi
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Audit actions recorded in addition to the webhook event types.
+const (
+	AuditKick      = "kick"
+	AuditBanUser   = "ban.user"
+	AuditBanIP     = "ban.ip"
+	AuditUnbanUser = "unban.user"
+	AuditUnbanIP   = "unban.ip"
+	AuditBroadcast = "broadcast"
+)
+
+// AuditEvent is a structured record of who did what, see SetAuditSink.
+type AuditEvent struct {
+	Time          time.Time `json:"time"`
+	Action        string    `json:"action"`
+	SessionID     string    `json:"session_id,omitempty"`
+	CorrelationID string    `json:"correlation_id,omitempty"`
+	UserID        string    `json:"user_id,omitempty"`
+	ClientIP      string    `json:"client_ip,omitempty"`
+	Room          string    `json:"room,omitempty"`
+	Detail        string    `json:"detail,omitempty"`
+}
+
+// AuditSink stores audit events, e.g. in a database or log pipeline.
+type AuditSink interface {
+	WriteAudit(ctx context.Context, events []AuditEvent) error
+}
+
+// AuditOptions configures how events are handed to an AuditSink.
+type AuditOptions struct {
+	BatchSize     int                       // Maximum number of events per WriteAudit call, 100 if 0.
+	FlushInterval time.Duration             // Maximum time events wait for a batch to fill up, one second if 0.
+	QueueSize     int                       // Maximum number of events waiting to be written, 10000 if 0.
+	Block         bool                      // Block the recording goroutine instead of dropping events when the queue is full.
+	OnError       func([]AuditEvent, error) // Optional function called with events that were dropped or could not be written.
+}
+
+type auditor struct {
+	sink  AuditSink
+	opts  AuditOptions
+	queue chan AuditEvent
+	done  chan struct{}
+}
+
+type auditLog struct {
+	mu     sync.RWMutex
+	a      *auditor
+	closed bool
+}
+
+// SetAuditSink records connects, disconnects, room joins and leaves and
+// moderation actions like kicks, bans and admin broadcasts to sink. Events are
+// written in batches from a background goroutine.
+func (k *Kuromi) SetAuditSink(sink AuditSink, opts AuditOptions) {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 100
+	}
+
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = time.Second
+	}
+
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = 10000
+	}
+
+	a := &auditor{
+		sink:  sink,
+		opts:  opts,
+		queue: make(chan AuditEvent, opts.QueueSize),
+		done:  make(chan struct{}),
+	}
+
+	k.audits.mu.Lock()
+	defer k.audits.mu.Unlock()
+
+	if k.audits.closed || k.audits.a != nil {
+		return
+	}
+
+	k.audits.a = a
+
+	go a.run()
+}
+
+// audit records ev if an audit sink is set.
+func (k *Kuromi) audit(ev AuditEvent) {
+	k.audits.mu.RLock()
+	defer k.audits.mu.RUnlock()
+
+	a := k.audits.a
+	if a == nil || k.audits.closed {
+		return
+	}
+
+	ev.Time = k.now()
+
+	if a.opts.Block {
+		a.queue <- ev
+		return
+	}
+
+	select {
+	case a.queue <- ev:
+	default:
+		if a.opts.OnError != nil {
+			a.opts.OnError([]AuditEvent{ev}, ErrAuditQueueFull)
+		}
+	}
+}
+
+// auditSession records action about s.
+func (k *Kuromi) auditSession(action string, s *Session, room, detail string) {
+	k.audit(AuditEvent{
+		Action:        action,
+		SessionID:     s.ID(),
+		CorrelationID: s.CorrelationID(),
+		UserID:        s.UserID(),
+		ClientIP:      s.ClientIP(),
+		Room:          room,
+		Detail:        detail,
+	})
+}
+
+// stopAudit writes the queued events and stops recording.
+func (k *Kuromi) stopAudit() {
+	k.audits.mu.Lock()
+	a := k.audits.a
+	closed := k.audits.closed
+	k.audits.closed = true
+	k.audits.mu.Unlock()
+
+	if a == nil || closed {
+		return
+	}
+
+	close(a.queue)
+	<-a.done
+}
+
+func (a *auditor) run() {
+	defer close(a.done)
+
+	ticker := time.NewTicker(a.opts.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]AuditEvent, 0, a.opts.BatchSize)
+
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+
+		if err := a.sink.WriteAudit(context.Background(), batch); err != nil && a.opts.OnError != nil {
+			a.opts.OnError(batch, err)
+		}
+
+		batch = make([]AuditEvent, 0, a.opts.BatchSize)
+	}
+
+	for {
+		select {
+		case ev, ok := <-a.queue:
+			if !ok {
+				flush()
+				return
+			}
+
+			batch = append(batch, ev)
+
+			if len(batch) >= a.opts.BatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
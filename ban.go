@@ -0,0 +1,132 @@
+package kuromi
+
+import (
+	"sync"
+	"time"
+)
+
+// banRegistry keeps temporary bans of IP addresses and users.
+type banRegistry struct {
+	mu    sync.Mutex
+	ips   map[string]time.Time
+	users map[string]time.Time
+}
+
+func newBanRegistry() *banRegistry {
+	return &banRegistry{
+		ips:   make(map[string]time.Time),
+		users: make(map[string]time.Time),
+	}
+}
+
+// ban bans key in set for d, or permanently if d is not positive.
+// A ban is never shortened by a shorter one.
+func (br *banRegistry) ban(set map[string]time.Time, key string, d time.Duration, now time.Time) {
+	br.mu.Lock()
+	defer br.mu.Unlock()
+
+	current, ok := set[key]
+	if ok && current.IsZero() {
+		return
+	}
+
+	if d <= 0 {
+		set[key] = time.Time{}
+		return
+	}
+
+	if until := now.Add(d); until.After(current) {
+		set[key] = until
+	}
+}
+
+func (br *banRegistry) unban(set map[string]time.Time, key string) {
+	br.mu.Lock()
+	defer br.mu.Unlock()
+
+	delete(set, key)
+}
+
+func (br *banRegistry) banned(set map[string]time.Time, key string, now time.Time) bool {
+	br.mu.Lock()
+	defer br.mu.Unlock()
+
+	until, ok := set[key]
+	if !ok {
+		return false
+	}
+
+	if !until.IsZero() && now.After(until) {
+		delete(set, key)
+		return false
+	}
+
+	return true
+}
+
+// Kick closes the session with the id with the given close code and reason.
+func (k *Kuromi) Kick(id string, code StatusCode, reason string) error {
+	s, ok := k.Session(id)
+	if !ok {
+		return ErrSessionNotFound
+	}
+
+	k.auditSession(AuditKick, s, "", reason)
+
+	return s.CloseWithMsg(code, reason)
+}
+
+// BanUser bans the user id for d, or permanently if d is not positive, and
+// closes its sessions. Sessions binding a banned user are closed.
+func (k *Kuromi) BanUser(id string, d time.Duration) {
+	k.bans.ban(k.bans.users, id, d, k.now())
+	k.audit(AuditEvent{Action: AuditBanUser, UserID: id, Detail: banDetail(d)})
+
+	for _, s := range k.users.get(id) {
+		s.CloseWithMsg(StatusPolicyViolation, ErrBanned.Error())
+	}
+}
+
+// UnbanUser lifts the ban of the user id.
+func (k *Kuromi) UnbanUser(id string) {
+	k.bans.unban(k.bans.users, id)
+	k.audit(AuditEvent{Action: AuditUnbanUser, UserID: id})
+}
+
+// IsUserBanned reports whether the user id is banned.
+func (k *Kuromi) IsUserBanned(id string) bool {
+	return k.bans.banned(k.bans.users, id, k.now())
+}
+
+// BanIP bans the client IP address ip for d, or permanently if d is not positive,
+// and closes the sessions connected from it. Requests from a banned IP address
+// are rejected with 403 Forbidden before they are upgraded.
+func (k *Kuromi) BanIP(ip string, d time.Duration) {
+	k.bans.ban(k.bans.ips, ip, d, k.now())
+	k.audit(AuditEvent{Action: AuditBanIP, ClientIP: ip, Detail: banDetail(d)})
+
+	for _, s := range k.hub.all() {
+		if s.ClientIP() == ip {
+			s.CloseWithMsg(StatusPolicyViolation, ErrBanned.Error())
+		}
+	}
+}
+
+// UnbanIP lifts the ban of the IP address ip.
+func (k *Kuromi) UnbanIP(ip string) {
+	k.bans.unban(k.bans.ips, ip)
+	k.audit(AuditEvent{Action: AuditUnbanIP, ClientIP: ip})
+}
+
+// IsIPBanned reports whether the IP address ip is banned.
+func (k *Kuromi) IsIPBanned(ip string) bool {
+	return k.bans.banned(k.bans.ips, ip, k.now())
+}
+
+func banDetail(d time.Duration) string {
+	if d <= 0 {
+		return "permanent"
+	}
+
+	return d.String()
+}
@@ -0,0 +1,54 @@
+package kuromi
+
+import (
+	"sync/atomic"
+
+	"github.com/coder/websocket"
+)
+
+const (
+	queuedPending int32 = iota
+	queuedWritten
+	queuedCanceled
+)
+
+// QueuedMessage is a message written with WriteCancelable or
+// WriteBinaryCancelable, which can be canceled while it is still queued,
+// e.g. a price update superseded by a newer one, so slow consumers receive
+// only the freshest data.
+type QueuedMessage struct {
+	state atomic.Int32
+}
+
+// Cancel drops the message if it is still queued. It returns false if the
+// message is already being written or was canceled. Canceled messages count
+// towards Session.Pending until the write pump skips them.
+func (qm *QueuedMessage) Cancel() bool {
+	return qm.state.CompareAndSwap(queuedPending, queuedCanceled)
+}
+
+// claim marks the message as being written, it reports false if it was canceled.
+func (qm *QueuedMessage) claim() bool {
+	return qm.state.CompareAndSwap(queuedPending, queuedWritten)
+}
+
+// canceled reports whether the message was written with WriteCancelable and canceled.
+func (e envelope) canceled() bool {
+	return e.queued != nil && e.queued.state.Load() == queuedCanceled
+}
+
+// WriteCancelable writes a text message to the session and returns a handle to
+// cancel it while it is queued.
+func (s *Session) WriteCancelable(msg []byte) (*QueuedMessage, error) {
+	qm := &QueuedMessage{}
+
+	return qm, s.write(envelope{t: websocket.MessageText, msg: msg, queued: qm})
+}
+
+// WriteBinaryCancelable writes a binary message to the session and returns a
+// handle to cancel it while it is queued.
+func (s *Session) WriteBinaryCancelable(msg []byte) (*QueuedMessage, error) {
+	qm := &QueuedMessage{}
+
+	return qm, s.write(envelope{t: websocket.MessageBinary, msg: msg, queued: qm})
+}
@@ -0,0 +1,63 @@
+// Package cborcodec implements kuromi.Codec with CBOR (RFC 8949), a compact
+// binary encoding for bandwidth-sensitive clients like embedded devices.
+//
+//	k := kuromi.New()
+//	k.Config.Codec = cborcodec.Codec{}
+//
+// Values are encoded in the core deterministic encoding, so equal values
+// always produce the same bytes.
+package cborcodec
+
+import (
+	"github.com/coder/websocket"
+	"github.com/fshiori/kuromi"
+	"github.com/fxamacker/cbor/v2"
+)
+
+var (
+	encMode, _ = cbor.CoreDetEncOptions().EncMode()
+	decMode, _ = cbor.DecOptions{}.DecMode()
+)
+
+// Codec encodes values as CBOR binary messages.
+type Codec struct{}
+
+// Marshal implements kuromi.Codec.
+func (Codec) Marshal(v any) ([]byte, error) {
+	return encMode.Marshal(v)
+}
+
+// Unmarshal implements kuromi.Codec.
+func (Codec) Unmarshal(data []byte, v any) error {
+	return decMode.Unmarshal(data, v)
+}
+
+// MessageType implements kuromi.Codec.
+func (Codec) MessageType() websocket.MessageType {
+	return websocket.MessageBinary
+}
+
+// WriteCBOR writes v encoded as CBOR to s, regardless of Config.Codec.
+func WriteCBOR(s *kuromi.Session, v any) error {
+	msg, err := encMode.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	return s.WriteBinary(msg)
+}
+
+// BroadcastCBOR broadcasts v encoded as CBOR to all sessions of k, regardless of Config.Codec.
+func BroadcastCBOR(k *kuromi.Kuromi, v any) error {
+	msg, err := encMode.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	return k.BroadcastBinary(msg)
+}
+
+// DecodeCBOR decodes a CBOR message into v.
+func DecodeCBOR(msg []byte, v any) error {
+	return decMode.Unmarshal(msg, v)
+}
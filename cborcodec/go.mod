@@ -0,0 +1,13 @@
+module github.com/fshiori/kuromi/cborcodec
+
+go 1.22.6
+
+require (
+	github.com/coder/websocket v1.8.12
+	github.com/fshiori/kuromi v0.0.0
+	github.com/fxamacker/cbor/v2 v2.7.0
+)
+
+require github.com/x448/float16 v0.8.4 // indirect
+
+replace github.com/fshiori/kuromi => ../
@@ -0,0 +1,153 @@
+// Package chaos wraps kuromi transports to inject faults: latency, dropped and
+// truncated messages and killed connections, according to a seedable policy.
+// It is meant for tests verifying the reconnection and idempotency logic of
+// applications and their clients:
+//
+//	k := kuromi.New()
+//	k.Config.WrapTransport = chaos.Wrapper(chaos.Policy{Seed: 1, DropRate: 0.05})
+package chaos
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/fshiori/kuromi"
+)
+
+// ErrKilled is returned by reads and writes of a connection killed by the policy.
+var ErrKilled = errors.New("chaos: connection killed")
+
+// Policy describes the faults injected into a transport. Rates are
+// probabilities between 0 and 1 applied to every message read or written.
+type Policy struct {
+	Seed         int64         // Seed of the random source, runs with the same seed inject the same faults.
+	Latency      time.Duration // Maximum latency added to every message, the actual latency is random.
+	DropRate     float64       // Probability a message is silently dropped.
+	TruncateRate float64       // Probability a message is truncated to a random length.
+	KillRate     float64       // Probability the connection is closed instead of reading or writing a message.
+}
+
+// source is a random source safe for concurrent use.
+type source struct {
+	mu  sync.Mutex
+	rnd *rand.Rand
+}
+
+func (s *source) float64() float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.rnd.Float64()
+}
+
+func (s *source) int63n(n int64) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.rnd.Int63n(n)
+}
+
+// Wrapper returns a function for kuromi.Config.WrapTransport wrapping every
+// transport with p. All wrapped transports share a random source seeded with p.Seed.
+func Wrapper(p Policy) func(kuromi.Transport) kuromi.Transport {
+	src := &source{rnd: rand.New(rand.NewSource(p.Seed))}
+
+	return func(t kuromi.Transport) kuromi.Transport {
+		return &transport{Transport: t, policy: p, src: src}
+	}
+}
+
+// Wrap wraps t with p.
+func Wrap(t kuromi.Transport, p Policy) kuromi.Transport {
+	return Wrapper(p)(t)
+}
+
+type transport struct {
+	kuromi.Transport
+	policy Policy
+	src    *source
+
+	mu     sync.Mutex
+	killed bool
+}
+
+// Unwrap returns the wrapped transport.
+func (t *transport) Unwrap() kuromi.Transport {
+	return t.Transport
+}
+
+func (t *transport) Read(ctx context.Context) (websocket.MessageType, []byte, error) {
+	for {
+		if err := t.fault(ctx); err != nil {
+			return 0, nil, err
+		}
+
+		typ, p, err := t.Transport.Read(ctx)
+		if err != nil {
+			return typ, p, err
+		}
+
+		if t.chance(t.policy.DropRate) {
+			continue
+		}
+
+		return typ, t.truncate(p), nil
+	}
+}
+
+func (t *transport) Write(ctx context.Context, typ websocket.MessageType, p []byte) error {
+	if err := t.fault(ctx); err != nil {
+		return err
+	}
+
+	if t.chance(t.policy.DropRate) {
+		return nil
+	}
+
+	return t.Transport.Write(ctx, typ, t.truncate(p))
+}
+
+// fault delays the caller and kills the connection according to the policy.
+func (t *transport) fault(ctx context.Context) error {
+	if t.policy.Latency > 0 {
+		timer := time.NewTimer(time.Duration(t.src.int63n(int64(t.policy.Latency))))
+		defer timer.Stop()
+
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.killed {
+		return ErrKilled
+	}
+
+	if t.chance(t.policy.KillRate) {
+		t.killed = true
+		t.Transport.Close(websocket.StatusGoingAway, "chaos")
+		return ErrKilled
+	}
+
+	return nil
+}
+
+func (t *transport) truncate(p []byte) []byte {
+	if len(p) == 0 || !t.chance(t.policy.TruncateRate) {
+		return p
+	}
+
+	return p[:t.src.int63n(int64(len(p)))]
+}
+
+func (t *transport) chance(rate float64) bool {
+	return rate > 0 && t.src.float64() < rate
+}
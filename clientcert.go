@@ -0,0 +1,74 @@
+package kuromi
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+)
+
+// CertificateIdentity holds the identity fields of a client certificate.
+type CertificateIdentity struct {
+	CommonName         string
+	Organization       []string
+	OrganizationalUnit []string
+	DNSNames           []string
+	EmailAddresses     []string
+	URIs               []string
+	SerialNumber       string // Decimal serial number.
+	Fingerprint        string // Hex encoded SHA-256 hash of the DER encoded certificate.
+}
+
+// ClientCertificateChain returns the verified certificate chain of the client
+// of the session, starting with the client certificate, or nil if the session
+// did not connect over TLS with a verified client certificate. The server's
+// tls.Config must set ClientAuth to VerifyClientCertIfGiven or
+// RequireAndVerifyClientCert for client certificates to be verified.
+func (s *Session) ClientCertificateChain() []*x509.Certificate {
+	if s.Request == nil || s.Request.TLS == nil || len(s.Request.TLS.VerifiedChains) == 0 {
+		return nil
+	}
+
+	return s.Request.TLS.VerifiedChains[0]
+}
+
+// ClientCertificate returns the verified client certificate of the session, or
+// nil if there is none, see ClientCertificateChain.
+func (s *Session) ClientCertificate() *x509.Certificate {
+	if chain := s.ClientCertificateChain(); len(chain) > 0 {
+		return chain[0]
+	}
+
+	return nil
+}
+
+// ClientIdentity returns the identity fields of the verified client certificate
+// of the session, ie: (identity, true), or (CertificateIdentity{}, false) if there is none.
+func (s *Session) ClientIdentity() (CertificateIdentity, bool) {
+	cert := s.ClientCertificate()
+	if cert == nil {
+		return CertificateIdentity{}, false
+	}
+
+	return IdentityOf(cert), true
+}
+
+// IdentityOf returns the identity fields of cert.
+func IdentityOf(cert *x509.Certificate) CertificateIdentity {
+	sum := sha256.Sum256(cert.Raw)
+
+	id := CertificateIdentity{
+		CommonName:         cert.Subject.CommonName,
+		Organization:       cert.Subject.Organization,
+		OrganizationalUnit: cert.Subject.OrganizationalUnit,
+		DNSNames:           cert.DNSNames,
+		EmailAddresses:     cert.EmailAddresses,
+		SerialNumber:       cert.SerialNumber.String(),
+		Fingerprint:        hex.EncodeToString(sum[:]),
+	}
+
+	for _, u := range cert.URIs {
+		id.URIs = append(id.URIs, u.String())
+	}
+
+	return id
+}
@@ -0,0 +1,65 @@
+package kuromi
+
+import "time"
+
+// Clock is the source of time of a kuromi instance: ping periods, message and
+// key TTLs, timers and timestamps of sessions. Tests can set Config.Clock to a
+// fake clock, e.g. kuromitest.Clock, to advance time without sleeping.
+type Clock interface {
+	Now() time.Time
+	NewTimer(d time.Duration) Timer
+	NewTicker(d time.Duration) Ticker
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Timer is a timer created by a Clock, like time.Timer.
+// Timers created with AfterFunc have a nil channel.
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+// Ticker is a ticker created by a Clock, like time.Ticker.
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+	Reset(d time.Duration)
+}
+
+// SystemClock is the Clock backed by the time package, used when Config.Clock is nil.
+var SystemClock Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+func (systemClock) NewTimer(d time.Duration) Timer { return systemTimer{time.NewTimer(d)} }
+
+func (systemClock) NewTicker(d time.Duration) Ticker { return systemTicker{time.NewTicker(d)} }
+
+func (systemClock) AfterFunc(d time.Duration, f func()) Timer {
+	return systemTimer{time.AfterFunc(d, f)}
+}
+
+type systemTimer struct{ *time.Timer }
+
+func (t systemTimer) C() <-chan time.Time { return t.Timer.C }
+
+type systemTicker struct{ *time.Ticker }
+
+func (t systemTicker) C() <-chan time.Time { return t.Ticker.C }
+
+// clock returns the clock of k.
+func (k *Kuromi) clock() Clock {
+	if k.Config.Clock != nil {
+		return k.Config.Clock
+	}
+
+	return SystemClock
+}
+
+// now returns the current time of the clock of k.
+func (k *Kuromi) now() time.Time {
+	return k.clock().Now()
+}
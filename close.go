@@ -0,0 +1,64 @@
+package kuromi
+
+import (
+	"errors"
+
+	"github.com/coder/websocket"
+)
+
+// StatusCode represents a WebSocket close status code.
+type StatusCode = websocket.StatusCode
+
+// CloseError is returned by reads when the session sent a close frame.
+type CloseError = websocket.CloseError
+
+// Close status codes defined in RFC 6455, section 7.4.1.
+const (
+	StatusNormalClosure           = websocket.StatusNormalClosure
+	StatusGoingAway               = websocket.StatusGoingAway
+	StatusProtocolError           = websocket.StatusProtocolError
+	StatusUnsupportedData         = websocket.StatusUnsupportedData
+	StatusNoStatusRcvd            = websocket.StatusNoStatusRcvd
+	StatusAbnormalClosure         = websocket.StatusAbnormalClosure
+	StatusInvalidFramePayloadData = websocket.StatusInvalidFramePayloadData
+	StatusPolicyViolation         = websocket.StatusPolicyViolation
+	StatusMessageTooBig           = websocket.StatusMessageTooBig
+	StatusMandatoryExtension      = websocket.StatusMandatoryExtension
+	StatusInternalError           = websocket.StatusInternalError
+	StatusServiceRestart          = websocket.StatusServiceRestart
+	StatusTryAgainLater           = websocket.StatusTryAgainLater
+	StatusBadGateway              = websocket.StatusBadGateway
+)
+
+// CloseStatus returns the status code of the close frame that caused err, or -1
+// if err was not caused by a close frame.
+func CloseStatus(err error) StatusCode {
+	return websocket.CloseStatus(err)
+}
+
+// IsCloseError reports whether err was caused by a close frame with one of codes.
+// If no codes are given it reports whether err was caused by any close frame.
+func IsCloseError(err error, codes ...StatusCode) bool {
+	var ce CloseError
+	if !errors.As(err, &ce) {
+		return false
+	}
+
+	if len(codes) == 0 {
+		return true
+	}
+
+	for _, code := range codes {
+		if ce.Code == code {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IsNormalClosure reports whether err was caused by the session closing normally
+// or going away, i.e. an expected disconnect rather than a failure.
+func IsNormalClosure(err error) bool {
+	return IsCloseError(err, StatusNormalClosure, StatusGoingAway, StatusNoStatusRcvd)
+}
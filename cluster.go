@@ -0,0 +1,295 @@
+package kuromi
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coder/websocket"
+)
+
+// Broker carries messages between the nodes of a cluster, e.g. on top of Redis
+// pub/sub or NATS, see Config.Broker.
+type Broker interface {
+	// Publish sends msg to the subscribers of channel on all nodes.
+	Publish(ctx context.Context, channel string, msg []byte) error
+	// Subscribe calls fn with the messages published to channel until
+	// unsubscribe is called. fn must not block for long.
+	Subscribe(channel string, fn func(msg []byte)) (unsubscribe func(), err error)
+}
+
+// clusterState is the membership of a node in the cluster.
+type clusterState struct {
+	once  sync.Once
+	err   error
+	stops []func()
+	quit  chan struct{}
+
+	mu       sync.Mutex
+	seen     map[string]time.Time
+	ring     *hashRing
+	presence map[string]map[string][]Presence // Presence of the owned rooms by node.
+	pending  map[uint64]chan []Presence
+	nextReq  uint64
+}
+
+// Kinds of node messages.
+const (
+	nodeSend          = ""
+	nodeHeartbeat     = "heartbeat"
+	nodeLeave         = "leave"
+	nodeHistory       = "history"
+	nodeReplay        = "replay"
+	nodeHandoff       = "handoff"
+	nodePresence      = "presence"
+	nodePresenceQuery = "presence_query"
+	nodePresenceReply = "presence_reply"
+)
+
+// nodeMessage is a message exchanged by the nodes of a cluster through the broker.
+type nodeMessage struct {
+	Kind     string                `json:"kind,omitempty"`
+	From     string                `json:"from,omitempty"`
+	Session  string                `json:"session,omitempty"`
+	Room     string                `json:"room,omitempty"`
+	Type     websocket.MessageType `json:"type,omitempty"`
+	Data     []byte                `json:"data,omitempty"`
+	History  []nodeMessage         `json:"history,omitempty"`
+	Presence []Presence            `json:"presence,omitempty"`
+	Request  uint64                `json:"request,omitempty"`
+}
+
+// clusterChannel is the broker channel of the cluster membership.
+const clusterChannel = "kuromi.cluster"
+
+// nodeChannel returns the broker channel of the node id.
+func nodeChannel(id string) string {
+	return "kuromi.node." + id
+}
+
+// joinCluster subscribes the node to the broker on first use.
+func (k *Kuromi) joinCluster() error {
+	if k.Config.Broker == nil {
+		return nil
+	}
+
+	c := &k.cluster
+	joined := false
+
+	c.once.Do(func() {
+		c.seen = map[string]time.Time{k.Config.NodeID: k.now()}
+		c.ring = newHashRing([]string{k.Config.NodeID})
+		c.presence = make(map[string]map[string][]Presence)
+		c.pending = make(map[uint64]chan []Presence)
+		c.quit = make(chan struct{})
+
+		for channel, fn := range map[string]func([]byte){
+			nodeChannel(k.Config.NodeID): k.receiveNodeMessage,
+			clusterChannel:               k.receiveNodeMessage,
+		} {
+			stop, err := k.Config.Broker.Subscribe(channel, fn)
+			if err != nil {
+				for _, stop := range c.stops {
+					stop()
+				}
+
+				c.stops = nil
+				c.err = err
+
+				return
+			}
+
+			c.stops = append(c.stops, stop)
+		}
+
+		joined = true
+	})
+
+	// Announced outside of once, as other nodes answering synchronously
+	// would otherwise call joinCluster recursively.
+	if joined {
+		k.publishNode(clusterChannel, nodeMessage{Kind: nodeHeartbeat})
+
+		go k.heartbeat()
+	}
+
+	return c.err
+}
+
+// clustered reports whether the node is a member of a cluster.
+func (k *Kuromi) clustered() bool {
+	return k.Config.Broker != nil && k.joinCluster() == nil
+}
+
+// leaveCluster announces the node is leaving and unsubscribes it from the broker.
+func (k *Kuromi) leaveCluster() {
+	c := &k.cluster
+	joined := false
+
+	c.once.Do(func() {})
+
+	c.mu.Lock()
+	if c.quit != nil && c.err == nil {
+		select {
+		case <-c.quit:
+		default:
+			close(c.quit)
+			joined = true
+		}
+	}
+	c.mu.Unlock()
+
+	if !joined {
+		return
+	}
+
+	k.publishNode(clusterChannel, nodeMessage{Kind: nodeLeave})
+
+	for _, stop := range c.stops {
+		stop()
+	}
+}
+
+// sendNode sends m to node, handling it directly if node is the local node.
+func (k *Kuromi) sendNode(node string, m nodeMessage) error {
+	if node == k.Config.NodeID {
+		m.From = node
+		k.handleNodeMessage(m)
+		return nil
+	}
+
+	return k.publishNode(nodeChannel(node), m)
+}
+
+func (k *Kuromi) publishNode(channel string, m nodeMessage) error {
+	m.From = k.Config.NodeID
+
+	msg, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	return k.Config.Broker.Publish(context.Background(), channel, msg)
+}
+
+func (k *Kuromi) receiveNodeMessage(msg []byte) {
+	var m nodeMessage
+	if err := json.Unmarshal(msg, &m); err != nil {
+		return
+	}
+
+	k.handleNodeMessage(m)
+}
+
+func (k *Kuromi) handleNodeMessage(m nodeMessage) {
+	switch m.Kind {
+	case nodeSend:
+		if s, ok := k.Session(m.Session); ok {
+			s.write(envelope{t: m.Type, msg: m.Data})
+		}
+	case nodeHeartbeat:
+		k.nodeSeen(m.From)
+	case nodeLeave:
+		k.nodeGone(m.From)
+	default:
+		k.handleRoomMessage(m)
+	}
+}
+
+// nodeOf returns the node encoded in the session id, empty for sessions of
+// instances without Config.NodeID.
+func nodeOf(id string) string {
+	node, _, ok := strings.Cut(id, ":")
+	if !ok {
+		return ""
+	}
+
+	return node
+}
+
+// SendToSession writes a text message to the session with the global id. With
+// Config.Broker set, the id encodes the node owning the session, see Session.ID,
+// and messages to sessions of other nodes are routed through the broker, so
+// any node can message any connected client. Delivery to other nodes is not
+// confirmed. ErrSessionNotFound is returned if the session is local and not connected.
+func (k *Kuromi) SendToSession(id string, msg []byte) error {
+	return k.sendToSession(id, envelope{t: websocket.MessageText, msg: msg})
+}
+
+// SendToSessionBinary writes a binary message to the session with the global id, see SendToSession.
+func (k *Kuromi) SendToSessionBinary(id string, msg []byte) error {
+	return k.sendToSession(id, envelope{t: websocket.MessageBinary, msg: msg})
+}
+
+func (k *Kuromi) sendToSession(id string, message envelope) error {
+	if k.hub.closed() {
+		return ErrClosed
+	}
+
+	node := nodeOf(id)
+
+	if node == k.Config.NodeID || k.Config.Broker == nil {
+		s, ok := k.Session(id)
+		if !ok {
+			return ErrSessionNotFound
+		}
+
+		return s.write(message)
+	}
+
+	return k.publishNode(nodeChannel(node), nodeMessage{Session: id, Type: message.t, Data: message.msg})
+}
+
+// MemoryBroker is a Broker connecting kuromi instances in a single process, e.g. in tests.
+type MemoryBroker struct {
+	mu     sync.RWMutex
+	nextID uint64
+	subs   map[string]map[uint64]func([]byte)
+}
+
+// NewMemoryBroker returns an empty MemoryBroker.
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{subs: make(map[string]map[uint64]func([]byte))}
+}
+
+// Publish implements Broker. Subscribers are called synchronously.
+func (b *MemoryBroker) Publish(ctx context.Context, channel string, msg []byte) error {
+	b.mu.RLock()
+	fns := make([]func([]byte), 0, len(b.subs[channel]))
+	for _, fn := range b.subs[channel] {
+		fns = append(fns, fn)
+	}
+	b.mu.RUnlock()
+
+	for _, fn := range fns {
+		fn(append([]byte(nil), msg...))
+	}
+
+	return nil
+}
+
+// Subscribe implements Broker.
+func (b *MemoryBroker) Subscribe(channel string, fn func([]byte)) (func(), error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.nextID++
+	id := b.nextID
+
+	if b.subs[channel] == nil {
+		b.subs[channel] = make(map[uint64]func([]byte))
+	}
+	b.subs[channel][id] = fn
+
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		delete(b.subs[channel], id)
+		if len(b.subs[channel]) == 0 {
+			delete(b.subs, channel)
+		}
+	}, nil
+}
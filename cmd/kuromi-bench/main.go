@@ -0,0 +1,49 @@
+// Command kuromi-bench opens concurrent websocket connections against a
+// kuromi server, sends messages at a fixed rate and reports latency
+// percentiles and error rates.
+//
+// Latency is measured for messages the server writes back, e.g. by an echo
+// or broadcast handler:
+//
+//	kuromi-bench -url ws://localhost:5000/ws -c 100 -rate 10 -size 128 -d 30s
+//
+// The load is generated with the loadgen package, which can be used to write
+// the same tests in Go.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/fshiori/kuromi/loadgen"
+)
+
+func main() {
+	var cfg loadgen.Config
+
+	flag.StringVar(&cfg.URL, "url", "ws://localhost:5000/ws", "websocket url of the kuromi server")
+	flag.IntVar(&cfg.Connections, "c", 10, "number of concurrent connections")
+	flag.IntVar(&cfg.Senders, "senders", 0, "number of connections sending messages, 0 means all")
+	flag.Float64Var(&cfg.Rate, "rate", 1, "messages per second per sending connection, 0 only connects")
+	flag.IntVar(&cfg.Size, "size", 64, "message size in bytes")
+	flag.DurationVar(&cfg.Duration, "d", 10*time.Second, "duration of the test")
+	flag.DurationVar(&cfg.Ramp, "ramp", 0, "time over which connections are opened")
+	flag.DurationVar(&cfg.Lifetime, "lifetime", 0, "reconnect connections after this long, 0 keeps them open")
+	flag.BoolVar(&cfg.Binary, "binary", false, "send binary instead of text messages")
+	flag.Parse()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	res, err := loadgen.Run(ctx, cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	fmt.Print(res)
+}
@@ -0,0 +1,72 @@
+package kuromi
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/coder/websocket"
+)
+
+// Codec encodes values written with WriteValue and BroadcastValue and decodes
+// messages sent by sessions, see Config.Codec.
+type Codec interface {
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+	MessageType() websocket.MessageType // Type of the messages produced by Marshal.
+}
+
+// JSONCodec encodes values as JSON text messages.
+type JSONCodec struct{}
+
+// Marshal implements Codec.
+func (JSONCodec) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+// Unmarshal implements Codec.
+func (JSONCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+// MessageType implements Codec.
+func (JSONCodec) MessageType() websocket.MessageType {
+	return websocket.MessageText
+}
+
+// codec returns Config.Codec, or JSONCodec if it is not set.
+func (k *Kuromi) codec() Codec {
+	if k.Config.Codec != nil {
+		return k.Config.Codec
+	}
+
+	return JSONCodec{}
+}
+
+// WriteValue writes v encoded with Config.Codec, JSON by default, to the session.
+func (s *Session) WriteValue(v any) error {
+	c := s.kuromi.codec()
+
+	msg, err := c.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	return s.write(envelope{t: c.MessageType(), msg: msg})
+}
+
+// Decode decodes msg received from the session into v with Config.Codec, JSON by default.
+func (s *Session) Decode(msg []byte, v any) error {
+	return s.kuromi.codec().Unmarshal(msg, v)
+}
+
+// BroadcastValue broadcasts v encoded with Config.Codec, JSON by default, to all sessions.
+func (k *Kuromi) BroadcastValue(v any) error {
+	c := k.codec()
+
+	msg, err := c.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	return k.broadcast(context.Background(), envelope{t: c.MessageType(), msg: msg})
+}
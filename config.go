@@ -4,20 +4,95 @@ import "time"
 
 // Config kuromi configuration struct.
 type Config struct {
-	WriteWait                 time.Duration // Duration until write times out.
-	PongWait                  time.Duration // Timeout for waiting on pong.
-	PingPeriod                time.Duration // Duration between pings.
-	MaxMessageSize            int64         // Maximum size in bytes of a message.
-	MessageBufferSize         int           // The max amount of messages that can be in a sessions buffer before it starts dropping them.
-	ConcurrentMessageHandling bool          // Handle messages from sessions concurrently.
+	WriteWait                 time.Duration                 // Duration until write times out.
+	Clock                     Clock                         // Optional source of time for pings, TTLs, bans and timers, e.g. a fake clock in tests. Defaults to SystemClock.
+	WriteWatchdogGrace        time.Duration                 // Time after WriteWait a write or ping may still block before the connection is force-closed, 0 disables the watchdog.
+	PongWait                  time.Duration                 // Timeout for waiting on pong.
+	PingPeriod                time.Duration                 // Duration between pings.
+	PingPayload               func(*Session) []byte         // Optional payload of each ping, e.g. a timestamp, written after each ping and echoed by clients to HandlePong, see PingPrefix.
+	MaxPingFailures           int                           // Consecutive failed pings after which a session is closed, 0 or 1 closes it on the first failure.
+	MaxMessageSize            int64                         // Maximum size in bytes of a message.
+	MessageBufferSize         int                           // The max amount of messages that can be in a sessions buffer before it starts dropping them.
+	BulkBufferSize            int                           // The max amount of PriorityBulk messages queued for a session, 0 queues them with other messages.
+	MessageHeaders            bool                          // Frame messages written with headers and parse the headers of incoming messages, see HeaderPrefix.
+	CorrelationHeader         string                        // Request header sessions take their correlation ID from, see Session.CorrelationID. Empty always generates one.
+	ContextKeys               map[string]any                // Values of the upgrade request context, e.g. set by auth middleware, copied into Session.Keys under the given names. Keys passed to HandleRequestWithKeys take precedence.
+	ConcurrentMessageHandling bool                          // Handle messages from sessions concurrently.
+	MessageHandlerWorkers     int                           // Number of workers handling messages concurrently, 0 spawns a goroutine per message.
+	MessageHandlerQueueSize   int                           // The max amount of messages waiting for a worker before they are dropped.
+	OrderedMessageHandling    bool                          // Handle messages of the same session (or ordering key) one at a time and in order when using workers.
+	MessageOrderingKey        func(*Session, []byte) string // Optional key used instead of the session to order messages when OrderedMessageHandling is set.
+	MessageHandlerTimeout     time.Duration                 // Deadline for handling a single message, 0 disables it. Handlers exceeding it keep running but no longer block the session.
+	CloseOnHandlerTimeout     bool                          // Close the session when a message handler exceeds MessageHandlerTimeout.
+	NamespaceParam            string                        // URL query parameter used by sessions to select a namespace, empty disables namespaces.
+	EnableSSE                 bool                          // Serve requests accepting text/event-stream as write-only Server-Sent Events sessions.
+	BroadcastPresence         bool                          // Send presence diffs as JSON text messages to the members of a room when sessions join or leave it.
+	RoomHistorySize           int                           // Number of messages broadcast to a room that are kept and replayed to sessions joining it, 0 disables room history.
+	RoomHistoryTTL            time.Duration                 // How long the history of a room is kept after the last message broadcast to it, 0 keeps it until ClearRoomHistory.
+	ResumeGracePeriod         time.Duration                 // How long the state of a disconnected session is kept for the client to resume it, 0 disables resumable sessions.
+	ResumeParam               string                        // URL query parameter used by clients to pass the resume token of a disconnected session.
+	Outbox                    OutboxStore                   // Optional store queueing messages sent with SendToUser to disconnected users until they connect.
+	OutboxTTL                 time.Duration                 // How long messages are kept in Outbox, 0 keeps them until they are delivered.
+	SequenceMessages          bool                          // Prefix messages written to sessions with a sequence number and handle resend requests, see ResendPrefix.
+	RetransmitBufferSize      int                           // Number of messages per session kept for resend requests when SequenceMessages is set.
+	AckTimeout                time.Duration                 // How long BroadcastWithAck waits for sessions to confirm a message before writing it again.
+	AckRetries                int                           // How many times BroadcastWithAck writes a message again to sessions that did not confirm it.
+	MessageTTL                time.Duration                 // Default time queued messages are kept before they are dropped instead of written, 0 keeps them until they are written.
+	WrapTransport             func(Transport) Transport     // Optional function wrapping the transport of every session, e.g. to inject faults in tests.
+	TrustedProxies            []string                      // IP addresses and CIDR prefixes of proxies whose ClientIPHeaders are trusted by Session.ClientIP.
+	ClientIPHeaders           []string                      // Request headers carrying the client IP set by trusted proxies, in order of preference.
+	CompressionThreshold      int                           // Minimum size in bytes of messages compressed with permessage-deflate, 0 leaves compression to AcceptOptions.
+	Codec                     Codec                         // Codec used to encode and decode values, JSON if not set. When set, messages it cannot decode are invalid.
+	InvalidMessagePolicy      InvalidMessagePolicy          // What happens to malformed messages sent by sessions.
+	FloodMessageRate          float64                       // Messages per second a session may send on average before it is penalized, 0 disables the limit.
+	FloodBurst                int                           // Messages a session may send at once when FloodMessageRate is set.
+	FloodMaxErrors            int                           // Errors caused by a session, e.g. rejected messages, allowed within FloodWindow before it is penalized, 0 disables the limit.
+	FloodWindow               time.Duration                 // Window in which errors are counted for FloodMaxErrors.
+	FloodBanDuration          time.Duration                 // How long the user or client IP of a penalized session is banned by default.
+	SlowConsumerHighWater     int                           // Queued messages above which a session is considered slow, 0 disables slow consumer detection.
+	SlowConsumerThreshold     time.Duration                 // How long the queue of a session must stay above SlowConsumerHighWater before the slow consumer handler fires.
+	MaxWriteFailures          int                           // Consecutive write failures after which a session stops writing and is closed, 0 or 1 closes it on the first failure.
+	WriteRetries              int                           // Times a write that failed with a transient error, e.g. a timeout, is retried before it fails, 0 disables retries.
+	WriteRetryBackoff         time.Duration                 // Delay before the first write retry, doubled for every following retry.
+	BroadcastWorkers          int                           // Number of goroutines queueing a broadcast for large numbers of sessions, 0 or 1 queues it from the hub alone. Broadcast filters must be safe for concurrent use.
+	BroadcastRate             float64                       // Broadcasts per second allowed for the whole instance, 0 disables the limit.
+	BroadcastBurst            int                           // Broadcasts allowed at once when BroadcastRate is set, 0 allows one second worth.
+	BroadcastByteRate         float64                       // Broadcast bytes per second allowed for the whole instance, 0 disables the limit.
+	BroadcastByteBurst        int                           // Broadcast bytes allowed at once when BroadcastByteRate is set, 0 allows one second worth.
+	BroadcastLimitPolicy      BroadcastLimitPolicy          // What happens to broadcasts exceeding BroadcastRate or BroadcastByteRate.
+	EgressByteRate            float64                       // Bytes per second written to a session at most, 0 disables the limit. See Session.SetEgressRate.
+	EgressByteBurst           int                           // Bytes written to a session at once when its egress rate is limited, 0 allows one second worth.
+	SessionCookie             *SessionCookie                // Optional signed cookie set during the upgrade to correlate the sessions of a browser, see Session.CookieID.
+	ShutdownTimeout           time.Duration                 // How long a shutdown triggered through AttachToServer waits for sessions to disconnect.
+	Transforms                []Transform                   // Stages transforming messages written to sessions in order and messages sent by sessions in reverse order, e.g. AESGCM.
+	Broker                    Broker                        // Optional broker connecting the nodes of a cluster, see SendToSession.
+	NodeID                    string                        // Identifier of the node in a cluster, encoded in session IDs. Required with Broker.
+	ClusterHeartbeat          time.Duration                 // How often a node announces itself to the cluster. Nodes silent for three heartbeats are considered gone.
+	VersionSubprotocol        string                        // Prefix of the subprotocols negotiating a protocol version, e.g. "chat.v" offers "chat.v2" for Kuromi.Version("2"). Empty disables it.
+	VersionHandshake          bool                          // Require sessions without a version negotiated by subprotocol to send the versions they support as first message, see VersionPrefix.
 }
 
 func newConfig() *Config {
 	return &Config{
-		WriteWait:         10 * time.Second,
-		PongWait:          60 * time.Second,
-		PingPeriod:        54 * time.Second,
-		MaxMessageSize:    512,
-		MessageBufferSize: 256,
+		WriteWait:               10 * time.Second,
+		WriteWatchdogGrace:      5 * time.Second,
+		PongWait:                60 * time.Second,
+		PingPeriod:              54 * time.Second,
+		MaxMessageSize:          512,
+		MessageBufferSize:       256,
+		MessageHandlerQueueSize: 256,
+		NamespaceParam:          "namespace",
+		ResumeParam:             "resume",
+		CorrelationHeader:       "X-Correlation-ID",
+		AckTimeout:              5 * time.Second,
+		AckRetries:              2,
+		ClientIPHeaders:         []string{"X-Forwarded-For", "X-Real-IP"},
+		FloodBurst:              20,
+		FloodWindow:             time.Minute,
+		FloodBanDuration:        5 * time.Minute,
+		SlowConsumerThreshold:   5 * time.Second,
+		WriteRetryBackoff:       50 * time.Millisecond,
+		ShutdownTimeout:         10 * time.Second,
+		ClusterHeartbeat:        time.Second,
 	}
 }
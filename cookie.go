@@ -0,0 +1,97 @@
+package kuromi
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// defaultCookieName is the name of the session cookie if SessionCookie.Name is empty.
+const defaultCookieName = "kuromi_id"
+
+// SessionCookie configures a signed cookie set during the upgrade, which
+// identifies the browser of a session across reconnects, see Session.CookieID.
+type SessionCookie struct {
+	Name     string        // Name of the cookie, "kuromi_id" if empty.
+	Secret   []byte        // Key signing the cookie with HMAC-SHA256, cookies with an invalid signature are replaced.
+	MaxAge   time.Duration // Lifetime of the cookie, 0 makes it a browser session cookie.
+	Path     string        // Path of the cookie, "/" if empty.
+	Domain   string
+	Secure   bool
+	SameSite http.SameSite
+}
+
+func (sc *SessionCookie) name() string {
+	if sc.Name == "" {
+		return defaultCookieName
+	}
+
+	return sc.Name
+}
+
+func (sc *SessionCookie) sign(id string) string {
+	mac := hmac.New(sha256.New, sc.Secret)
+	mac.Write([]byte(id))
+
+	return id + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verify returns the id of a signed cookie value, ie: (id, true).
+func (sc *SessionCookie) verify(value string) (string, bool) {
+	id, _, ok := strings.Cut(value, ".")
+	if !ok || id == "" {
+		return "", false
+	}
+
+	return id, hmac.Equal([]byte(value), []byte(sc.sign(id)))
+}
+
+// bindCookie returns the id of the valid session cookie sent with r, or sets a
+// new cookie on w and returns its id. It returns an empty string if
+// Config.SessionCookie is not set.
+func (k *Kuromi) bindCookie(w http.ResponseWriter, r *http.Request) string {
+	sc := k.Config.SessionCookie
+	if sc == nil {
+		return ""
+	}
+
+	if c, err := r.Cookie(sc.name()); err == nil {
+		if id, ok := sc.verify(c.Value); ok {
+			return id
+		}
+	}
+
+	id := newResumeToken()
+
+	cookie := &http.Cookie{
+		Name:     sc.name(),
+		Value:    sc.sign(id),
+		Path:     sc.Path,
+		Domain:   sc.Domain,
+		Secure:   sc.Secure,
+		HttpOnly: true,
+		SameSite: sc.SameSite,
+	}
+
+	if cookie.Path == "" {
+		cookie.Path = "/"
+	}
+
+	if sc.MaxAge > 0 {
+		cookie.MaxAge = int(sc.MaxAge.Seconds())
+	}
+
+	http.SetCookie(w, cookie)
+
+	return id
+}
+
+// CookieID returns the id stored in the signed session cookie of the session,
+// which is the same for all sessions of a browser while the cookie is kept,
+// or an empty string if Config.SessionCookie is not set.
+func (s *Session) CookieID() string {
+	return s.cookieID
+}
@@ -0,0 +1,64 @@
+package kuromi
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"strconv"
+)
+
+// CorrelationHeaderKey is the message header carrying the correlation ID of a
+// message, see Config.MessageHeaders.
+const CorrelationHeaderKey = "correlation-id"
+
+// maxCorrelationID is the longest correlation ID taken from an upgrade request.
+const maxCorrelationID = 128
+
+// newCorrelationID returns the correlation ID of a session whose upgrade request
+// has header as Config.CorrelationHeader, or a random ID if it is empty or too long.
+func newCorrelationID(header string) string {
+	if header != "" && len(header) <= maxCorrelationID {
+		return header
+	}
+
+	b := make([]byte, 8)
+	rand.Read(b)
+
+	return hex.EncodeToString(b)
+}
+
+// CorrelationID returns the correlation ID of the session, taken from the
+// Config.CorrelationHeader of the upgrade request or generated, to follow a
+// session through logs, audit events and webhooks of several services.
+func (s *Session) CorrelationID() string {
+	return s.correlationID
+}
+
+// messageCorrelationID returns the correlation ID of a message sent by the
+// session: its correlation-id header, or the correlation ID of the session
+// followed by the number of the message.
+func (s *Session) messageCorrelationID(header Header) string {
+	if id := header.Get(CorrelationHeaderKey); id != "" {
+		return id
+	}
+
+	return s.correlationID + "." + strconv.FormatUint(s.messages.Add(1), 10)
+}
+
+type correlationKey struct{}
+
+// CorrelationIDFromContext returns the correlation ID of the message passed to
+// a message handler with ctx, or an empty string if ctx is not such a context.
+func CorrelationIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(correlationKey{}).(string)
+
+	return id
+}
+
+// messageContext returns the context passed to the message handler with a
+// message of the session with header.
+func (s *Session) messageContext(header Header) context.Context {
+	ctx := context.WithValue(context.Background(), correlationKey{}, s.messageCorrelationID(header))
+
+	return withHeader(ctx, header)
+}
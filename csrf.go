@@ -0,0 +1,50 @@
+package kuromi
+
+import (
+	"crypto/subtle"
+	"net/http"
+)
+
+// CheckCSRF sets fn to decide whether a request is a legitimate upgrade or a
+// cross-site request, checked before the upgrade. Browsers send cookies with
+// cross-site websocket handshakes, so endpoints authenticating sessions with
+// cookies are open to cross-site websocket hijacking without such a check.
+// Rejected requests are answered with 403 Forbidden. See SecFetchSite and
+// DoubleSubmit for common checks.
+func (k *Kuromi) CheckCSRF(fn func(*http.Request) bool) {
+	k.csrfCheck = fn
+}
+
+// SecFetchSite returns a CSRF check rejecting browser requests whose
+// Sec-Fetch-Site header is cross-site, and same-site unless allowSameSite is
+// set. Requests without the header, e.g. from non-browser clients or old
+// browsers, are accepted.
+func SecFetchSite(allowSameSite bool) func(*http.Request) bool {
+	return func(r *http.Request) bool {
+		switch r.Header.Get("Sec-Fetch-Site") {
+		case "", "same-origin", "none":
+			return true
+		case "same-site":
+			return allowSameSite
+		}
+
+		return false
+	}
+}
+
+// DoubleSubmit returns a CSRF check accepting requests whose URL query
+// parameter param equals the value of the cookie named cookie. Cross-site pages
+// cannot read the cookie, so they cannot put its value in the URL. Browsers do
+// not let scripts set headers on websocket handshakes, hence the query parameter.
+func DoubleSubmit(cookie, param string) func(*http.Request) bool {
+	return func(r *http.Request) bool {
+		c, err := r.Cookie(cookie)
+		if err != nil || c.Value == "" {
+			return false
+		}
+
+		token := r.URL.Query().Get(param)
+
+		return subtle.ConstantTimeCompare([]byte(c.Value), []byte(token)) == 1
+	}
+}
@@ -0,0 +1,36 @@
+package kuromi
+
+import "github.com/coder/websocket"
+
+// DeadLetter is a message that could not be delivered to a session.
+type DeadLetter struct {
+	Type   websocket.MessageType
+	Msg    []byte
+	Header Header // Nil if the message was written without headers.
+	Reason error  // Why the message was not delivered, e.g. ErrMessageBufferFull or ErrAckTimeout.
+}
+
+// deadLetter passes message to the dead-letter handler. Close messages are ignored.
+func (s *Session) deadLetter(message envelope, reason error) {
+	if message.t == CloseMessage {
+		return
+	}
+
+	s.handlers.onDeadLetter(s, DeadLetter{Type: message.t, Msg: message.msg, Header: message.header, Reason: reason})
+}
+
+// deadLetterQueued passes the messages still queued for the closed session s to the dead-letter handler.
+func (s *Session) deadLetterQueued() {
+	for {
+		message, ok := s.dequeue()
+		if !ok {
+			return
+		}
+
+		s.pending.Add(-1)
+
+		if !message.canceled() {
+			s.deadLetter(message, ErrSessionClosed)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package kuromi
+
+// DeliveryStatus is the outcome of queueing a message for a single session.
+type DeliveryStatus int
+
+const (
+	// Delivered means the message was queued for sending to the session.
+	Delivered DeliveryStatus = iota
+	// DroppedBufferFull means the message was dropped because the session message buffer was full.
+	DroppedBufferFull
+	// DroppedSessionClosed means the message was dropped because the session was closed or draining.
+	DroppedSessionClosed
+)
+
+func (d DeliveryStatus) String() string {
+	switch d {
+	case Delivered:
+		return "delivered"
+	case DroppedBufferFull:
+		return "dropped-buffer-full"
+	case DroppedSessionClosed:
+		return "dropped-session-closed"
+	}
+
+	return "unknown"
+}
+
+// Delivery is the result of a broadcast for a single session.
+type Delivery struct {
+	Session *Session
+	Status  DeliveryStatus
+}
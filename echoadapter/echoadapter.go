@@ -0,0 +1,53 @@
+// Package echoadapter mounts kuromi on Echo routers.
+//
+// Path parameters and selected values set by middleware are copied into
+// Session.Keys:
+//
+//	k := kuromi.New()
+//	e := echo.New()
+//	e.GET("/ws/:room", echoadapter.Handler(k, "user"), authMiddleware)
+//	k.HandleMessage(func(s *kuromi.Session, msg []byte) {
+//		room := s.MustGet("room").(string)
+//		user := s.MustGet("user")
+//		...
+//	})
+package echoadapter
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/fshiori/kuromi"
+	"github.com/labstack/echo/v4"
+)
+
+// Handler returns an echo.HandlerFunc that upgrades the request with k.
+// Session.Keys is populated with the path parameters of the route and the
+// context values stored under keys, which take precedence over parameters
+// of the same name.
+func Handler(k *kuromi.Kuromi, keys ...string) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		names, values := c.ParamNames(), c.ParamValues()
+		sessionKeys := make(map[string]any, len(names)+len(keys))
+
+		for i, name := range names {
+			if i < len(values) {
+				sessionKeys[name] = values[i]
+			}
+		}
+
+		for _, key := range keys {
+			if v := c.Get(key); v != nil {
+				sessionKeys[key] = v
+			}
+		}
+
+		err := k.HandleRequestWithKeys(c.Response(), c.Request(), sessionKeys)
+
+		if errors.Is(err, kuromi.ErrClosed) {
+			return echo.NewHTTPError(http.StatusServiceUnavailable, err.Error())
+		}
+
+		return err
+	}
+}
@@ -1,11 +1,28 @@
 package kuromi
 
-import "github.com/coder/websocket"
+import (
+	"time"
+
+	"github.com/coder/websocket"
+)
 
 type envelope struct {
-	t      websocket.MessageType
-	msg    []byte
-	filter filterFunc
+	t        websocket.MessageType
+	msg      []byte
+	filter   filterFunc
+	priority Priority
+	header   Header
+
+	code   websocket.StatusCode // only used for close message
+	report chan []Delivery      // only used for broadcasts with a delivery report
+	seq    uint64               // only used when sequencing messages
+	ackID  string               // only used for messages sent with BroadcastWithAck
+	done   func()               // only used for room broadcasts, called once the message is queued
+	queued *QueuedMessage       // only used for messages written with WriteCancelable
+
+	expires time.Time // zero if the message does not expire
+}
 
-	code websocket.StatusCode // only used for close message
+func (e envelope) expired(now time.Time) bool {
+	return !e.expires.IsZero() && now.After(e.expires)
 }
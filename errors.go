@@ -1,10 +1,79 @@
 package kuromi
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
 var (
 	ErrClosed            = errors.New("kuromi instance is closed")
 	ErrSessionClosed     = errors.New("session is closed")
 	ErrWriteClosed       = errors.New("tried to write to closed a session")
 	ErrMessageBufferFull = errors.New("session message buffer is full")
+	ErrSessionDraining   = errors.New("session is draining")
+	ErrHandlerQueueFull  = errors.New("message handler queue is full")
+	ErrHandlerTimeout    = errors.New("message handler timed out")
+	ErrUnknownNamespace  = errors.New("unknown namespace")
+	ErrUserNotConnected  = errors.New("user has no connected sessions")
+	ErrInvalidTopic      = errors.New("invalid topic")
+	ErrInvalidResend     = errors.New("invalid resend request")
+	ErrResendUnavailable = errors.New("requested messages are no longer in the retransmit buffer")
+	ErrAckTimeout        = errors.New("message was not acknowledged in time")
+	ErrMessageExpired    = errors.New("message expired before it was written")
+	ErrSessionNotFound   = errors.New("session not found")
+	ErrInvalidConfig     = errors.New("invalid config")
+	ErrOriginNotAllowed  = errors.New("origin not allowed")
+	ErrInvalidUTF8       = errors.New("text message is not valid UTF-8")
+	ErrUndecodable       = errors.New("message cannot be decoded")
+	ErrIPDenied          = errors.New("ip address not allowed")
+	ErrBanned            = errors.New("banned")
+	ErrMessageFlood      = errors.New("message rate exceeded")
+	ErrErrorFlood        = errors.New("error rate exceeded")
+	ErrWebhookQueueFull  = errors.New("webhook queue is full")
+	ErrWebhookFailed     = errors.New("webhook request failed")
+	ErrAuditQueueFull    = errors.New("audit queue is full")
+	ErrCircuitOpen       = errors.New("session stopped writing after repeated write failures")
+	ErrCrossSite         = errors.New("cross-site request rejected")
+	ErrKeyExchange       = errors.New("key exchange failed")
+	ErrDecryptFailed     = errors.New("message cannot be decrypted")
+	ErrBadSignature      = errors.New("message signature is invalid")
+	ErrNoSecret          = errors.New("no signing secret for session")
+	ErrForbidden         = errors.New("action on room not allowed")
+	ErrVersionMismatch   = errors.New("no supported protocol version")
+	ErrPingFailed        = errors.New("session did not answer pings")
+	ErrWriteStalled      = errors.New("write to session stalled")
+	ErrBroadcastLimited  = errors.New("broadcast rate limit exceeded")
 )
+
+// PanicError is passed to the error handler when a handler panics.
+type PanicError struct {
+	Value any    // The value passed to panic.
+	Stack []byte // Stack trace of the panicking goroutine.
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("handler panicked: %v", e.Value)
+}
+
+// StatusError can be returned from a handler to close the session with Code and Reason.
+type StatusError struct {
+	Code   StatusCode // Close status code sent to the session.
+	Reason string     // Close reason sent to the session.
+	Err    error      // Optional underlying error.
+}
+
+func (e *StatusError) Error() string {
+	if e.Err != nil {
+		return e.Err.Error()
+	}
+
+	if e.Reason != "" {
+		return e.Reason
+	}
+
+	return e.Code.String()
+}
+
+func (e *StatusError) Unwrap() error {
+	return e.Err
+}
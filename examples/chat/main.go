@@ -13,9 +13,7 @@ func main() {
 		http.ServeFile(w, r, "index.html")
 	})
 
-	http.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
-		k.HandleRequest(w, r)
-	})
+	http.Handle("/ws", k)
 
 	k.HandleMessage(func(s *kuromi.Session, msg []byte) {
 		k.Broadcast(msg)
@@ -0,0 +1,152 @@
+// Package fiberadapter mounts kuromi on Fiber apps.
+//
+// kuromi is built on net/http, which Fiber (and fasthttp) do not use. The
+// adapter performs the upgrade on the hijacked fasthttp connection instead,
+// translating the fasthttp request into an *http.Request for the session.
+// Route parameters and values stored with c.Locals are copied into Session.Keys:
+//
+//	k := kuromi.New()
+//	app := fiber.New()
+//	app.Get("/ws/:room", authMiddleware, fiberadapter.Handler(k))
+//	k.HandleMessage(func(s *kuromi.Session, msg []byte) {
+//		room := s.MustGet("room").(string)
+//		...
+//	})
+package fiberadapter
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/fshiori/kuromi"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Handler returns a fiber.Handler that upgrades the request with k.
+// Requests that are not websocket upgrades get a 426 Upgrade Required response.
+func Handler(k *kuromi.Kuromi) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if !isUpgrade(c) {
+			return fiber.ErrUpgradeRequired
+		}
+
+		if k.IsClosed() {
+			return fiber.ErrServiceUnavailable
+		}
+
+		r, err := convertRequest(c)
+		if err != nil {
+			return fiber.ErrBadRequest
+		}
+
+		keys := make(map[string]any)
+
+		for name, value := range c.AllParams() {
+			keys[name] = strings.Clone(value)
+		}
+
+		c.Context().VisitUserValues(func(key []byte, value any) {
+			keys[string(key)] = value
+		})
+
+		c.Context().HijackSetNoResponse(true)
+		c.Context().Hijack(func(conn net.Conn) {
+			w := &responseWriter{
+				conn:   conn,
+				brw:    bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn)),
+				header: make(http.Header),
+			}
+
+			k.HandleRequestWithKeys(w, r, keys)
+		})
+
+		return nil
+	}
+}
+
+func isUpgrade(c *fiber.Ctx) bool {
+	return strings.Contains(strings.ToLower(c.Get(fiber.HeaderConnection)), "upgrade") &&
+		strings.EqualFold(c.Get(fiber.HeaderUpgrade), "websocket")
+}
+
+// convertRequest copies the fasthttp request into an *http.Request, since the
+// fasthttp request is reused once the fiber handler returns.
+func convertRequest(c *fiber.Ctx) (*http.Request, error) {
+	requestURI := string(c.Request().RequestURI())
+
+	u, err := url.ParseRequestURI(requestURI)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &http.Request{
+		Method:     c.Method(),
+		URL:        u,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     make(http.Header),
+		Host:       string(c.Request().Host()),
+		RemoteAddr: c.Context().RemoteAddr().String(),
+		RequestURI: requestURI,
+		Body:       http.NoBody,
+	}
+
+	c.Request().Header.VisitAll(func(key, value []byte) {
+		r.Header.Add(string(key), string(value))
+	})
+
+	return r.WithContext(context.Background()), nil
+}
+
+// responseWriter writes a raw HTTP/1.1 response to a hijacked fasthttp
+// connection and hands the connection over on Hijack.
+type responseWriter struct {
+	conn        net.Conn
+	brw         *bufio.ReadWriter
+	header      http.Header
+	wroteHeader bool
+}
+
+func (w *responseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *responseWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+
+	w.wroteHeader = true
+
+	if code != http.StatusSwitchingProtocols {
+		w.header.Set("Connection", "close")
+	}
+
+	fmt.Fprintf(w.brw, "HTTP/1.1 %d %s\r\n", code, http.StatusText(code))
+	w.header.Write(w.brw)
+	w.brw.WriteString("\r\n")
+	w.brw.Flush()
+}
+
+func (w *responseWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	n, err := w.brw.Write(p)
+	if err != nil {
+		return n, err
+	}
+
+	return n, w.brw.Flush()
+}
+
+func (w *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return w.conn, w.brw, nil
+}
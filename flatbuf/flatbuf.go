@@ -0,0 +1,183 @@
+// Package flatbuf hands FlatBuffers messages of sessions to handlers without an
+// unmarshal step, and builds outbound messages with pooled builders, for
+// servers that need minimal CPU per message such as game servers.
+//
+// Messages are dispatched on their file identifier, so several root tables can
+// share a connection:
+//
+//	m := flatbuf.New(k)
+//	flatbuf.Handle(m, "MOVE", game.GetRootAsMove, func(s *kuromi.Session, mv *game.Move) {
+//		b := flatbuf.GetBuilder()
+//		// build the reply with b
+//		flatbuf.WriteBuilder(s, b)
+//	})
+package flatbuf
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/fshiori/kuromi"
+	flatbuffers "github.com/google/flatbuffers/go"
+)
+
+// identifierLength is the length of a file identifier.
+const identifierLength = 4
+
+var (
+	ErrMalformed         = errors.New("malformed flatbuffer")
+	ErrUnknownIdentifier = errors.New("unknown flatbuffer file identifier")
+)
+
+// Handlers is implemented by *kuromi.Kuromi, *kuromi.Route and *kuromi.Namespace.
+type Handlers interface {
+	HandleMessageBinary(func(*kuromi.Session, []byte))
+}
+
+// Mux dispatches the binary messages of sessions to the handlers of their file identifier.
+type Mux struct {
+	mu       sync.RWMutex
+	handlers map[string]func(*kuromi.Session, []byte)
+
+	invalid func(s *kuromi.Session, msg []byte, err error)
+}
+
+// New creates a Mux and installs its binary message handler on h.
+func New(h Handlers) *Mux {
+	m := &Mux{handlers: make(map[string]func(*kuromi.Session, []byte))}
+
+	h.HandleMessageBinary(m.dispatch)
+
+	return m
+}
+
+// Handle fires fn with the root table of the messages whose file identifier is
+// ident, read in place from the message with root, e.g. the generated
+// GetRootAsMonster. An empty ident handles the messages matching no other
+// identifier, e.g. of schemas without file_identifier.
+//
+// Messages are verified with Verify before fn is called. Nested tables and
+// vectors are read lazily and are not verified; reading them out of bounds
+// panics, which kuromi recovers and reports to the error handler.
+func Handle[T any](m *Mux, ident string, root func(buf []byte, offset flatbuffers.UOffsetT) T, fn func(*kuromi.Session, T)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.handlers[ident] = func(s *kuromi.Session, msg []byte) {
+		fn(s, root(msg, 0))
+	}
+}
+
+// HandleInvalid fires fn with the message and the reason when a session sends a
+// binary message that is not a valid flatbuffer or has no handler.
+func (m *Mux) HandleInvalid(fn func(s *kuromi.Session, msg []byte, err error)) {
+	m.invalid = fn
+}
+
+func (m *Mux) dispatch(s *kuromi.Session, msg []byte) {
+	if err := Verify(msg); err != nil {
+		m.fail(s, msg, err)
+		return
+	}
+
+	m.mu.RLock()
+	handler, ok := m.handlers[Identifier(msg)]
+	if !ok {
+		handler, ok = m.handlers[""]
+	}
+	m.mu.RUnlock()
+
+	if !ok {
+		m.fail(s, msg, ErrUnknownIdentifier)
+		return
+	}
+
+	handler(s, msg)
+}
+
+func (m *Mux) fail(s *kuromi.Session, msg []byte, err error) {
+	if m.invalid != nil {
+		m.invalid(s, msg, err)
+	}
+}
+
+// Identifier returns the file identifier of buf, empty if buf is too short to carry one.
+func Identifier(buf []byte) string {
+	if len(buf) < flatbuffers.SizeUOffsetT+identifierLength {
+		return ""
+	}
+
+	return flatbuffers.GetBufferIdentifier(buf)
+}
+
+// Verify checks that the root table of buf and its vtable lie within buf, so
+// the fields of the root table can be read without bounds errors.
+func Verify(buf []byte) error {
+	size := uint64(len(buf))
+
+	if size < flatbuffers.SizeUOffsetT {
+		return ErrMalformed
+	}
+
+	table := uint64(flatbuffers.GetUOffsetT(buf))
+	if table%4 != 0 || table+flatbuffers.SizeSOffsetT > size {
+		return ErrMalformed
+	}
+
+	vtable := int64(table) - int64(flatbuffers.GetSOffsetT(buf[table:]))
+	if vtable < 0 || vtable%2 != 0 || uint64(vtable)+2*flatbuffers.SizeVOffsetT > size {
+		return ErrMalformed
+	}
+
+	vsize := uint64(flatbuffers.GetVOffsetT(buf[vtable:]))
+	tsize := uint64(flatbuffers.GetVOffsetT(buf[vtable+flatbuffers.SizeVOffsetT:]))
+
+	if vsize < 2*flatbuffers.SizeVOffsetT || vsize%2 != 0 || uint64(vtable)+vsize > size || table+tsize > size {
+		return ErrMalformed
+	}
+
+	for off := uint64(2 * flatbuffers.SizeVOffsetT); off < vsize; off += flatbuffers.SizeVOffsetT {
+		if field := uint64(flatbuffers.GetVOffsetT(buf[uint64(vtable)+off:])); field >= tsize && field != 0 {
+			return ErrMalformed
+		}
+	}
+
+	return nil
+}
+
+var builders = sync.Pool{
+	New: func() any { return flatbuffers.NewBuilder(1024) },
+}
+
+// GetBuilder returns a reset builder from the pool. It is returned to the pool
+// by WriteBuilder, BroadcastBuilder or PutBuilder.
+func GetBuilder() *flatbuffers.Builder {
+	return builders.Get().(*flatbuffers.Builder)
+}
+
+// PutBuilder resets b and returns it to the pool. b must not be used afterwards.
+func PutBuilder(b *flatbuffers.Builder) {
+	b.Reset()
+	builders.Put(b)
+}
+
+// WriteBuilder writes the finished buffer of b to s as a binary message and
+// returns b to the pool.
+func WriteBuilder(s *kuromi.Session, b *flatbuffers.Builder) error {
+	defer PutBuilder(b)
+
+	return s.WriteBinary(finished(b))
+}
+
+// BroadcastBuilder broadcasts the finished buffer of b to all sessions of k as
+// a binary message and returns b to the pool.
+func BroadcastBuilder(k *kuromi.Kuromi, b *flatbuffers.Builder) error {
+	defer PutBuilder(b)
+
+	return k.BroadcastBinary(finished(b))
+}
+
+// finished copies the finished buffer of b, which is reused once b returns to the pool.
+func finished(b *flatbuffers.Builder) []byte {
+	return append([]byte(nil), b.FinishedBytes()...)
+}
@@ -0,0 +1,12 @@
+module github.com/fshiori/kuromi/flatbuf
+
+go 1.22.6
+
+require (
+	github.com/fshiori/kuromi v0.0.0
+	github.com/google/flatbuffers v24.3.25+incompatible
+)
+
+require github.com/coder/websocket v1.8.12 // indirect
+
+replace github.com/fshiori/kuromi => ../
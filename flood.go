@@ -0,0 +1,149 @@
+package kuromi
+
+import (
+	"sync"
+	"time"
+)
+
+// Penalty is what happens to a session exceeding the flood thresholds of the Config.
+type Penalty struct {
+	Disconnect bool          // Close the session with StatusPolicyViolation.
+	BanIP      time.Duration // Reject connections from the client IP of the session for this long.
+	BanUser    time.Duration // Reject the user bound to the session for this long.
+}
+
+// floodGuard tracks the message and error rate of a session.
+type floodGuard struct {
+	mu       sync.Mutex
+	tokens   float64
+	last     time.Time
+	errors   int
+	window   time.Time
+	violated bool
+}
+
+// message takes a token for a message and reports whether the message rate was exceeded.
+func (fg *floodGuard) message(rate float64, burst int, now time.Time) bool {
+	if rate <= 0 {
+		return false
+	}
+
+	fg.mu.Lock()
+	defer fg.mu.Unlock()
+
+	if fg.last.IsZero() {
+		fg.tokens = float64(burst)
+	} else {
+		fg.tokens += now.Sub(fg.last).Seconds() * rate
+		if fg.tokens > float64(burst) {
+			fg.tokens = float64(burst)
+		}
+	}
+
+	fg.last = now
+
+	if fg.tokens < 1 {
+		return true
+	}
+
+	fg.tokens--
+
+	return false
+}
+
+// fault counts an error and reports whether more than max errors happened within window.
+func (fg *floodGuard) fault(max int, window time.Duration, now time.Time) bool {
+	if max <= 0 {
+		return false
+	}
+
+	fg.mu.Lock()
+	defer fg.mu.Unlock()
+
+	if now.Sub(fg.window) > window {
+		fg.window = now
+		fg.errors = 0
+	}
+
+	fg.errors++
+
+	return fg.errors > max
+}
+
+// checkFlood reports whether the message rate of the session was exceeded and
+// penalizes the session if so.
+func (s *Session) checkFlood() bool {
+	if !s.flood.message(s.kuromi.Config.FloodMessageRate, s.kuromi.Config.FloodBurst, s.kuromi.now()) {
+		return false
+	}
+
+	s.penalize(ErrMessageFlood)
+
+	return true
+}
+
+// recordFault counts an error caused by the session, e.g. a rejected message,
+// and penalizes the session if it exceeds Config.FloodMaxErrors.
+func (s *Session) recordFault() {
+	if s.flood.fault(s.kuromi.Config.FloodMaxErrors, s.kuromi.Config.FloodWindow, s.kuromi.now()) {
+		s.penalize(ErrErrorFlood)
+	}
+}
+
+func (s *Session) penalize(reason error) {
+	s.flood.mu.Lock()
+	violated := s.flood.violated
+	s.flood.violated = true
+	s.flood.mu.Unlock()
+
+	if violated {
+		return
+	}
+
+	s.handlers.onError(s, reason)
+
+	p := s.kuromi.defaultPenalty(s)
+	if s.kuromi.floodHandler != nil {
+		p = s.kuromi.floodHandler(s, reason, p)
+	}
+
+	if p.BanIP > 0 {
+		s.kuromi.bans.ban(s.kuromi.bans.ips, s.ClientIP(), p.BanIP, s.kuromi.now())
+		s.kuromi.audit(AuditEvent{Action: AuditBanIP, SessionID: s.ID(), CorrelationID: s.CorrelationID(), ClientIP: s.ClientIP(), Detail: reason.Error()})
+	}
+
+	if user := s.UserID(); user != "" && p.BanUser > 0 {
+		s.kuromi.bans.ban(s.kuromi.bans.users, user, p.BanUser, s.kuromi.now())
+		s.kuromi.audit(AuditEvent{Action: AuditBanUser, SessionID: s.ID(), CorrelationID: s.CorrelationID(), UserID: user, Detail: reason.Error()})
+	}
+
+	if p.Disconnect {
+		s.CloseWithMsg(StatusPolicyViolation, reason.Error())
+		return
+	}
+
+	s.flood.mu.Lock()
+	s.flood.violated = false
+	s.flood.mu.Unlock()
+}
+
+// defaultPenalty disconnects s and bans its user, or its client IP if it has
+// no user, for Config.FloodBanDuration.
+func (k *Kuromi) defaultPenalty(s *Session) Penalty {
+	p := Penalty{Disconnect: true}
+
+	if s.UserID() != "" {
+		p.BanUser = k.Config.FloodBanDuration
+	} else {
+		p.BanIP = k.Config.FloodBanDuration
+	}
+
+	return p
+}
+
+// HandleFlood fires fn when a session exceeds Config.FloodMessageRate or
+// Config.FloodMaxErrors. fn receives the default penalty and returns the
+// penalty applied to the session.
+func (k *Kuromi) HandleFlood(fn func(s *Session, reason error, p Penalty) Penalty) {
+	k.floodHandler = fn
+}
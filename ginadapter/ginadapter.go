@@ -0,0 +1,37 @@
+// Package ginadapter mounts kuromi on Gin routers.
+//
+// Values set on the gin context by earlier middleware, such as the
+// authenticated user, are copied into Session.Keys:
+//
+//	k := kuromi.New()
+//	r := gin.Default()
+//	r.GET("/ws", authMiddleware, ginadapter.Handler(k))
+//	k.HandleMessage(func(s *kuromi.Session, msg []byte) {
+//		user := s.MustGet("user")
+//		...
+//	})
+package ginadapter
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/fshiori/kuromi"
+	"github.com/gin-gonic/gin"
+)
+
+// Handler returns a gin.HandlerFunc that upgrades the request with k and
+// populates Session.Keys with a copy of the gin context keys.
+func Handler(k *kuromi.Kuromi) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		keys := c.Copy().Keys
+
+		if err := k.HandleRequestWithKeys(c.Writer, c.Request, keys); err != nil {
+			if errors.Is(err, kuromi.ErrClosed) {
+				c.AbortWithStatus(http.StatusServiceUnavailable)
+			}
+
+			c.Error(err)
+		}
+	}
+}
@@ -0,0 +1,222 @@
+// Package grpcbridge exposes a kuromi instance as a gRPC bidirectional streaming
+// service, so internal services can message websocket clients over gRPC without
+// knowing about websockets, and connects kuromi to remote services implementing
+// the same service. The service is defined in bridge.proto.
+//
+//	k := kuromi.New()
+//	b := grpcbridge.New(k)
+//	k.HandleMessage(b.Forward)
+//
+//	srv := grpc.NewServer(grpcbridge.ServerOption())
+//	b.Register(srv)
+package grpcbridge
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/fshiori/kuromi"
+	"google.golang.org/grpc"
+)
+
+// Method is the full name of the streaming method of the bridge service.
+const Method = "/kuromi.bridge.v1.Bridge/Stream"
+
+// DefaultBufferSize is the default number of frames queued for a stream.
+const DefaultBufferSize = 256
+
+var ErrBufferFull = errors.New("bridge stream buffer is full")
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: "kuromi.bridge.v1.Bridge",
+	HandlerType: (*any)(nil),
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Stream",
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "bridge.proto",
+}
+
+// Bridge connects the sessions of a kuromi instance to gRPC streams.
+type Bridge struct {
+	BufferSize int                 // Number of frames queued for a stream before frames are dropped, DefaultBufferSize if 0.
+	OnError    func(*Frame, error) // Optional handler of frames that could not be delivered.
+
+	kuromi  *kuromi.Kuromi
+	mu      sync.RWMutex
+	streams map[chan *Frame]struct{}
+}
+
+// New returns a bridge to the sessions of k.
+func New(k *kuromi.Kuromi) *Bridge {
+	return &Bridge{
+		kuromi:  k,
+		streams: make(map[chan *Frame]struct{}),
+	}
+}
+
+// ServerOption returns the server option to pass to grpc.NewServer, so it can
+// encode frames. It keeps encoding generated protobuf messages of other services.
+func ServerOption() grpc.ServerOption {
+	return grpc.ForceServerCodec(Codec())
+}
+
+// Register registers the bridge service on srv.
+func (b *Bridge) Register(srv grpc.ServiceRegistrar) {
+	desc := serviceDesc
+	desc.Streams = []grpc.StreamDesc{serviceDesc.Streams[0]}
+	desc.Streams[0].Handler = func(_ any, stream grpc.ServerStream) error {
+		return b.pump(stream.Context(), stream)
+	}
+
+	srv.RegisterService(&desc, nil)
+}
+
+// Connect opens a stream to the bridge service of a remote peer on cc, delivering
+// the frames it sends to the sessions and forwarding to it the frames passed to
+// Forward, until ctx is done or the stream fails.
+func (b *Bridge) Connect(ctx context.Context, cc grpc.ClientConnInterface) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stream, err := cc.NewStream(ctx, &serviceDesc.Streams[0], Method, grpc.ForceCodec(Codec()))
+	if err != nil {
+		return err
+	}
+
+	return b.pump(ctx, stream)
+}
+
+// Forward sends a text message of s to the connected gRPC streams. It can be
+// passed to kuromi.Kuromi.HandleMessage directly.
+func (b *Bridge) Forward(s *kuromi.Session, msg []byte) {
+	b.Send(&Frame{Session: s.ID(), User: s.UserID(), Data: msg})
+}
+
+// ForwardBinary sends a binary message of s to the connected gRPC streams. It
+// can be passed to kuromi.Kuromi.HandleMessageBinary directly.
+func (b *Bridge) ForwardBinary(s *kuromi.Session, msg []byte) {
+	b.Send(&Frame{Session: s.ID(), User: s.UserID(), Binary: true, Data: msg})
+}
+
+// Send queues f on all connected gRPC streams. Streams whose buffer is full drop it.
+func (b *Bridge) Send(f *Frame) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for out := range b.streams {
+		select {
+		case out <- f:
+		default:
+			b.fail(f, ErrBufferFull)
+		}
+	}
+}
+
+// Deliver routes f to the sessions it targets.
+func (b *Bridge) Deliver(f *Frame) error {
+	k := b.kuromi
+
+	switch {
+	case f.Session != "" && f.Binary:
+		return k.SendToSessionBinary(f.Session, f.Data)
+	case f.Session != "":
+		return k.SendToSession(f.Session, f.Data)
+	case f.User != "" && f.Binary:
+		return k.SendToUserBinary(f.User, f.Data)
+	case f.User != "":
+		return k.SendToUser(f.User, f.Data)
+	case f.Room != "" && f.Binary:
+		return k.BroadcastRoomBinary(f.Room, f.Data)
+	case f.Room != "":
+		return k.BroadcastRoom(f.Room, f.Data)
+	case f.Topic != "" && f.Binary:
+		return k.PublishBinary(f.Topic, f.Data)
+	case f.Topic != "":
+		return k.Publish(f.Topic, f.Data)
+	case f.Binary:
+		return k.BroadcastBinary(f.Data)
+	}
+
+	return k.Broadcast(f.Data)
+}
+
+// stream is the part of grpc.ServerStream and grpc.ClientStream used by the bridge.
+type stream interface {
+	SendMsg(m any) error
+	RecvMsg(m any) error
+}
+
+// pump delivers the frames received on st and sends the forwarded frames to st until either fails.
+func (b *Bridge) pump(ctx context.Context, st stream) error {
+	size := b.BufferSize
+	if size <= 0 {
+		size = DefaultBufferSize
+	}
+
+	out := make(chan *Frame, size)
+
+	b.mu.Lock()
+	b.streams[out] = struct{}{}
+	b.mu.Unlock()
+
+	defer func() {
+		b.mu.Lock()
+		delete(b.streams, out)
+		b.mu.Unlock()
+	}()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	errs := make(chan error, 2)
+
+	go func() {
+		for {
+			select {
+			case f := <-out:
+				if err := st.SendMsg(f); err != nil {
+					errs <- err
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	go func() {
+		for {
+			f := new(Frame)
+			if err := st.RecvMsg(f); err != nil {
+				errs <- err
+				return
+			}
+
+			if err := b.Deliver(f); err != nil {
+				b.fail(f, err)
+			}
+		}
+	}()
+
+	select {
+	case err := <-errs:
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (b *Bridge) fail(f *Frame, err error) {
+	if b.OnError != nil {
+		b.OnError(f, err)
+	}
+}
@@ -0,0 +1,56 @@
+package grpcbridge
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+)
+
+// Client is a client of the bridge service for services messaging websocket
+// clients without running kuromi.
+type Client struct {
+	cc grpc.ClientConnInterface
+}
+
+// NewClient returns a client of the bridge service on cc.
+func NewClient(cc grpc.ClientConnInterface) *Client {
+	return &Client{cc: cc}
+}
+
+// Stream opens a stream to the bridge service. Frames sent on it are routed to
+// the sessions they target, and the frames forwarded by the bridge are received on it.
+func (c *Client) Stream(ctx context.Context, opts ...grpc.CallOption) (*Stream, error) {
+	opts = append([]grpc.CallOption{grpc.ForceCodec(Codec())}, opts...)
+
+	cs, err := c.cc.NewStream(ctx, &serviceDesc.Streams[0], Method, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Stream{cs: cs}, nil
+}
+
+// Stream is a stream of frames to and from the bridge service.
+type Stream struct {
+	cs grpc.ClientStream
+}
+
+// Send sends f to the bridge. It must not be called concurrently.
+func (s *Stream) Send(f *Frame) error {
+	return s.cs.SendMsg(f)
+}
+
+// Recv receives the next frame forwarded by the bridge. It must not be called concurrently.
+func (s *Stream) Recv() (*Frame, error) {
+	f := new(Frame)
+	if err := s.cs.RecvMsg(f); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// CloseSend closes the sending side of the stream.
+func (s *Stream) CloseSend() error {
+	return s.cs.CloseSend()
+}
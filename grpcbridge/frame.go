@@ -0,0 +1,155 @@
+package grpcbridge
+
+import (
+	"errors"
+
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+)
+
+var ErrMalformedFrame = errors.New("malformed bridge frame")
+
+// Frame is a message between a gRPC peer and the websocket sessions of a kuromi
+// instance, see bridge.proto.
+//
+// Frames sent to kuromi are routed by the first target set: Session, User, Room
+// or Topic, and broadcast to all sessions otherwise. Frames received from kuromi
+// carry the Session and User that sent them.
+type Frame struct {
+	Session string // Global ID of the session, see kuromi.Kuromi.SendToSession.
+	User    string
+	Room    string
+	Topic   string
+	Binary  bool
+	Data    []byte
+}
+
+// Field numbers of Frame in bridge.proto.
+const (
+	fieldSession protowire.Number = iota + 1
+	fieldUser
+	fieldRoom
+	fieldTopic
+	fieldBinary
+	fieldData
+)
+
+// Marshal returns the protobuf encoding of f.
+func (f *Frame) Marshal() []byte {
+	var b []byte
+
+	for _, field := range []struct {
+		num   protowire.Number
+		value string
+	}{
+		{fieldSession, f.Session},
+		{fieldUser, f.User},
+		{fieldRoom, f.Room},
+		{fieldTopic, f.Topic},
+	} {
+		if field.value != "" {
+			b = protowire.AppendTag(b, field.num, protowire.BytesType)
+			b = protowire.AppendString(b, field.value)
+		}
+	}
+
+	if f.Binary {
+		b = protowire.AppendTag(b, fieldBinary, protowire.VarintType)
+		b = protowire.AppendVarint(b, 1)
+	}
+
+	if len(f.Data) > 0 {
+		b = protowire.AppendTag(b, fieldData, protowire.BytesType)
+		b = protowire.AppendBytes(b, f.Data)
+	}
+
+	return b
+}
+
+// Unmarshal parses the protobuf encoding of a frame into f. Unknown fields are skipped.
+func (f *Frame) Unmarshal(b []byte) error {
+	*f = Frame{}
+
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return ErrMalformedFrame
+		}
+		b = b[n:]
+
+		switch {
+		case num == fieldBinary && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				return ErrMalformedFrame
+			}
+			f.Binary = v != 0
+			b = b[n:]
+		case num >= fieldSession && num <= fieldData && typ == protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return ErrMalformedFrame
+			}
+
+			switch num {
+			case fieldSession:
+				f.Session = string(v)
+			case fieldUser:
+				f.User = string(v)
+			case fieldRoom:
+				f.Room = string(v)
+			case fieldTopic:
+				f.Topic = string(v)
+			case fieldData:
+				f.Data = append([]byte(nil), v...)
+			}
+			b = b[n:]
+		default:
+			n := protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return ErrMalformedFrame
+			}
+			b = b[n:]
+		}
+	}
+
+	return nil
+}
+
+// codec is the protobuf codec of gRPC extended to frames, which are encoded
+// by hand rather than generated.
+type codec struct{}
+
+// Codec returns the gRPC codec encoding frames as well as generated protobuf
+// messages, so it can replace the default codec of servers also serving other
+// services, see ServerOption.
+func Codec() encoding.Codec {
+	return codec{}
+}
+
+func (codec) Marshal(v any) ([]byte, error) {
+	switch v := v.(type) {
+	case *Frame:
+		return v.Marshal(), nil
+	case proto.Message:
+		return proto.Marshal(v)
+	}
+
+	return nil, errors.New("grpcbridge: cannot marshal non-protobuf value")
+}
+
+func (codec) Unmarshal(data []byte, v any) error {
+	switch v := v.(type) {
+	case *Frame:
+		return v.Unmarshal(data)
+	case proto.Message:
+		return proto.Unmarshal(data, v)
+	}
+
+	return errors.New("grpcbridge: cannot unmarshal into non-protobuf value")
+}
+
+func (codec) Name() string {
+	return "proto"
+}
@@ -0,0 +1,19 @@
+module github.com/fshiori/kuromi/grpcbridge
+
+go 1.22.6
+
+require (
+	github.com/fshiori/kuromi v0.0.0
+	google.golang.org/grpc v1.64.0
+	google.golang.org/protobuf v1.33.0
+)
+
+require (
+	github.com/coder/websocket v1.8.12 // indirect
+	golang.org/x/net v0.22.0 // indirect
+	golang.org/x/sys v0.18.0 // indirect
+	golang.org/x/text v0.14.0 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20240318140521-94a12d6c2237 // indirect
+)
+
+replace github.com/fshiori/kuromi => ../
@@ -0,0 +1,151 @@
+package kuromi
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/base64"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/coder/websocket"
+)
+
+// isExtendedConnect reports whether r bootstraps a websocket over an HTTP/2
+// stream with an extended CONNECT request as described in RFC 8441.
+func isExtendedConnect(r *http.Request) bool {
+	return r.ProtoMajor == 2 &&
+		r.Method == http.MethodConnect &&
+		r.Header.Get(":protocol") == "websocket"
+}
+
+// acceptExtendedConnect accepts a websocket over the HTTP/2 stream of r.
+// The websocket library only speaks the HTTP/1.1 upgrade handshake, so r is
+// presented to it as an upgrade request and the stream as a hijacked connection.
+func acceptExtendedConnect(w http.ResponseWriter, r *http.Request, opts *websocket.AcceptOptions) (*websocket.Conn, error) {
+	key := make([]byte, 16)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+
+	req := r.Clone(r.Context())
+	req.Method = http.MethodGet
+	req.Proto, req.ProtoMajor, req.ProtoMinor = "HTTP/1.1", 1, 1
+	req.Header.Del(":protocol")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Key", base64.StdEncoding.EncodeToString(key))
+
+	return websocket.Accept(&h2ResponseWriter{w: w, r: r, header: make(http.Header)}, req, opts)
+}
+
+// h2ResponseWriter translates the HTTP/1.1 upgrade response written by the
+// websocket library into the 200 response of an extended CONNECT request.
+type h2ResponseWriter struct {
+	w      http.ResponseWriter
+	r      *http.Request
+	header http.Header
+}
+
+func (hw *h2ResponseWriter) Header() http.Header {
+	return hw.header
+}
+
+func (hw *h2ResponseWriter) WriteHeader(code int) {
+	for name, values := range hw.header {
+		switch http.CanonicalHeaderKey(name) {
+		case "Connection", "Upgrade", "Sec-Websocket-Accept":
+			continue
+		}
+
+		hw.w.Header()[name] = values
+	}
+
+	if code == http.StatusSwitchingProtocols {
+		code = http.StatusOK
+	}
+
+	hw.w.WriteHeader(code)
+	http.NewResponseController(hw.w).Flush()
+}
+
+func (hw *h2ResponseWriter) Write(p []byte) (int, error) {
+	return hw.w.Write(p)
+}
+
+func (hw *h2ResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	c := &h2Conn{
+		body:   hw.r.Body,
+		w:      hw.w,
+		rc:     http.NewResponseController(hw.w),
+		remote: h2Addr(hw.r.RemoteAddr),
+	}
+
+	return c, bufio.NewReadWriter(bufio.NewReader(c), bufio.NewWriter(c)), nil
+}
+
+// h2Conn is a net.Conn reading from the request body and writing to the
+// response of an HTTP/2 stream.
+type h2Conn struct {
+	body   io.ReadCloser
+	w      http.ResponseWriter
+	rc     *http.ResponseController
+	remote h2Addr
+
+	mu     sync.Mutex
+	closed bool
+}
+
+func (c *h2Conn) Read(p []byte) (int, error) {
+	return c.body.Read(p)
+}
+
+func (c *h2Conn) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return 0, net.ErrClosed
+	}
+
+	n, err := c.w.Write(p)
+	if err != nil {
+		return n, err
+	}
+
+	return n, c.rc.Flush()
+}
+
+func (c *h2Conn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return nil
+	}
+
+	c.closed = true
+
+	return c.body.Close()
+}
+
+func (c *h2Conn) LocalAddr() net.Addr  { return h2Addr("") }
+func (c *h2Conn) RemoteAddr() net.Addr { return c.remote }
+
+func (c *h2Conn) SetDeadline(t time.Time) error {
+	if err := c.rc.SetReadDeadline(t); err != nil {
+		return err
+	}
+
+	return c.rc.SetWriteDeadline(t)
+}
+
+func (c *h2Conn) SetReadDeadline(t time.Time) error  { return c.rc.SetReadDeadline(t) }
+func (c *h2Conn) SetWriteDeadline(t time.Time) error { return c.rc.SetWriteDeadline(t) }
+
+type h2Addr string
+
+func (a h2Addr) Network() string { return "tcp" }
+func (a h2Addr) String() string  { return string(a) }
@@ -0,0 +1,412 @@
+package kuromi
+
+import (
+	"context"
+	"time"
+
+	"github.com/coder/websocket"
+)
+
+type handleMessageFunc func(*Session, []byte)
+type handleMessageContextFunc func(context.Context, *Session, []byte) error
+type handleErrorFunc func(*Session, error)
+type handleCloseFunc func(*Session, int, string) error
+type handleSessionFunc func(*Session)
+type handleSessionErrFunc func(*Session) error
+type handleDisconnectFunc func(*Session, StatusCode, string)
+type handleReceiptFunc func(*Session, string)
+type handleLatencyFunc func(*Session, time.Duration)
+type handleSlowConsumerFunc func(*Session, SlowConsumerStats)
+type handleKeyExpiredFunc func(*Session, string, any)
+type handleSendErrorFunc func(*Session, []byte, error)
+type handleDeadLetterFunc func(*Session, DeadLetter)
+type handleInvalidMessageFunc func(*Session, []byte, error)
+type handleVerifyFailureFunc func(*Session, []byte, error)
+type handleSentFunc func(*Session, SentMessage)
+type validateFunc func(*Session, []byte) error
+type filterFunc func(*Session) bool
+
+// handlers is a set of handlers. Handlers that are not set are looked up in
+// the parent set, so routes only need to set the handlers they override.
+type handlers struct {
+	messageHandler           handleMessageContextFunc
+	messageHandlerBinary     handleMessageContextFunc
+	messageSentHandler       handleMessageFunc
+	messageSentHandlerBinary handleMessageFunc
+	sentHandler              handleSentFunc
+	errorHandler             handleErrorFunc
+	closeHandler             handleCloseFunc
+	connectHandler           handleSessionErrFunc
+	disconnectHandler        handleDisconnectFunc
+	pongHandler              handleMessageFunc
+	latencyHandler           handleLatencyFunc
+	pingFailureHandler       handleErrorFunc
+	slowConsumerHandler      handleSlowConsumerFunc
+	keyExpiredHandler        handleKeyExpiredFunc
+	sendErrorHandler         handleSendErrorFunc
+	receiptHandler           handleReceiptFunc
+	deadLetterHandler        handleDeadLetterFunc
+	invalidMessageHandler    handleInvalidMessageFunc
+	verifyFailureHandler     handleVerifyFailureFunc
+	validator                validateFunc
+	parent                   *handlers
+}
+
+// HandleConnect fires fn when a session connects.
+func (h *handlers) HandleConnect(fn func(*Session)) {
+	h.connectHandler = func(s *Session) error {
+		fn(s)
+		return nil
+	}
+}
+
+// HandleConnectErr fires fn when a session connects.
+// If fn returns an error the session is rejected: it is closed immediately
+// with the code and reason of a *StatusError, or StatusPolicyViolation otherwise.
+func (h *handlers) HandleConnectErr(fn func(*Session) error) {
+	h.connectHandler = fn
+}
+
+// HandleDisconnect fires fn when a session disconnects.
+func (h *handlers) HandleDisconnect(fn func(*Session)) {
+	h.disconnectHandler = func(s *Session, _ StatusCode, _ string) {
+		fn(s)
+	}
+}
+
+// HandleDisconnectStatus fires fn with the close code and reason when a session
+// disconnects, see Session.DisconnectStatus.
+func (h *handlers) HandleDisconnectStatus(fn func(*Session, StatusCode, string)) {
+	h.disconnectHandler = fn
+}
+
+// HandlePong fires fn when a pong is received from a session, failed pings are
+// reported to HandlePingFailure instead. With Config.PingPayload set it fires
+// when the session echoes the payload, see PongPrefix.
+func (h *handlers) HandlePong(fn func(*Session, []byte)) {
+	h.pongHandler = fn
+}
+
+// HandlePingFailure fires fn with the error when a ping to a session fails,
+// e.g. because no pong was received within Config.WriteWait. The session is
+// closed after Config.MaxPingFailures consecutive failures.
+func (h *handlers) HandlePingFailure(fn func(*Session, error)) {
+	h.pingFailureHandler = fn
+}
+
+// HandleLatency fires fn with the round-trip time of a ping when a pong is
+// received from a session, see Session.Latency.
+func (h *handlers) HandleLatency(fn func(*Session, time.Duration)) {
+	h.latencyHandler = fn
+}
+
+// HandleSlowConsumer fires fn in its own goroutine when the output queue of a
+// session stays above Config.SlowConsumerHighWater for Config.SlowConsumerThreshold,
+// e.g. to kick the session or switch it to snapshots. It fires again only
+// after the queue dropped below the high-water mark.
+func (h *handlers) HandleSlowConsumer(fn func(*Session, SlowConsumerStats)) {
+	h.slowConsumerHandler = fn
+}
+
+// HandleKeyExpired fires fn with the key and its value when a value stored
+// with Session.SetWithTTL expires.
+func (h *handlers) HandleKeyExpired(fn func(*Session, string, any)) {
+	h.keyExpiredHandler = fn
+}
+
+// HandleReceipt fires fn with the message id when a session sends a delivery receipt, see ReceiptPrefix.
+func (h *handlers) HandleReceipt(fn func(*Session, string)) {
+	h.receiptHandler = fn
+}
+
+// HandleSendError fires fn with the message and the error when writing a queued
+// message to a session failed, after any retries, so the application can persist
+// or re-route it. The error handler is still called with the error.
+func (h *handlers) HandleSendError(fn func(*Session, []byte, error)) {
+	h.sendErrorHandler = fn
+}
+
+// HandleDeadLetter fires fn when a message cannot be delivered to a session, because its
+// message buffer is full, it closed before the message was written or it did not
+// confirm a message sent with BroadcastWithAck.
+func (h *handlers) HandleDeadLetter(fn func(*Session, DeadLetter)) {
+	h.deadLetterHandler = fn
+}
+
+// HandleInvalidMessage fires fn with the raw message and the reason when a session
+// sends a malformed message, see Config.InvalidMessagePolicy.
+func (h *handlers) HandleInvalidMessage(fn func(*Session, []byte, error)) {
+	h.invalidMessageHandler = fn
+}
+
+// HandleVerifyFailure fires fn with the raw message and the error when a message
+// sent by a session fails verification or decryption by one of Config.Transforms,
+// e.g. an HMACSigner signature mismatch. The message is dropped and the error
+// handler is still called with the error.
+func (h *handlers) HandleVerifyFailure(fn func(*Session, []byte, error)) {
+	h.verifyFailureHandler = fn
+}
+
+// HandleMessage fires fn when a text message comes in.
+// NOTE: by default Kuromi handles messages sequentially for each
+// session. This has the effect that a message handler exceeding the
+// read deadline (Config.PongWait, by default 1 minute) will time out
+// the session. Concurrent message handling can be turned on by setting
+// Config.ConcurrentMessageHandling to true. Setting Config.MessageHandlerWorkers
+// additionally bounds the number of messages handled at the same time.
+func (h *handlers) HandleMessage(fn func(*Session, []byte)) {
+	h.messageHandler = func(_ context.Context, s *Session, msg []byte) error {
+		fn(s, msg)
+		return nil
+	}
+}
+
+// HandleMessageContext fires fn when a text message comes in.
+// The context passed to fn is done when Config.MessageHandlerTimeout expires.
+func (h *handlers) HandleMessageContext(fn func(context.Context, *Session, []byte)) {
+	h.messageHandler = func(ctx context.Context, s *Session, msg []byte) error {
+		fn(ctx, s, msg)
+		return nil
+	}
+}
+
+// HandleMessageErr fires fn when a text message comes in.
+// A non-nil error returned by fn is passed to the error handler. If the error
+// is or wraps a *StatusError the session is closed with its code and reason.
+func (h *handlers) HandleMessageErr(fn func(*Session, []byte) error) {
+	h.messageHandler = func(_ context.Context, s *Session, msg []byte) error {
+		return fn(s, msg)
+	}
+}
+
+// HandleMessageBinary fires fn when a binary message comes in.
+func (h *handlers) HandleMessageBinary(fn func(*Session, []byte)) {
+	h.messageHandlerBinary = func(_ context.Context, s *Session, msg []byte) error {
+		fn(s, msg)
+		return nil
+	}
+}
+
+// HandleMessageBinaryContext fires fn when a binary message comes in.
+// The context passed to fn is done when Config.MessageHandlerTimeout expires.
+func (h *handlers) HandleMessageBinaryContext(fn func(context.Context, *Session, []byte)) {
+	h.messageHandlerBinary = func(ctx context.Context, s *Session, msg []byte) error {
+		fn(ctx, s, msg)
+		return nil
+	}
+}
+
+// HandleMessageBinaryErr fires fn when a binary message comes in.
+// Errors returned by fn are handled the same way as for HandleMessageErr.
+func (h *handlers) HandleMessageBinaryErr(fn func(*Session, []byte) error) {
+	h.messageHandlerBinary = func(_ context.Context, s *Session, msg []byte) error {
+		return fn(s, msg)
+	}
+}
+
+// HandleSentMessage fires fn when a text message is successfully sent.
+func (h *handlers) HandleSentMessage(fn func(*Session, []byte)) {
+	h.messageSentHandler = fn
+}
+
+// HandleSentMessageBinary fires fn when a binary message is successfully sent.
+func (h *handlers) HandleSentMessageBinary(fn func(*Session, []byte)) {
+	h.messageSentHandlerBinary = fn
+}
+
+// HandleSent fires fn with every message successfully sent, including its
+// headers, after the handlers set with HandleSentMessage and HandleSentMessageBinary.
+func (h *handlers) HandleSent(fn func(*Session, SentMessage)) {
+	h.sentHandler = fn
+}
+
+// HandleError fires fn when a session has an error.
+// Panics in message, connect and disconnect handlers are recovered and passed
+// to fn as a *PanicError, after which the session is closed.
+func (h *handlers) HandleError(fn func(*Session, error)) {
+	h.errorHandler = fn
+}
+
+// HandleClose sets the handler for close messages received from the session.
+// The code argument to h is the received close code or CloseNoStatusReceived
+// if the close message is empty. The default close handler sends a close frame
+// back to the session.
+//
+// The application must read the connection to process close messages as
+// described in the section on Control Frames above.
+//
+// The connection read methods return a CloseError when a close frame is
+// received. Most applications should handle close messages as part of their
+// normal error handling. Applications should only set a close handler when the
+// application must perform some action before sending a close frame back to
+// the session.
+func (h *handlers) HandleClose(fn func(*Session, int, string) error) {
+	if fn != nil {
+		h.closeHandler = fn
+	}
+}
+
+func (h *handlers) onMessage(ctx context.Context, s *Session, t websocket.MessageType, msg []byte) error {
+	for ; h != nil; h = h.parent {
+		if t == websocket.MessageText && h.messageHandler != nil {
+			return h.messageHandler(ctx, s, msg)
+		}
+
+		if t == websocket.MessageBinary && h.messageHandlerBinary != nil {
+			return h.messageHandlerBinary(ctx, s, msg)
+		}
+	}
+
+	return nil
+}
+
+func (h *handlers) onSent(s *Session, t websocket.MessageType, msg []byte) {
+	for ; h != nil; h = h.parent {
+		if t == websocket.MessageText && h.messageSentHandler != nil {
+			h.messageSentHandler(s, msg)
+			return
+		}
+
+		if t == websocket.MessageBinary && h.messageSentHandlerBinary != nil {
+			h.messageSentHandlerBinary(s, msg)
+			return
+		}
+	}
+}
+
+func (h *handlers) onSentMessage(s *Session, m SentMessage) {
+	for ; h != nil; h = h.parent {
+		if h.sentHandler != nil {
+			h.sentHandler(s, m)
+			return
+		}
+	}
+}
+
+func (h *handlers) onError(s *Session, err error) {
+	for ; h != nil; h = h.parent {
+		if h.errorHandler != nil {
+			h.errorHandler(s, err)
+			return
+		}
+	}
+}
+
+func (h *handlers) onClose(s *Session, code int, reason string) {
+	for ; h != nil; h = h.parent {
+		if h.closeHandler != nil {
+			h.closeHandler(s, code, reason)
+			return
+		}
+	}
+}
+
+func (h *handlers) onConnect(s *Session) error {
+	for ; h != nil; h = h.parent {
+		if h.connectHandler != nil {
+			return h.connectHandler(s)
+		}
+	}
+
+	return nil
+}
+
+func (h *handlers) onDisconnect(s *Session) {
+	for ; h != nil; h = h.parent {
+		if h.disconnectHandler != nil {
+			code, reason := s.DisconnectStatus()
+			h.disconnectHandler(s, code, reason)
+			return
+		}
+	}
+}
+
+func (h *handlers) onPong(s *Session, payload []byte) {
+	for ; h != nil; h = h.parent {
+		if h.pongHandler != nil {
+			h.pongHandler(s, payload)
+			return
+		}
+	}
+}
+
+func (h *handlers) onPingFailure(s *Session, err error) {
+	for ; h != nil; h = h.parent {
+		if h.pingFailureHandler != nil {
+			h.pingFailureHandler(s, err)
+			return
+		}
+	}
+}
+
+func (h *handlers) onLatency(s *Session, rtt time.Duration) {
+	for ; h != nil; h = h.parent {
+		if h.latencyHandler != nil {
+			h.latencyHandler(s, rtt)
+			return
+		}
+	}
+}
+
+func (h *handlers) onSlowConsumer(s *Session, stats SlowConsumerStats) {
+	for ; h != nil; h = h.parent {
+		if h.slowConsumerHandler != nil {
+			h.slowConsumerHandler(s, stats)
+			return
+		}
+	}
+}
+
+func (h *handlers) onKeyExpired(s *Session, key string, value any) {
+	for ; h != nil; h = h.parent {
+		if h.keyExpiredHandler != nil {
+			h.keyExpiredHandler(s, key, value)
+			return
+		}
+	}
+}
+
+func (h *handlers) onSendError(s *Session, msg []byte, err error) {
+	for ; h != nil; h = h.parent {
+		if h.sendErrorHandler != nil {
+			h.sendErrorHandler(s, msg, err)
+			return
+		}
+	}
+}
+
+func (h *handlers) onVerifyFailure(s *Session, msg []byte, err error) {
+	for ; h != nil; h = h.parent {
+		if h.verifyFailureHandler != nil {
+			h.verifyFailureHandler(s, msg, err)
+			return
+		}
+	}
+}
+
+func (h *handlers) onReceipt(s *Session, id string) {
+	for ; h != nil; h = h.parent {
+		if h.receiptHandler != nil {
+			h.receiptHandler(s, id)
+			return
+		}
+	}
+}
+
+func (h *handlers) onDeadLetter(s *Session, dl DeadLetter) {
+	for ; h != nil; h = h.parent {
+		if h.deadLetterHandler != nil {
+			h.deadLetterHandler(s, dl)
+			return
+		}
+	}
+}
+
+func (h *handlers) onInvalidMessage(s *Session, msg []byte, err error) {
+	for ; h != nil; h = h.parent {
+		if h.invalidMessageHandler != nil {
+			h.invalidMessageHandler(s, msg, err)
+			return
+		}
+	}
+}
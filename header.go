@@ -0,0 +1,136 @@
+package kuromi
+
+import (
+	"bytes"
+	"context"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/coder/websocket"
+)
+
+// HeaderPrefix starts messages carrying headers when Config.MessageHeaders is
+// set. The headers follow as a URL encoded query, e.g. "content-type=json",
+// then SequenceSeparator and the payload:
+//
+//	kuromi.h:content-type=application%2Fjson&correlation-id=42
+//	{"price":1}
+//
+// Clients send headers the same way, messages without the prefix have none.
+const HeaderPrefix = "kuromi.h:"
+
+// Header is optional metadata of a message, e.g. its content type or a
+// correlation ID, so routing code does not have to parse payloads. Keys are
+// case-sensitive.
+type Header map[string]string
+
+// Get returns the value of key, or an empty string if h has none.
+func (h Header) Get(key string) string {
+	return h[key]
+}
+
+// encode returns the headers as URL encoded query with sorted keys.
+func (h Header) encode() string {
+	keys := make([]string, 0, len(h))
+	for key := range h {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	var b strings.Builder
+
+	for i, key := range keys {
+		if i > 0 {
+			b.WriteByte('&')
+		}
+
+		b.WriteString(url.QueryEscape(key))
+		b.WriteByte('=')
+		b.WriteString(url.QueryEscape(h[key]))
+	}
+
+	return b.String()
+}
+
+// SentMessage is a message written to a session, passed to HandleSent.
+type SentMessage struct {
+	Type   websocket.MessageType
+	Msg    []byte
+	Header Header // Nil if the message was written without headers.
+}
+
+func frameHeader(h Header, msg []byte) []byte {
+	query := h.encode()
+
+	b := make([]byte, 0, len(HeaderPrefix)+len(query)+1+len(msg))
+	b = append(b, HeaderPrefix...)
+	b = append(b, query...)
+	b = append(b, SequenceSeparator)
+
+	return append(b, msg...)
+}
+
+// parseHeader splits a message sent by a client into its headers and payload.
+// Messages without HeaderPrefix or with malformed headers are returned as they are.
+func parseHeader(message []byte) (Header, []byte) {
+	if !bytes.HasPrefix(message, []byte(HeaderPrefix)) {
+		return nil, message
+	}
+
+	query, payload, ok := bytes.Cut(message[len(HeaderPrefix):], []byte{SequenceSeparator})
+	if !ok {
+		return nil, message
+	}
+
+	values, err := url.ParseQuery(string(query))
+	if err != nil {
+		return nil, message
+	}
+
+	h := make(Header, len(values))
+	for key, v := range values {
+		h[key] = v[0]
+	}
+
+	return h, payload
+}
+
+type headerKey struct{}
+
+// HeaderFromContext returns the headers of the message passed to a message
+// handler with ctx, or nil if it has none. See Config.MessageHeaders.
+func HeaderFromContext(ctx context.Context) Header {
+	h, _ := ctx.Value(headerKey{}).(Header)
+
+	return h
+}
+
+func withHeader(ctx context.Context, h Header) context.Context {
+	if h == nil {
+		return ctx
+	}
+
+	return context.WithValue(ctx, headerKey{}, h)
+}
+
+// WriteWithHeader writes a text message with headers h to the session.
+func (s *Session) WriteWithHeader(msg []byte, h Header) error {
+	return s.write(envelope{t: websocket.MessageText, msg: msg, header: h})
+}
+
+// WriteBinaryWithHeader writes a binary message with headers h to the session.
+func (s *Session) WriteBinaryWithHeader(msg []byte, h Header) error {
+	return s.write(envelope{t: websocket.MessageBinary, msg: msg, header: h})
+}
+
+// BroadcastWithHeader broadcasts a text message with headers h to all sessions.
+func (k *Kuromi) BroadcastWithHeader(msg []byte, h Header) error {
+	return k.broadcast(context.Background(), envelope{t: websocket.MessageText, msg: msg, header: h})
+}
+
+// BroadcastBinaryWithHeader broadcasts a binary message with headers h to all sessions.
+func (k *Kuromi) BroadcastBinaryWithHeader(msg []byte, h Header) error {
+	return k.broadcast(context.Background(), envelope{t: websocket.MessageBinary, msg: msg, header: h})
+}
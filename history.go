@@ -0,0 +1,173 @@
+package kuromi
+
+import (
+	"sync"
+	"time"
+)
+
+// ring keeps the last messages written to it.
+type ring struct {
+	msgs []envelope
+	next int
+	full bool
+}
+
+func (r *ring) push(message envelope, size int) {
+	if len(r.msgs) != size {
+		r.resize(size)
+	}
+
+	r.msgs[r.next] = message
+	r.next = (r.next + 1) % size
+
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// resize keeps the most recent messages that fit in size.
+func (r *ring) resize(size int) {
+	msgs := r.all()
+	if len(msgs) > size {
+		msgs = msgs[len(msgs)-size:]
+	}
+
+	r.msgs = make([]envelope, size)
+	r.next = copy(r.msgs, msgs) % size
+	r.full = len(msgs) == size
+}
+
+// all returns the messages from oldest to newest.
+func (r *ring) all() []envelope {
+	if !r.full {
+		return append([]envelope(nil), r.msgs[:r.next]...)
+	}
+
+	msgs := make([]envelope, 0, len(r.msgs))
+	msgs = append(msgs, r.msgs[r.next:]...)
+
+	return append(msgs, r.msgs[:r.next]...)
+}
+
+// historyRing is the history of a room.
+type historyRing struct {
+	ring
+	last time.Time // Time of the last message.
+}
+
+type roomHistory struct {
+	mu    sync.Mutex
+	rooms map[string]*historyRing
+	swept time.Time
+}
+
+func newRoomHistory() *roomHistory {
+	return &roomHistory{
+		rooms: make(map[string]*historyRing),
+	}
+}
+
+// record keeps message in the history of room at time now, dropping the
+// histories without messages for ttl at most once per ttl.
+func (h *roomHistory) record(room string, message envelope, size int, ttl time.Duration, now time.Time) {
+	if size <= 0 {
+		return
+	}
+
+	message.filter = nil
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	r, ok := h.rooms[room]
+	if !ok {
+		r = &historyRing{}
+		h.rooms[room] = r
+	}
+
+	r.push(message, size)
+	r.last = now
+
+	if ttl > 0 && now.Sub(h.swept) >= ttl {
+		h.sweep(now.Add(-ttl))
+		h.swept = now
+	}
+}
+
+// sweep drops the histories without messages after cutoff, h.mu must be held.
+func (h *roomHistory) sweep(cutoff time.Time) {
+	for room, r := range h.rooms {
+		if !r.last.After(cutoff) {
+			delete(h.rooms, room)
+		}
+	}
+}
+
+// get returns the history of room at time now, dropping it if it has had no
+// messages for ttl.
+func (h *roomHistory) get(room string, ttl time.Duration, now time.Time) []envelope {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	r, ok := h.rooms[room]
+	if !ok {
+		return nil
+	}
+
+	if ttl > 0 && now.Sub(r.last) >= ttl {
+		delete(h.rooms, room)
+		return nil
+	}
+
+	return r.all()
+}
+
+func (h *roomHistory) names() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return keys(h.rooms)
+}
+
+func (h *roomHistory) clear(room string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	delete(h.rooms, room)
+}
+
+// RoomHistory returns the messages kept for room, oldest first, see Config.RoomHistorySize.
+// In a cluster the history is only kept by the owner of the room, see RoomOwner.
+func (k *Kuromi) RoomHistory(room string) [][]byte {
+	history := k.history.get(room, k.Config.RoomHistoryTTL, k.now())
+
+	msgs := make([][]byte, len(history))
+	for i, message := range history {
+		msgs[i] = message.msg
+	}
+
+	return msgs
+}
+
+// ClearRoomHistory drops the messages kept for room.
+func (k *Kuromi) ClearRoomHistory(room string) {
+	k.history.clear(room)
+}
+
+// replayHistory writes the messages kept for room to s.
+func (k *Kuromi) replayHistory(room string, s *Session) {
+	if k.authorize(s, room, RoomRead) != nil {
+		return
+	}
+
+	if k.clustered() {
+		if owner := k.RoomOwner(room); owner != k.Config.NodeID {
+			k.sendNode(owner, nodeMessage{Kind: nodeReplay, Room: room, Session: s.ID()})
+			return
+		}
+	}
+
+	for _, message := range k.history.get(room, k.Config.RoomHistoryTTL, k.now()) {
+		s.writeMessage(message)
+	}
+}
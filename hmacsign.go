@@ -0,0 +1,114 @@
+package kuromi
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"hash"
+	"net/http"
+
+	"github.com/coder/websocket"
+)
+
+// HMACSigner is a Transform signing messages written to sessions and verifying
+// the signature of messages sent by sessions with HMAC, using a secret per session,
+// so tampering by intermediaries is detected. Messages failing verification are
+// dropped and passed to the handler set with HandleVerifyFailure.
+//
+// The signature is appended to the message: binary messages end with the raw
+// MAC, text messages end with a dot followed by the base64url encoded MAC
+// without padding, so they remain valid UTF-8.
+type HMACSigner struct {
+	Secret func(*http.Request) ([]byte, error) // Returns the secret of the session upgraded from a request, e.g. looked up from its credentials.
+	Hash   func() hash.Hash                    // Hash function of the HMAC, sha256.New if nil.
+}
+
+// Handshake implements Transform.
+func (h HMACSigner) Handshake(w http.ResponseWriter, r *http.Request) (SessionTransform, error) {
+	if h.Secret == nil {
+		return nil, ErrNoSecret
+	}
+
+	secret, err := h.Secret(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(secret) == 0 {
+		return nil, ErrNoSecret
+	}
+
+	fn := h.Hash
+	if fn == nil {
+		fn = sha256.New
+	}
+
+	return &hmacSession{out: hmac.New(fn, secret), in: hmac.New(fn, secret)}, nil
+}
+
+// hmacSession is the SessionTransform of HMACSigner.
+// Outbound is only called by the write pump and Inbound by the read pump, so
+// each has its own hash.
+type hmacSession struct {
+	out hash.Hash
+	in  hash.Hash
+}
+
+// macSum returns the MAC of a message of type t.
+func macSum(mac hash.Hash, t websocket.MessageType, msg []byte) []byte {
+	mac.Reset()
+	mac.Write([]byte{byte(t)})
+	mac.Write(msg)
+	return mac.Sum(nil)
+}
+
+// Outbound implements SessionTransform.
+func (h *hmacSession) Outbound(t websocket.MessageType, msg []byte) (websocket.MessageType, []byte, error) {
+	sig := macSum(h.out, t, msg)
+
+	out := make([]byte, 0, len(msg)+1+base64.RawURLEncoding.EncodedLen(len(sig)))
+	out = append(out, msg...)
+
+	if t == websocket.MessageText {
+		out = append(out, '.')
+		out = base64.RawURLEncoding.AppendEncode(out, sig)
+	} else {
+		out = append(out, sig...)
+	}
+
+	return t, out, nil
+}
+
+// Inbound implements SessionTransform.
+func (h *hmacSession) Inbound(t websocket.MessageType, msg []byte) (websocket.MessageType, []byte, error) {
+	size := h.in.Size()
+
+	var body, sig []byte
+
+	if t == websocket.MessageText {
+		i := bytes.LastIndexByte(msg, '.')
+		if i < 0 {
+			return 0, nil, ErrBadSignature
+		}
+
+		var err error
+		if sig, err = base64.RawURLEncoding.DecodeString(string(msg[i+1:])); err != nil {
+			return 0, nil, ErrBadSignature
+		}
+
+		body = msg[:i]
+	} else {
+		if len(msg) < size {
+			return 0, nil, ErrBadSignature
+		}
+
+		body, sig = msg[:len(msg)-size], msg[len(msg)-size:]
+	}
+
+	if !hmac.Equal(sig, macSum(h.in, t, body)) {
+		return 0, nil, ErrBadSignature
+	}
+
+	return t, body, nil
+}
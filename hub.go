@@ -1,8 +1,10 @@
 package kuromi
 
 import (
+	"container/heap"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
 type sessionSet struct {
@@ -65,10 +67,15 @@ type hub struct {
 	register   chan *Session
 	unregister chan *Session
 	exit       chan envelope
+	schedule   chan *ScheduledMessage
+	scheduled  scheduleQueue
 	open       atomic.Bool
+	stats      hubCounters
+	workers    func() int
+	clock      func() Clock
 }
 
-func newHub() *hub {
+func newHub(workers func() int, clock func() Clock) *hub {
 	return &hub{
 		sessions: sessionSet{
 			members: make(map[*Session]struct{}),
@@ -77,27 +84,51 @@ func newHub() *hub {
 		register:   make(chan *Session),
 		unregister: make(chan *Session),
 		exit:       make(chan envelope),
+		schedule:   make(chan *ScheduledMessage),
+		workers:    workers,
+		clock:      clock,
 	}
 }
 
 func (h *hub) run() {
-	h.open.Store(true)
+	// The timer is created when a message is scheduled, so a clock set after
+	// New is used.
+	var timer Timer
+	defer func() {
+		if timer != nil {
+			timer.Stop()
+		}
+	}()
 
 loop:
 	for {
 		select {
 		case s := <-h.register:
+			h.stats.registers.Add(-1)
 			h.sessions.add(s)
 		case s := <-h.unregister:
+			h.stats.unregisters.Add(-1)
 			h.sessions.del(s)
 		case m := <-h.broadcast:
-			h.sessions.each(func(s *Session) {
-				if m.filter == nil {
-					s.writeMessage(m)
-				} else if m.filter(s) {
-					s.writeMessage(m)
+			h.stats.broadcasts.Add(-1)
+			h.deliver(m)
+		case sm := <-h.schedule:
+			heap.Push(&h.scheduled, sm)
+			timer = h.resetTimer(timer)
+		case now := <-timerC(timer):
+			for _, sm := range h.scheduled.due(now) {
+				if !sm.state.CompareAndSwap(schedulePending, scheduleFired) {
+					continue
 				}
-			})
+
+				if sm.session != nil {
+					sm.session.writeMessage(sm.message)
+				} else {
+					h.deliver(sm.message)
+				}
+			}
+
+			timer = h.resetTimer(timer)
 		case m := <-h.exit:
 			h.open.Store(false)
 
@@ -113,6 +144,120 @@ loop:
 	}
 }
 
+// fanOutMinSessions is the minimum number of sessions per broadcast worker,
+// smaller broadcasts are delivered by the hub loop alone.
+const fanOutMinSessions = 256
+
+// deliver writes a broadcast message to the sessions it is meant for.
+func (h *hub) deliver(m envelope) {
+	var report []Delivery
+
+	start := time.Now()
+
+	workers := h.workers()
+	if n := h.sessions.len(); workers > 1 && n >= 2*fanOutMinSessions {
+		report = h.fanOut(m, min(workers, n/fanOutMinSessions))
+	} else {
+		h.sessions.each(func(s *Session) {
+			if d, ok := h.deliverTo(s, m); ok && m.report != nil {
+				report = append(report, d)
+			}
+		})
+	}
+
+	h.stats.deliverDone(time.Since(start))
+
+	if m.done != nil {
+		m.done()
+	}
+
+	if m.report != nil {
+		m.report <- report
+	}
+}
+
+// fanOut delivers m to the sessions using workers goroutines and waits for
+// them, so messages are still queued for every session in broadcast order.
+func (h *hub) fanOut(m envelope, workers int) []Delivery {
+	sessions := h.sessions.all()
+	size := (len(sessions) + workers - 1) / workers
+
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		report []Delivery
+	)
+
+	for i := 0; i < len(sessions); i += size {
+		chunk := sessions[i:min(i+size, len(sessions))]
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			var part []Delivery
+			for _, s := range chunk {
+				if d, ok := h.deliverTo(s, m); ok && m.report != nil {
+					part = append(part, d)
+				}
+			}
+
+			if part != nil {
+				mu.Lock()
+				report = append(report, part...)
+				mu.Unlock()
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	return report
+}
+
+// deliverTo writes m to s if it passes the filter of m.
+func (h *hub) deliverTo(s *Session, m envelope) (Delivery, bool) {
+	if m.filter != nil && !m.filter(s) {
+		return Delivery{}, false
+	}
+
+	status := s.writeMessage(m)
+
+	if status == Delivered {
+		h.stats.delivered.Add(1)
+	} else {
+		h.stats.dropped.Add(1)
+	}
+
+	return Delivery{Session: s, Status: status}, true
+}
+
+// resetTimer stops timer and returns a timer firing when the first scheduled
+// message is due, or nil if no message is scheduled.
+func (h *hub) resetTimer(timer Timer) Timer {
+	if timer != nil {
+		timer.Stop()
+	}
+
+	clock := h.clock()
+
+	d, ok := h.scheduled.next(clock.Now())
+	if !ok {
+		return nil
+	}
+
+	return clock.NewTimer(d)
+}
+
+// timerC returns the channel of timer, or nil if timer is nil.
+func timerC(timer Timer) <-chan time.Time {
+	if timer == nil {
+		return nil
+	}
+
+	return timer.C()
+}
+
 func (h *hub) closed() bool {
 	return !h.open.Load()
 }
@@ -0,0 +1,69 @@
+package kuromi
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// HubStats describes the health of the hub, the single loop registering
+// sessions and delivering broadcasts. Growing pending counts or broadcast
+// times mean the hub is becoming a bottleneck.
+type HubStats struct {
+	PendingBroadcasts    int           `json:"pending_broadcasts"`     // Broadcasts waiting for the hub.
+	PendingRegisters     int           `json:"pending_registers"`      // Connecting sessions waiting for the hub.
+	PendingUnregisters   int           `json:"pending_unregisters"`    // Disconnecting sessions waiting for the hub.
+	Broadcasts           uint64        `json:"broadcasts"`             // Broadcasts delivered by the hub.
+	Delivered            uint64        `json:"delivered"`              // Broadcast messages queued for a session.
+	Dropped              uint64        `json:"dropped"`                // Broadcast messages dropped because a session buffer was full or the session closed.
+	Limited              uint64        `json:"limited"`                // Broadcasts dropped or refused by the broadcast rate limit.
+	BroadcastTime        time.Duration `json:"broadcast_time"`         // Total time spent delivering broadcasts.
+	MaxBroadcastTime     time.Duration `json:"max_broadcast_time"`     // Longest time spent delivering a single broadcast.
+	AverageBroadcastTime time.Duration `json:"average_broadcast_time"` // Average time spent delivering a broadcast.
+}
+
+type hubCounters struct {
+	broadcasts  atomic.Int64
+	registers   atomic.Int64
+	unregisters atomic.Int64
+	delivered   atomic.Uint64
+	dropped     atomic.Uint64
+	limited     atomic.Uint64
+	deliveries  atomic.Uint64
+	deliverTime atomic.Int64
+	deliverMax  atomic.Int64
+}
+
+func (c *hubCounters) deliverDone(d time.Duration) {
+	c.deliveries.Add(1)
+	c.deliverTime.Add(int64(d))
+
+	for {
+		max := c.deliverMax.Load()
+		if int64(d) <= max || c.deliverMax.CompareAndSwap(max, int64(d)) {
+			return
+		}
+	}
+}
+
+// HubStats returns the statistics of the hub.
+func (k *Kuromi) HubStats() HubStats {
+	c := &k.hub.stats
+
+	stats := HubStats{
+		PendingBroadcasts:  int(c.broadcasts.Load()),
+		PendingRegisters:   int(c.registers.Load()),
+		PendingUnregisters: int(c.unregisters.Load()),
+		Broadcasts:         c.deliveries.Load(),
+		Delivered:          c.delivered.Load(),
+		Dropped:            c.dropped.Load(),
+		Limited:            c.limited.Load(),
+		BroadcastTime:      time.Duration(c.deliverTime.Load()),
+		MaxBroadcastTime:   time.Duration(c.deliverMax.Load()),
+	}
+
+	if stats.Broadcasts > 0 {
+		stats.AverageBroadcastTime = stats.BroadcastTime / time.Duration(stats.Broadcasts)
+	}
+
+	return stats
+}
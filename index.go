@@ -0,0 +1,82 @@
+package kuromi
+
+import "sync"
+
+// sessionIndex maps keys to sets of sessions, e.g. user IDs to the sessions of a user.
+type sessionIndex[K comparable] struct {
+	mu      sync.RWMutex
+	members map[K]map[*Session]struct{}
+}
+
+func newSessionIndex[K comparable]() *sessionIndex[K] {
+	return &sessionIndex[K]{
+		members: make(map[K]map[*Session]struct{}),
+	}
+}
+
+func (ix *sessionIndex[K]) add(key K, s *Session) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	set, ok := ix.members[key]
+	if !ok {
+		set = make(map[*Session]struct{})
+		ix.members[key] = set
+	}
+
+	set[s] = struct{}{}
+}
+
+func (ix *sessionIndex[K]) del(key K, s *Session) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	set := ix.members[key]
+	delete(set, s)
+
+	if len(set) == 0 {
+		delete(ix.members, key)
+	}
+}
+
+func (ix *sessionIndex[K]) has(key K, s *Session) bool {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+
+	_, ok := ix.members[key][s]
+
+	return ok
+}
+
+func (ix *sessionIndex[K]) get(key K) []*Session {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+
+	set := ix.members[key]
+	sessions := make([]*Session, 0, len(set))
+
+	for s := range set {
+		sessions = append(sessions, s)
+	}
+
+	return sessions
+}
+
+func (ix *sessionIndex[K]) len(key K) int {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+
+	return len(ix.members[key])
+}
+
+func (ix *sessionIndex[K]) keys() []K {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+
+	keys := make([]K, 0, len(ix.members))
+	for key := range ix.members {
+		keys = append(keys, key)
+	}
+
+	return keys
+}
@@ -0,0 +1,74 @@
+package kuromi
+
+import (
+	"fmt"
+	"unicode/utf8"
+
+	"github.com/coder/websocket"
+)
+
+// InvalidMessagePolicy is what happens to malformed messages sent by sessions:
+// text messages that are not valid UTF-8 and, if Config.Codec is set, messages
+// it cannot decode.
+type InvalidMessagePolicy int
+
+const (
+	// InvalidMessageAllow passes invalid messages to the message handler, which is the default.
+	InvalidMessageAllow InvalidMessagePolicy = iota
+	// InvalidMessageDrop drops invalid messages.
+	InvalidMessageDrop
+	// InvalidMessageError drops invalid messages and passes an error to the error handler.
+	InvalidMessageError
+	// InvalidMessageClose drops invalid messages and closes the session with
+	// StatusInvalidFramePayloadData or StatusUnsupportedData.
+	InvalidMessageClose
+)
+
+// validateMessage applies Config.InvalidMessagePolicy to message and reports
+// whether it should be passed to the message handler.
+func (s *Session) validateMessage(t websocket.MessageType, message []byte) bool {
+	policy := s.kuromi.Config.InvalidMessagePolicy
+	if policy == InvalidMessageAllow {
+		return true
+	}
+
+	code, err := s.invalidMessage(t, message)
+	if err == nil {
+		return true
+	}
+
+	s.protect(func() { s.handlers.onInvalidMessage(s, message, err) })
+	s.recordFault()
+
+	switch policy {
+	case InvalidMessageError:
+		s.handlers.onError(s, err)
+	case InvalidMessageClose:
+		s.handlers.onError(s, err)
+
+		reason := ErrInvalidUTF8.Error()
+		if code == StatusUnsupportedData {
+			reason = ErrUndecodable.Error()
+		}
+
+		s.CloseWithMsg(code, reason)
+	}
+
+	return false
+}
+
+// invalidMessage returns the close status matching why message is invalid and the reason, or a nil error if it is valid.
+func (s *Session) invalidMessage(t websocket.MessageType, message []byte) (StatusCode, error) {
+	if t == websocket.MessageText && !utf8.Valid(message) {
+		return StatusInvalidFramePayloadData, ErrInvalidUTF8
+	}
+
+	if c := s.kuromi.Config.Codec; c != nil {
+		var v any
+		if err := c.Unmarshal(message, &v); err != nil {
+			return StatusUnsupportedData, fmt.Errorf("%w: %w", ErrUndecodable, err)
+		}
+	}
+
+	return 0, nil
+}
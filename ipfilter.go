@@ -0,0 +1,187 @@
+package kuromi
+
+import (
+	"net/http"
+	"net/netip"
+	"sort"
+	"sync"
+)
+
+// IPFilter is a runtime-mutable allow and deny list of IP addresses and CIDR
+// prefixes checked against the client IP of requests before they are upgraded,
+// see Session.ClientIP. Denied addresses are always rejected; if the allow list
+// is not empty only addresses on it are accepted.
+type IPFilter struct {
+	mu       sync.RWMutex
+	allow    map[netip.Prefix]struct{}
+	deny     map[netip.Prefix]struct{}
+	onChange func(allow, deny []string)
+}
+
+func newIPFilter() *IPFilter {
+	return &IPFilter{
+		allow: make(map[netip.Prefix]struct{}),
+		deny:  make(map[netip.Prefix]struct{}),
+	}
+}
+
+// IPFilter returns the IP allow and deny list of the kuromi instance.
+func (k *Kuromi) IPFilter() *IPFilter {
+	return k.ipFilter
+}
+
+func parsePrefix(s string) (netip.Prefix, error) {
+	if prefix, err := netip.ParsePrefix(s); err == nil {
+		return prefix.Masked(), nil
+	}
+
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+
+	addr = addr.Unmap()
+
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+func (f *IPFilter) update(list map[netip.Prefix]struct{}, entries []string, add bool) error {
+	prefixes := make([]netip.Prefix, 0, len(entries))
+	for _, e := range entries {
+		prefix, err := parsePrefix(e)
+		if err != nil {
+			return err
+		}
+		prefixes = append(prefixes, prefix)
+	}
+
+	f.mu.Lock()
+	for _, prefix := range prefixes {
+		if add {
+			list[prefix] = struct{}{}
+		} else {
+			delete(list, prefix)
+		}
+	}
+	f.mu.Unlock()
+
+	f.changed()
+
+	return nil
+}
+
+// Allow adds IP addresses or CIDR prefixes to the allow list.
+func (f *IPFilter) Allow(entries ...string) error {
+	return f.update(f.allow, entries, true)
+}
+
+// Disallow removes IP addresses or CIDR prefixes from the allow list.
+func (f *IPFilter) Disallow(entries ...string) error {
+	return f.update(f.allow, entries, false)
+}
+
+// Deny adds IP addresses or CIDR prefixes to the deny list.
+func (f *IPFilter) Deny(entries ...string) error {
+	return f.update(f.deny, entries, true)
+}
+
+// Undeny removes IP addresses or CIDR prefixes from the deny list.
+func (f *IPFilter) Undeny(entries ...string) error {
+	return f.update(f.deny, entries, false)
+}
+
+// Load replaces both lists, e.g. with lists persisted by the change handler.
+func (f *IPFilter) Load(allow, deny []string) error {
+	a, d := make(map[netip.Prefix]struct{}), make(map[netip.Prefix]struct{})
+
+	for _, l := range []struct {
+		entries []string
+		set     map[netip.Prefix]struct{}
+	}{{allow, a}, {deny, d}} {
+		for _, e := range l.entries {
+			prefix, err := parsePrefix(e)
+			if err != nil {
+				return err
+			}
+			l.set[prefix] = struct{}{}
+		}
+	}
+
+	f.mu.Lock()
+	f.allow, f.deny = a, d
+	f.mu.Unlock()
+
+	return nil
+}
+
+// HandleChange fires fn with both lists after they were modified with Allow,
+// Disallow, Deny or Undeny, e.g. to persist them.
+func (f *IPFilter) HandleChange(fn func(allow, deny []string)) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.onChange = fn
+}
+
+// Lists returns the allow and deny lists.
+func (f *IPFilter) Lists() (allow, deny []string) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	return prefixStrings(f.allow), prefixStrings(f.deny)
+}
+
+// Allowed reports whether the IP address ip passes the filter.
+func (f *IPFilter) Allowed(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+
+	addr = addr.Unmap()
+
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	if containsAddr(f.deny, addr) {
+		return false
+	}
+
+	return len(f.allow) == 0 || containsAddr(f.allow, addr)
+}
+
+func (f *IPFilter) changed() {
+	f.mu.RLock()
+	fn := f.onChange
+	f.mu.RUnlock()
+
+	if fn != nil {
+		fn(f.Lists())
+	}
+}
+
+func containsAddr(set map[netip.Prefix]struct{}, addr netip.Addr) bool {
+	for prefix := range set {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func prefixStrings(set map[netip.Prefix]struct{}) []string {
+	s := make([]string, 0, len(set))
+	for prefix := range set {
+		s = append(s, prefix.String())
+	}
+
+	sort.Strings(s)
+
+	return s
+}
+
+// allowedRequest reports whether the client IP of r passes the IP filter.
+func (k *Kuromi) allowedRequest(r *http.Request) bool {
+	return k.ipFilter.Allowed(clientIP(r.RemoteAddr, r.Header.Values, k.Config))
+}
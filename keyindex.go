@@ -0,0 +1,109 @@
+package kuromi
+
+import (
+	"context"
+	"reflect"
+
+	"github.com/coder/websocket"
+)
+
+// keyValue is a key of Session.Keys with its value, used to index sessions by their keys.
+type keyValue struct {
+	key   string
+	value any
+}
+
+// BroadcastToKey broadcasts a text message to all sessions whose Keys hold
+// value under key, e.g. BroadcastToKey("user_id", id, msg). Values are
+// compared with ==, so an int does not match an int64 with the same value.
+//
+// Sessions are looked up in an index instead of scanning all sessions. The
+// index is kept up to date by Set and UnSet and covers the keys a session
+// connected with, keys written directly to Session.Keys are not indexed.
+// Values that are not comparable, e.g. slices, are not indexed either.
+func (k *Kuromi) BroadcastToKey(key string, value any, msg []byte) error {
+	return k.broadcastToKey(keyValue{key, value}, envelope{t: websocket.MessageText, msg: msg})
+}
+
+// BroadcastBinaryToKey broadcasts a binary message to all sessions whose Keys
+// hold value under key, see BroadcastToKey.
+func (k *Kuromi) BroadcastBinaryToKey(key string, value any, msg []byte) error {
+	return k.broadcastToKey(keyValue{key, value}, envelope{t: websocket.MessageBinary, msg: msg})
+}
+
+func (k *Kuromi) broadcastToKey(kv keyValue, message envelope) error {
+	if k.hub.closed() {
+		return ErrClosed
+	}
+
+	if !indexable(kv.value) {
+		return nil
+	}
+
+	if ok, err := k.admitBroadcast(context.Background(), len(message.msg)); !ok {
+		return err
+	}
+
+	for _, s := range k.keyIndex.get(kv) {
+		s.writeMessage(message)
+	}
+
+	return nil
+}
+
+// indexable reports whether value can be used as a key of the key index.
+func indexable(value any) bool {
+	return value != nil && reflect.ValueOf(value).Comparable()
+}
+
+// indexKey updates the key index for key, s.rwmutex must be held.
+func (s *Session) indexKey(key string, value any) {
+	if !s.indexing {
+		return
+	}
+
+	s.unindexKey(key)
+
+	if !indexable(value) {
+		return
+	}
+
+	if s.indexed == nil {
+		s.indexed = make(map[string]any)
+	}
+
+	s.indexed[key] = value
+	s.kuromi.keyIndex.add(keyValue{key, value}, s)
+}
+
+// unindexKey removes key from the key index, s.rwmutex must be held.
+func (s *Session) unindexKey(key string) {
+	if old, ok := s.indexed[key]; ok {
+		s.kuromi.keyIndex.del(keyValue{key, old}, s)
+		delete(s.indexed, key)
+	}
+}
+
+// startIndexing adds the keys of a connected session to the key index.
+func (s *Session) startIndexing() {
+	s.rwmutex.Lock()
+	defer s.rwmutex.Unlock()
+
+	s.indexing = true
+
+	for key, value := range s.Keys {
+		s.indexKey(key, value)
+	}
+}
+
+// stopIndexing removes a disconnected session from the key index.
+func (s *Session) stopIndexing() {
+	s.rwmutex.Lock()
+	defer s.rwmutex.Unlock()
+
+	s.indexing = false
+
+	for key := range s.indexed {
+		s.unindexKey(key)
+	}
+}
@@ -0,0 +1,66 @@
+package kuromi
+
+import "time"
+
+// keyTimer expires a value stored with SetWithTTL.
+type keyTimer struct {
+	timer Timer
+}
+
+// SetWithTTL stores a new key/value pair for this session like Set, removing
+// it again after d, e.g. for rate limit counters or temporary permissions.
+// The handler set with HandleKeyExpired fires when the value expires. Setting
+// or removing the key before it expires cancels the expiry.
+func (s *Session) SetWithTTL(key string, value any, d time.Duration) {
+	s.rwmutex.Lock()
+	defer s.rwmutex.Unlock()
+
+	s.set(key, value)
+
+	if s.keyTimers == nil {
+		s.keyTimers = make(map[string]*keyTimer)
+	}
+
+	kt := &keyTimer{}
+	kt.timer = s.kuromi.clock().AfterFunc(d, func() { s.expireKey(key, kt) })
+
+	s.keyTimers[key] = kt
+}
+
+// expireKey removes key if it was last set with kt.
+func (s *Session) expireKey(key string, kt *keyTimer) {
+	s.rwmutex.Lock()
+
+	if s.keyTimers[key] != kt {
+		s.rwmutex.Unlock()
+		return
+	}
+
+	delete(s.keyTimers, key)
+
+	value := s.Keys[key]
+	delete(s.Keys, key)
+	s.unindexKey(key)
+
+	s.rwmutex.Unlock()
+
+	s.protect(func() { s.handlers.onKeyExpired(s, key, value) })
+}
+
+// stopKeyTimer cancels the expiry of key, s.rwmutex must be held.
+func (s *Session) stopKeyTimer(key string) {
+	if kt, ok := s.keyTimers[key]; ok {
+		kt.timer.Stop()
+		delete(s.keyTimers, key)
+	}
+}
+
+// stopKeyTimers cancels the expiry of all keys of a disconnected session.
+func (s *Session) stopKeyTimers() {
+	s.rwmutex.Lock()
+	defer s.rwmutex.Unlock()
+
+	for key := range s.keyTimers {
+		s.stopKeyTimer(key)
+	}
+}
@@ -1,8 +1,12 @@
 package kuromi
 
 import (
+	"context"
+	"errors"
 	"net/http"
+	"slices"
 	"sync"
+	"sync/atomic"
 
 	"github.com/coder/websocket"
 )
@@ -11,183 +15,450 @@ const (
 	CloseMessage websocket.MessageType = websocket.MessageText + 1000
 )
 
-type handleMessageFunc func(*Session, []byte)
-type handleErrorFunc func(*Session, error)
-type handleCloseFunc func(*Session, int, string) error
-type handleSessionFunc func(*Session)
-type filterFunc func(*Session) bool
-
 // Kuromi implements a websocket manager.
 type Kuromi struct {
-	Config                   *Config
-	AcceptOptions            *websocket.AcceptOptions
-	messageHandler           handleMessageFunc
-	messageHandlerBinary     handleMessageFunc
-	messageSentHandler       handleMessageFunc
-	messageSentHandlerBinary handleMessageFunc
-	errorHandler             handleErrorFunc
-	closeHandler             handleCloseFunc
-	connectHandler           handleSessionFunc
-	disconnectHandler        handleSessionFunc
-	pongHandler              handleSessionFunc
-	hub                      *hub
+	handlers
+	Config          *Config
+	AcceptOptions   *websocket.AcceptOptions
+	hub             *hub
+	broadcastLimit  broadcastLimiter
+	pool            *workerPool
+	poolOnce        sync.Once
+	nextID          atomic.Uint64
+	routes          map[string]*Route
+	routesMu        sync.RWMutex
+	namespaces      map[string]*Namespace
+	namespacesMu    sync.RWMutex
+	protocols       map[string]*Subprotocol
+	protocolNames   []string
+	versions        map[string]*Version
+	versionNames    []string
+	protocolsMu     sync.RWMutex
+	checkOrigin     func(*http.Request) bool
+	csrfCheck       func(*http.Request) bool
+	authorizer      Authorizer
+	ipFilter        *IPFilter
+	bans            *banRegistry
+	floodHandler    func(*Session, error, Penalty) Penalty
+	webhooks        webhooks
+	audits          auditLog
+	users           *sessionIndex[string]
+	rooms           *roomRegistry
+	roomMetrics     *roomMetrics
+	history         *roomHistory
+	tags            *sessionIndex[string]
+	roles           *sessionIndex[string]
+	keyIndex        *sessionIndex[keyValue]
+	newData         func() any
+	shutdown        shutdownState
+	shuttingDown    atomic.Bool
+	topics          *topicTree
+	resumes         *resumeStore
+	acks            *ackRegistry
+	traffic         trafficCounter
+	presenceHandler func(PresenceDiff)
+	cluster         clusterState
 }
 
-// New creates a new kuromi instance with default Upgrader and Config.
-func New() *Kuromi {
-	hub := newHub()
-
-	go hub.run()
-
-	return &Kuromi{
-		Config:                   newConfig(),
-		AcceptOptions:            nil,
-		messageHandler:           func(*Session, []byte) {},
-		messageHandlerBinary:     func(*Session, []byte) {},
-		messageSentHandler:       func(*Session, []byte) {},
-		messageSentHandlerBinary: func(*Session, []byte) {},
-		errorHandler:             func(*Session, error) {},
-		closeHandler:             nil,
-		connectHandler:           func(*Session) {},
-		disconnectHandler:        func(*Session) {},
-		pongHandler:              func(*Session) {},
-		hub:                      hub,
+// New creates a new kuromi instance with default Upgrader and Config, modified by opts.
+// It panics if opts result in an invalid Config, use NewWithOptions to handle the error instead.
+func New(opts ...Option) *Kuromi {
+	k, err := NewWithOptions(opts...)
+	if err != nil {
+		panic(err)
 	}
-}
 
-// HandleConnect fires fn when a session connects.
-func (k *Kuromi) HandleConnect(fn func(*Session)) {
-	k.connectHandler = fn
+	return k
 }
 
-// HandleDisconnect fires fn when a session disconnects.
-func (k *Kuromi) HandleDisconnect(fn func(*Session)) {
-	k.disconnectHandler = fn
-}
+// NewWithOptions creates a new kuromi instance modified by opts.
+// It returns an error wrapping ErrInvalidConfig if the resulting Config is invalid.
+func NewWithOptions(opts ...Option) (*Kuromi, error) {
+	k := &Kuromi{
+		Config:        newConfig(),
+		AcceptOptions: nil,
+		routes:        make(map[string]*Route),
+		namespaces:    make(map[string]*Namespace),
+		protocols:     make(map[string]*Subprotocol),
+		versions:      make(map[string]*Version),
+		users:         newSessionIndex[string](),
+		rooms:         newRoomRegistry(),
+		roomMetrics:   newRoomMetrics(),
+		history:       newRoomHistory(),
+		tags:          newSessionIndex[string](),
+		roles:         newSessionIndex[string](),
+		keyIndex:      newSessionIndex[keyValue](),
+		topics:        newTopicTree(),
+		resumes:       newResumeStore(),
+		acks:          newAckRegistry(),
+		ipFilter:      newIPFilter(),
+		bans:          newBanRegistry(),
+	}
 
-// HandlePong fires fn when a pong is received from a session.
-func (k *Kuromi) HandlePong(fn func(*Session)) {
-	k.pongHandler = fn
-}
+	for _, opt := range opts {
+		opt(k)
+	}
 
-// HandleMessage fires fn when a text message comes in.
-// NOTE: by default Kuromi handles messages sequentially for each
-// session. This has the effect that a message handler exceeding the
-// read deadline (Config.PongWait, by default 1 minute) will time out
-// the session. Concurrent message handling can be turned on by setting
-// Config.ConcurrentMessageHandling to true.
-func (k *Kuromi) HandleMessage(fn func(*Session, []byte)) {
-	k.messageHandler = fn
-}
+	if k.Config == nil {
+		return nil, invalidConfig("Config must not be nil")
+	}
 
-// HandleMessageBinary fires fn when a binary message comes in.
-func (k *Kuromi) HandleMessageBinary(fn func(*Session, []byte)) {
-	k.messageHandlerBinary = fn
-}
+	if err := k.Config.Validate(); err != nil {
+		return nil, err
+	}
 
-// HandleSentMessage fires fn when a text message is successfully sent.
-func (k *Kuromi) HandleSentMessage(fn func(*Session, []byte)) {
-	k.messageSentHandler = fn
-}
+	k.hub = newHub(func() int { return k.Config.BroadcastWorkers }, k.clock)
 
-// HandleSentMessageBinary fires fn when a binary message is successfully sent.
-func (k *Kuromi) HandleSentMessageBinary(fn func(*Session, []byte)) {
-	k.messageSentHandlerBinary = fn
-}
+	// Open the hub before run is scheduled, sessions connecting right after
+	// New must not be rejected.
+	k.hub.open.Store(true)
 
-// HandleError fires fn when a session has an error.
-func (k *Kuromi) HandleError(fn func(*Session, error)) {
-	k.errorHandler = fn
-}
+	go k.hub.run()
 
-// HandleClose sets the handler for close messages received from the session.
-// The code argument to h is the received close code or CloseNoStatusReceived
-// if the close message is empty. The default close handler sends a close frame
-// back to the session.
-//
-// The application must read the connection to process close messages as
-// described in the section on Control Frames above.
-//
-// The connection read methods return a CloseError when a close frame is
-// received. Most applications should handle close messages as part of their
-// normal error handling. Applications should only set a close handler when the
-// application must perform some action before sending a close frame back to
-// the session.
-func (k *Kuromi) HandleClose(fn func(*Session, int, string) error) {
-	if fn != nil {
-		k.closeHandler = fn
-	}
+	return k, nil
 }
 
 // HandleRequest upgrades http requests to websocket connections and dispatches them to be handled by the kuromi instance.
+// Websockets bootstrapped over HTTP/2 with extended CONNECT (RFC 8441) are accepted as well,
+// which net/http servers support for HTTP/2 connections.
 func (k *Kuromi) HandleRequest(w http.ResponseWriter, r *http.Request) error {
 	return k.HandleRequestWithKeys(w, r, nil)
 }
 
 // HandleRequestWithKeys does the same as HandleRequest but populates session.Keys with keys.
+// When the session resumes a disconnected session, keys take precedence over the restored Keys.
 func (k *Kuromi) HandleRequestWithKeys(w http.ResponseWriter, r *http.Request, keys map[string]any) error {
-	if k.hub.closed() {
+	return k.handleRequest(w, r, keys, nil)
+}
+
+// handleRequest upgrades the request and serves the session with the handlers of route,
+// or the handlers of the kuromi instance if route is nil.
+func (k *Kuromi) handleRequest(w http.ResponseWriter, r *http.Request, keys map[string]any, route *Route) error {
+	if k.hub.closed() || k.shuttingDown.Load() {
 		return ErrClosed
 	}
 
-	c, err := websocket.Accept(w, r, k.AcceptOptions)
+	var namespace *Namespace
+
+	if name := k.namespaceName(r); name != "" {
+		ns, ok := k.lookupNamespace(name)
+		if !ok {
+			http.Error(w, ErrUnknownNamespace.Error(), http.StatusNotFound)
+			return ErrUnknownNamespace
+		}
+
+		namespace = ns
+	}
 
+	if !k.allowedRequest(r) {
+		http.Error(w, ErrIPDenied.Error(), http.StatusForbidden)
+		return ErrIPDenied
+	}
+
+	if k.bans.banned(k.bans.ips, clientIP(r.RemoteAddr, r.Header.Values, k.Config), k.now()) {
+		http.Error(w, ErrBanned.Error(), http.StatusForbidden)
+		return ErrBanned
+	}
+
+	if k.checkOrigin != nil && !k.checkOrigin(r) {
+		http.Error(w, ErrOriginNotAllowed.Error(), http.StatusForbidden)
+		return ErrOriginNotAllowed
+	}
+
+	if k.csrfCheck != nil && !k.csrfCheck(r) {
+		http.Error(w, ErrCrossSite.Error(), http.StatusForbidden)
+		return ErrCrossSite
+	}
+
+	if err := k.joinCluster(); err != nil {
+		http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+		return err
+	}
+
+	transforms, err := k.handshakeTransforms(w, r)
 	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return err
 	}
 
+	cookieID := k.bindCookie(w, r)
+
+	var c Transport
+
+	if k.Config.EnableSSE && isEventStream(r) {
+		c, err = acceptSSE(w, r)
+	} else if isExtendedConnect(r) {
+		c, err = acceptExtendedConnect(w, r, k.acceptOptions())
+	} else {
+		c, err = websocket.Accept(w, r, k.acceptOptions())
+	}
+
+	if err != nil {
+		return err
+	}
+
+	var subprotocol string
+	if conn, ok := c.(*websocket.Conn); ok {
+		subprotocol = conn.Subprotocol()
+	}
+
+	if k.Config.WrapTransport != nil {
+		c = k.Config.WrapTransport(c)
+	}
+
+	session := k.newSession(r, keys, c, route, namespace, subprotocol)
+	session.cookieID = cookieID
+	session.transforms = transforms
+
+	k.serve(session)
+
+	return nil
+}
+
+// newSession creates a session for the connection c accepted for r.
+func (k *Kuromi) newSession(r *http.Request, keys map[string]any, c Transport, route *Route, namespace *Namespace, subprotocol string) *Session {
 	session := &Session{
-		Request:    r,
-		Keys:       keys,
-		conn:       c,
-		output:     make(chan envelope, k.Config.MessageBufferSize),
-		outputDone: make(chan struct{}),
-		kuromi:     k,
-		open:       true,
-		rwmutex:    &sync.RWMutex{},
+		id:          k.nextID.Add(1),
+		Request:     r,
+		Keys:        contextKeys(r, keys, k.Config.ContextKeys),
+		conn:        c,
+		output:      make(chan envelope, k.Config.MessageBufferSize),
+		control:     make(chan envelope, controlBufferSize),
+		outputDone:  make(chan struct{}),
+		kuromi:      k,
+		handlers:    &k.handlers,
+		route:       route,
+		namespace:   namespace,
+		open:        true,
+		rwmutex:     &sync.RWMutex{},
+		connectedAt: k.now(),
+		subprotocol: subprotocol,
+	}
+
+	session.correlationID = newCorrelationID(r.Header.Get(k.Config.CorrelationHeader))
+
+	if k.Config.BulkBufferSize > 0 {
+		session.bulk = make(chan envelope, k.Config.BulkBufferSize)
+	}
+
+	if k.newData != nil {
+		session.data = k.newData()
+	}
+
+	if namespace != nil {
+		session.handlers = &namespace.handlers
+	} else if route != nil {
+		session.handlers = &route.handlers
+	} else if sp := k.lookupSubprotocol(subprotocol); sp != nil {
+		session.handlers = &sp.handlers
+	}
+
+	if name, ok := k.versionOfSubprotocol(subprotocol); ok {
+		session.setVersion(k.lookupVersion(name))
+	}
+
+	return session
+}
+
+// contextKeys returns keys with the values of the context of r for
+// Config.ContextKeys added, keeping keys unchanged.
+func contextKeys(r *http.Request, keys map[string]any, ctxKeys map[string]any) map[string]any {
+	if len(ctxKeys) == 0 {
+		return keys
+	}
+
+	merged := make(map[string]any, len(keys)+len(ctxKeys))
+
+	for name, key := range ctxKeys {
+		if value := r.Context().Value(key); value != nil {
+			merged[name] = value
+		}
+	}
+
+	for name, value := range keys {
+		merged[name] = value
+	}
+
+	return merged
+}
+
+// serve runs session until it disconnects.
+func (k *Kuromi) serve(session *Session) {
+	if k.Config.ResumeGracePeriod > 0 {
+		session.resumeToken = newResumeToken()
+	}
+
+	var connectErr error
+
+	// The version is negotiated before the session is registered, so its
+	// handlers are set before broadcasts reach it and the answer is written
+	// first.
+	if k.Config.VersionHandshake && session.version == "" {
+		connectErr = session.negotiateVersion()
 	}
 
+	k.hub.stats.registers.Add(1)
 	k.hub.register <- session
 
-	k.connectHandler(session)
+	if session.resumeToken != "" {
+		k.resume(session)
+	}
+
+	session.startIndexing()
 
-	go session.writePump()
+	if connectErr == nil {
+		session.protect(func() { connectErr = session.handlers.onConnect(session) })
+	}
 
-	session.readPump()
+	if connectErr != nil {
+		code, reason := StatusPolicyViolation, ""
+
+		var se *StatusError
+		if errors.As(connectErr, &se) {
+			code, reason = se.Code, se.Reason
+		}
+
+		session.closeWithMsg(code, reason)
+	} else {
+		k.emit(WebhookConnect, session, "")
+
+		go session.writePump()
+
+		session.readPump()
+	}
 
 	if !k.hub.closed() {
+		k.hub.stats.unregisters.Add(1)
 		k.hub.unregister <- session
 	}
 
 	session.close()
 
-	k.disconnectHandler(session)
+	if connectErr == nil {
+		k.park(session)
+	}
 
-	return nil
+	session.deadLetterQueued()
+
+	k.untrack(session)
+
+	session.protect(func() { session.handlers.onDisconnect(session) })
+
+	if connectErr == nil {
+		k.emit(WebhookDisconnect, session, "")
+	}
 }
 
-// Broadcast broadcasts a text message to all sessions.
-func (k *Kuromi) Broadcast(msg []byte) error {
-	if k.hub.closed() {
-		return ErrClosed
+// untrack removes a disconnected session from the registries of the kuromi instance.
+func (k *Kuromi) untrack(s *Session) {
+	if user := s.UserID(); user != "" {
+		k.users.del(user, s)
 	}
 
-	message := envelope{t: websocket.MessageText, msg: msg}
-	k.hub.broadcast <- message
+	k.leaveRooms(s)
+	k.untag(s)
+	k.unrole(s)
+	k.unsubscribeAll(s)
+	s.stopIndexing()
+	s.stopKeyTimers()
+}
 
-	return nil
+// ServeHTTP implements http.Handler so the kuromi instance can be mounted
+// directly on a router. It upgrades the request the same way as HandleRequest.
+//
+// Requests for a path registered with Route are served with the handlers of that route.
+func (k *Kuromi) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	k.serveHTTP(w, r, nil, k.lookupRoute(r.URL.Path))
+}
+
+// Handler returns an http.Handler that upgrades requests and populates session.Keys
+// with the keys returned by keysFn.
+func (k *Kuromi) Handler(keysFn func(*http.Request) map[string]any) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var keys map[string]any
+
+		if keysFn != nil {
+			keys = keysFn(r)
+		}
+
+		k.serveHTTP(w, r, keys, k.lookupRoute(r.URL.Path))
+	})
+}
+
+func (k *Kuromi) serveHTTP(w http.ResponseWriter, r *http.Request, keys map[string]any, route *Route) {
+	if err := k.handleRequest(w, r, keys, route); errors.Is(err, ErrClosed) {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+	}
+}
+
+// acceptOptions returns AcceptOptions with the registered subprotocols added.
+// The origin check of the websocket library is skipped if CheckOrigin is used
+// and compression is turned on if Config.CompressionThreshold is set.
+func (k *Kuromi) acceptOptions() *websocket.AcceptOptions {
+	k.protocolsMu.RLock()
+	defer k.protocolsMu.RUnlock()
+
+	versioned := k.Config.VersionSubprotocol != "" && len(k.versionNames) > 0
+
+	if len(k.protocolNames) == 0 && !versioned && k.checkOrigin == nil && k.Config.CompressionThreshold <= 0 {
+		return k.AcceptOptions
+	}
+
+	opts := &websocket.AcceptOptions{}
+	if k.AcceptOptions != nil {
+		*opts = *k.AcceptOptions
+	}
+
+	opts.Subprotocols = slices.Clone(opts.Subprotocols)
+	for _, name := range k.protocolNames {
+		if !slices.Contains(opts.Subprotocols, name) {
+			opts.Subprotocols = append(opts.Subprotocols, name)
+		}
+	}
+
+	if versioned {
+		for _, name := range k.versionNames {
+			if name := k.Config.VersionSubprotocol + name; !slices.Contains(opts.Subprotocols, name) {
+				opts.Subprotocols = append(opts.Subprotocols, name)
+			}
+		}
+	}
+
+	if k.checkOrigin != nil {
+		opts.InsecureSkipVerify = true
+	}
+
+	if k.Config.CompressionThreshold > 0 {
+		if opts.CompressionMode == websocket.CompressionDisabled {
+			opts.CompressionMode = websocket.CompressionNoContextTakeover
+		}
+
+		opts.CompressionThreshold = k.Config.CompressionThreshold
+	}
+
+	return opts
+}
+
+// Broadcast broadcasts a text message to all sessions.
+func (k *Kuromi) Broadcast(msg []byte) error {
+	return k.broadcast(context.Background(), envelope{t: websocket.MessageText, msg: msg})
 }
 
 // BroadcastFilter broadcasts a text message to all sessions that fn returns true for.
 func (k *Kuromi) BroadcastFilter(msg []byte, fn func(*Session) bool) error {
-	if k.hub.closed() {
-		return ErrClosed
-	}
+	return k.broadcast(context.Background(), envelope{t: websocket.MessageText, msg: msg, filter: fn})
+}
 
-	message := envelope{t: websocket.MessageText, msg: msg, filter: fn}
-	k.hub.broadcast <- message
+// BroadcastContext broadcasts a text message to all sessions.
+// It returns ctx.Err() if ctx is done before the hub accepts the message.
+func (k *Kuromi) BroadcastContext(ctx context.Context, msg []byte) error {
+	return k.broadcast(ctx, envelope{t: websocket.MessageText, msg: msg})
+}
 
-	return nil
+// BroadcastFilterContext broadcasts a text message to all sessions that fn returns true for.
+// It returns ctx.Err() if ctx is done before the hub accepts the message.
+func (k *Kuromi) BroadcastFilterContext(ctx context.Context, msg []byte, fn func(*Session) bool) error {
+	return k.broadcast(ctx, envelope{t: websocket.MessageText, msg: msg, filter: fn})
 }
 
 // BroadcastOthers broadcasts a text message to all sessions except session s.
@@ -199,6 +470,10 @@ func (k *Kuromi) BroadcastOthers(msg []byte, s *Session) error {
 
 // BroadcastMultiple broadcasts a text message to multiple sessions given in the sessions slice.
 func (k *Kuromi) BroadcastMultiple(msg []byte, sessions []*Session) error {
+	if ok, err := k.admitBroadcast(context.Background(), len(msg)); !ok {
+		return err
+	}
+
 	for _, sess := range sessions {
 		if writeErr := sess.Write(msg); writeErr != nil {
 			return writeErr
@@ -207,28 +482,32 @@ func (k *Kuromi) BroadcastMultiple(msg []byte, sessions []*Session) error {
 	return nil
 }
 
+// BroadcastReport broadcasts a text message to all sessions and returns the delivery status for each of them.
+// Delivered only means that the message was queued for the session, not that it was written to the connection.
+func (k *Kuromi) BroadcastReport(msg []byte) ([]Delivery, error) {
+	return k.broadcastReport(envelope{t: websocket.MessageText, msg: msg})
+}
+
 // BroadcastBinary broadcasts a binary message to all sessions.
 func (k *Kuromi) BroadcastBinary(msg []byte) error {
-	if k.hub.closed() {
-		return ErrClosed
-	}
-
-	message := envelope{t: websocket.MessageBinary, msg: msg}
-	k.hub.broadcast <- message
-
-	return nil
+	return k.broadcast(context.Background(), envelope{t: websocket.MessageBinary, msg: msg})
 }
 
 // BroadcastBinaryFilter broadcasts a binary message to all sessions that fn returns true for.
 func (k *Kuromi) BroadcastBinaryFilter(msg []byte, fn func(*Session) bool) error {
-	if k.hub.closed() {
-		return ErrClosed
-	}
+	return k.broadcast(context.Background(), envelope{t: websocket.MessageBinary, msg: msg, filter: fn})
+}
 
-	message := envelope{t: websocket.MessageBinary, msg: msg, filter: fn}
-	k.hub.broadcast <- message
+// BroadcastBinaryContext broadcasts a binary message to all sessions.
+// It returns ctx.Err() if ctx is done before the hub accepts the message.
+func (k *Kuromi) BroadcastBinaryContext(ctx context.Context, msg []byte) error {
+	return k.broadcast(ctx, envelope{t: websocket.MessageBinary, msg: msg})
+}
 
-	return nil
+// BroadcastBinaryFilterContext broadcasts a binary message to all sessions that fn returns true for.
+// It returns ctx.Err() if ctx is done before the hub accepts the message.
+func (k *Kuromi) BroadcastBinaryFilterContext(ctx context.Context, msg []byte, fn func(*Session) bool) error {
+	return k.broadcast(ctx, envelope{t: websocket.MessageBinary, msg: msg, filter: fn})
 }
 
 // BroadcastBinaryOthers broadcasts a binary message to all sessions except session s.
@@ -238,6 +517,52 @@ func (k *Kuromi) BroadcastBinaryOthers(msg []byte, s *Session) error {
 	})
 }
 
+// BroadcastBinaryReport broadcasts a binary message to all sessions and returns the delivery status for each of them.
+func (k *Kuromi) BroadcastBinaryReport(msg []byte) ([]Delivery, error) {
+	return k.broadcastReport(envelope{t: websocket.MessageBinary, msg: msg})
+}
+
+func (k *Kuromi) broadcast(ctx context.Context, message envelope) error {
+	if k.hub.closed() {
+		return ErrClosed
+	}
+
+	if ok, err := k.admitBroadcast(ctx, len(message.msg)); !ok {
+		return err
+	}
+
+	return k.queueBroadcast(ctx, message)
+}
+
+// queueBroadcast hands message to the hub without applying the broadcast rate limit.
+func (k *Kuromi) queueBroadcast(ctx context.Context, message envelope) error {
+	k.hub.stats.broadcasts.Add(1)
+
+	select {
+	case k.hub.broadcast <- message:
+		return nil
+	case <-ctx.Done():
+		k.hub.stats.broadcasts.Add(-1)
+		return ctx.Err()
+	}
+}
+
+func (k *Kuromi) broadcastReport(message envelope) ([]Delivery, error) {
+	if k.hub.closed() {
+		return nil, ErrClosed
+	}
+
+	if ok, err := k.admitBroadcast(context.Background(), len(message.msg)); !ok {
+		return nil, err
+	}
+
+	message.report = make(chan []Delivery, 1)
+	k.hub.stats.broadcasts.Add(1)
+	k.hub.broadcast <- message
+
+	return <-message.report, nil
+}
+
 // Sessions returns all sessions. An error is returned if the kuromi session is closed.
 func (k *Kuromi) Sessions() ([]*Session, error) {
 	if k.hub.closed() {
@@ -246,6 +571,17 @@ func (k *Kuromi) Sessions() ([]*Session, error) {
 	return k.hub.all(), nil
 }
 
+// Session returns the connected session with the id.
+func (k *Kuromi) Session(id string) (*Session, bool) {
+	for _, s := range k.hub.all() {
+		if s.ID() == id {
+			return s, true
+		}
+	}
+
+	return nil, false
+}
+
 // Close closes the kuromi instance and all connected sessions.
 func (k *Kuromi) Close() error {
 	if k.hub.closed() {
@@ -254,6 +590,11 @@ func (k *Kuromi) Close() error {
 
 	k.hub.exit <- envelope{t: CloseMessage, msg: []byte{}, code: websocket.StatusNormalClosure}
 
+	k.stopWorkers()
+	k.stopWebhooks()
+	k.stopAudit()
+	k.leaveCluster()
+
 	return nil
 }
 
@@ -266,11 +607,41 @@ func (k *Kuromi) CloseWithMsg(code websocket.StatusCode, reason string) error {
 
 	k.hub.exit <- envelope{t: CloseMessage, msg: []byte(reason), code: code}
 
+	k.stopWorkers()
+	k.stopWebhooks()
+	k.stopAudit()
+	k.leaveCluster()
+
 	return nil
 }
 
 // TODO: CloseNow
 
+// QueueStats holds aggregate output queue statistics for all sessions.
+type QueueStats struct {
+	Sessions   int // Number of connected sessions.
+	Pending    int // Total number of queued messages that have not been sent yet.
+	MaxPending int // Largest number of pending messages of a single session.
+	Capacity   int // Size of each session's message buffer.
+}
+
+// QueueStats returns aggregate output queue statistics for all connected sessions.
+// It can be used to detect slow consumers before their message buffers overflow.
+func (k *Kuromi) QueueStats() QueueStats {
+	stats := QueueStats{Capacity: k.Config.MessageBufferSize}
+
+	k.hub.sessions.each(func(s *Session) {
+		n := s.Pending()
+		stats.Sessions++
+		stats.Pending += n
+		if n > stats.MaxPending {
+			stats.MaxPending = n
+		}
+	})
+
+	return stats
+}
+
 // Len return the number of connected sessions.
 func (k *Kuromi) Len() int {
 	return k.hub.len()
@@ -0,0 +1,181 @@
+package kuromitest
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// buffer is one direction of a pipe: an unbounded byte buffer with a read
+// deadline.
+type buffer struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	buf      bytes.Buffer
+	eof      bool // The writing end is closed.
+	closed   bool // The reading end is closed.
+	deadline time.Time
+	timer    *time.Timer
+}
+
+func newBuffer() *buffer {
+	b := &buffer{}
+	b.cond = sync.NewCond(&b.mu)
+
+	return b
+}
+
+func (b *buffer) read(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for {
+		switch {
+		case b.closed:
+			return 0, net.ErrClosed
+		case b.buf.Len() > 0:
+			return b.buf.Read(p)
+		case b.eof:
+			return 0, io.EOF
+		case !b.deadline.IsZero() && !time.Now().Before(b.deadline):
+			return 0, os.ErrDeadlineExceeded
+		}
+
+		b.cond.Wait()
+	}
+}
+
+func (b *buffer) write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.eof {
+		return 0, net.ErrClosed
+	}
+
+	if b.closed {
+		return 0, io.ErrClosedPipe
+	}
+
+	b.buf.Write(p)
+	b.cond.Broadcast()
+
+	return len(p), nil
+}
+
+func (b *buffer) setDeadline(t time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.deadline = t
+
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+
+	if !t.IsZero() {
+		b.timer = time.AfterFunc(time.Until(t), func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+
+			b.cond.Broadcast()
+		})
+	}
+
+	b.cond.Broadcast()
+}
+
+// closeWrite marks the end of the data written to b.
+func (b *buffer) closeWrite() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.eof = true
+	b.cond.Broadcast()
+}
+
+// closeRead discards the data buffered in b.
+func (b *buffer) closeRead() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	b.buf.Reset()
+	b.cond.Broadcast()
+
+	if b.timer != nil {
+		b.timer.Stop()
+	}
+}
+
+// pipeConn is an end of an in-memory connection with buffered writes.
+type pipeConn struct {
+	rx, tx        *buffer
+	local, remote net.Addr
+	writeDeadline time.Time
+	mu            sync.Mutex
+	once          sync.Once
+}
+
+// newPipe returns the ends of an in-memory connection between a client at
+// client and a server at server.
+func newPipe(client, server net.Addr) (*pipeConn, *pipeConn) {
+	up, down := newBuffer(), newBuffer()
+
+	return &pipeConn{rx: down, tx: up, local: client, remote: server},
+		&pipeConn{rx: up, tx: down, local: server, remote: client}
+}
+
+func (c *pipeConn) Read(p []byte) (int, error) {
+	return c.rx.read(p)
+}
+
+// Write never blocks, it fails if the write deadline has passed.
+func (c *pipeConn) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	deadline := c.writeDeadline
+	c.mu.Unlock()
+
+	if !deadline.IsZero() && !time.Now().Before(deadline) {
+		return 0, os.ErrDeadlineExceeded
+	}
+
+	return c.tx.write(p)
+}
+
+func (c *pipeConn) Close() error {
+	c.once.Do(func() {
+		c.rx.closeRead()
+		c.tx.closeWrite()
+	})
+
+	return nil
+}
+
+func (c *pipeConn) LocalAddr() net.Addr  { return c.local }
+func (c *pipeConn) RemoteAddr() net.Addr { return c.remote }
+
+func (c *pipeConn) SetDeadline(t time.Time) error {
+	c.SetReadDeadline(t)
+
+	return c.SetWriteDeadline(t)
+}
+
+func (c *pipeConn) SetReadDeadline(t time.Time) error {
+	c.rx.setDeadline(t)
+
+	return nil
+}
+
+func (c *pipeConn) SetWriteDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.writeDeadline = t
+
+	return nil
+}
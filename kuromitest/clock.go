@@ -0,0 +1,250 @@
+// Package kuromitest provides helpers for testing kuromi applications without
+// real sleeps or network connections.
+package kuromitest
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/fshiori/kuromi"
+)
+
+// Clock is a fake kuromi.Clock for kuromi.Config.Clock. Its time only moves
+// when Advance or Set is called, firing the timers and tickers that are due
+// in order:
+//
+//	clock := kuromitest.NewClock(time.Unix(0, 0))
+//	k := kuromi.New()
+//	k.Config.Clock = clock
+//	...
+//	clock.BlockUntil(1) // wait for the ping ticker of the session
+//	clock.Advance(k.Config.PingPeriod)
+//
+// Functions of timers created with AfterFunc are called synchronously by
+// Advance and Set, so their effects are visible once those return.
+type Clock struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	now     time.Time
+	timers  []*fakeTimer
+	counter uint64
+}
+
+// NewClock returns a fake clock set to now.
+func NewClock(now time.Time) *Clock {
+	c := &Clock{now: now}
+	c.cond = sync.NewCond(&c.mu)
+
+	return c
+}
+
+// Now implements kuromi.Clock.
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+// NewTimer implements kuromi.Clock.
+func (c *Clock) NewTimer(d time.Duration) kuromi.Timer {
+	return c.add(&fakeTimer{clock: c, ch: make(chan time.Time, 1)}, d)
+}
+
+// NewTicker implements kuromi.Clock.
+func (c *Clock) NewTicker(d time.Duration) kuromi.Ticker {
+	if d <= 0 {
+		panic("kuromitest: non-positive interval for NewTicker")
+	}
+
+	return fakeTicker{c.add(&fakeTimer{clock: c, ch: make(chan time.Time, 1), period: d}, d)}
+}
+
+// AfterFunc implements kuromi.Clock.
+func (c *Clock) AfterFunc(d time.Duration, f func()) kuromi.Timer {
+	return c.add(&fakeTimer{clock: c, fn: f}, d)
+}
+
+// Advance moves the clock forward by d, firing the timers due until then.
+func (c *Clock) Advance(d time.Duration) {
+	c.Set(c.Now().Add(d))
+}
+
+// Set moves the clock to t, firing the timers due until then. The clock never
+// moves backwards.
+func (c *Clock) Set(t time.Time) {
+	for {
+		c.mu.Lock()
+
+		next := c.next(t)
+		if next == nil {
+			if t.After(c.now) {
+				c.now = t
+			}
+			c.mu.Unlock()
+
+			return
+		}
+
+		if next.when.After(c.now) {
+			c.now = next.when
+		}
+
+		if next.period > 0 {
+			next.when = next.when.Add(next.period)
+		} else {
+			c.remove(next)
+		}
+
+		now := c.now
+		c.mu.Unlock()
+
+		next.fire(now)
+	}
+}
+
+// Timers returns the number of active timers and tickers.
+func (c *Clock) Timers() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.timers)
+}
+
+// BlockUntil blocks until at least n timers and tickers are active, e.g. to
+// wait for a session to start its ping ticker before advancing the clock.
+func (c *Clock) BlockUntil(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for len(c.timers) < n {
+		c.cond.Wait()
+	}
+}
+
+func (c *Clock) add(t *fakeTimer, d time.Duration) *fakeTimer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.schedule(t, d)
+
+	return t
+}
+
+// schedule makes t fire after d, c.mu must be held.
+func (c *Clock) schedule(t *fakeTimer, d time.Duration) {
+	c.counter++
+	t.when, t.order = c.now.Add(d), c.counter
+
+	if !t.active {
+		t.active = true
+		c.timers = append(c.timers, t)
+		c.cond.Broadcast()
+	}
+}
+
+// remove deactivates t, c.mu must be held. It reports whether t was active.
+func (c *Clock) remove(t *fakeTimer) bool {
+	if !t.active {
+		return false
+	}
+
+	t.active = false
+
+	for i, other := range c.timers {
+		if other == t {
+			c.timers = append(c.timers[:i], c.timers[i+1:]...)
+			break
+		}
+	}
+
+	return true
+}
+
+// next returns the first timer due at or before t, c.mu must be held.
+func (c *Clock) next(t time.Time) *fakeTimer {
+	sort.Slice(c.timers, func(i, j int) bool {
+		a, b := c.timers[i], c.timers[j]
+		if a.when.Equal(b.when) {
+			return a.order < b.order
+		}
+
+		return a.when.Before(b.when)
+	})
+
+	if len(c.timers) == 0 || c.timers[0].when.After(t) {
+		return nil
+	}
+
+	return c.timers[0]
+}
+
+// fakeTimer is a timer or ticker of a fake clock.
+type fakeTimer struct {
+	clock  *Clock
+	ch     chan time.Time
+	fn     func()
+	period time.Duration
+	when   time.Time
+	order  uint64
+	active bool
+}
+
+func (t *fakeTimer) fire(now time.Time) {
+	if t.fn != nil {
+		t.fn()
+		return
+	}
+
+	// Like time.Ticker, ticks are dropped for slow receivers.
+	select {
+	case t.ch <- now:
+	default:
+	}
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.ch
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+
+	return t.clock.remove(t)
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+
+	active := t.active
+
+	if t.period > 0 {
+		t.period = d
+	}
+
+	t.clock.schedule(t, d)
+
+	return active
+}
+
+// fakeTicker is the kuromi.Ticker of a periodic fakeTimer.
+type fakeTicker struct{ t *fakeTimer }
+
+func (t fakeTicker) C() <-chan time.Time {
+	return t.t.ch
+}
+
+func (t fakeTicker) Stop() {
+	t.t.Stop()
+}
+
+func (t fakeTicker) Reset(d time.Duration) {
+	if d <= 0 {
+		panic("kuromitest: non-positive interval for Ticker.Reset")
+	}
+
+	t.t.Reset(d)
+}
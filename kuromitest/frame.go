@@ -0,0 +1,307 @@
+package kuromitest
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+	"unicode/utf8"
+)
+
+// Opcode is the opcode of a websocket frame.
+type Opcode byte
+
+// Opcodes defined by RFC 6455.
+const (
+	OpContinuation Opcode = 0x0
+	OpText         Opcode = 0x1
+	OpBinary       Opcode = 0x2
+	OpClose        Opcode = 0x8
+	OpPing         Opcode = 0x9
+	OpPong         Opcode = 0xa
+)
+
+// Frame is a raw websocket frame. Frames are written as they are, without
+// validation, so tests can send frames a websocket library would refuse to.
+type Frame struct {
+	Fin      bool   // Final fragment of a message.
+	RSV      byte   // Reserved bits, 0 to 7, e.g. 4 sets RSV1 of compressed messages.
+	Opcode   Opcode // Opcode of the frame, unknown opcodes are written as they are.
+	Payload  []byte // Payload of the frame, masked when written by a client.
+	Length   uint64 // Payload length announced in the header when not 0, e.g. to announce an oversized message without sending it.
+	Unmasked bool   // Write the payload without masking it, which servers must reject.
+}
+
+// TextFrame returns a final text frame.
+func TextFrame(s string) Frame {
+	return Frame{Fin: true, Opcode: OpText, Payload: []byte(s)}
+}
+
+// BinaryFrame returns a final binary frame.
+func BinaryFrame(p []byte) Frame {
+	return Frame{Fin: true, Opcode: OpBinary, Payload: p}
+}
+
+// PingFrame returns a ping frame with payload p.
+func PingFrame(p []byte) Frame {
+	return Frame{Fin: true, Opcode: OpPing, Payload: p}
+}
+
+// CloseFrame returns a close frame with code and reason.
+func CloseFrame(code uint16, reason string) Frame {
+	p := binary.BigEndian.AppendUint16(nil, code)
+
+	return Frame{Fin: true, Opcode: OpClose, Payload: append(p, reason...)}
+}
+
+// Fragments splits a message into frames of at most size bytes, e.g. to
+// interleave control frames between them.
+func Fragments(op Opcode, msg []byte, size int) []Frame {
+	var frames []Frame
+
+	for first := true; first || len(msg) > 0; first = false {
+		n := min(size, len(msg))
+
+		f := Frame{Opcode: OpContinuation, Payload: msg[:n]}
+		if first {
+			f.Opcode = op
+		}
+
+		msg = msg[n:]
+		f.Fin = len(msg) == 0
+		frames = append(frames, f)
+	}
+
+	return frames
+}
+
+// FrameConn is a raw websocket client connection to a Server, writing and
+// reading single frames to script what a session receives: oversized
+// messages, control frames interleaved with fragments, invalid frames or
+// connections dropped without a close frame.
+type FrameConn struct {
+	conn net.Conn
+	br   *bufio.Reader
+}
+
+// DialFrames performs the opening handshake with path on the server and returns
+// the raw connection. header is added to the upgrade request and may be nil.
+func (s *Server) DialFrames(ctx context.Context, path string, header http.Header) (*FrameConn, *http.Response, error) {
+	conn, err := s.Listener.Dial(ctx, "", "")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+		defer conn.SetDeadline(time.Time{})
+	}
+
+	nonce := make([]byte, 16)
+	rand.Read(nonce)
+	key := base64.StdEncoding.EncodeToString(nonce)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://kuromitest"+path, nil)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+
+	for name, values := range header {
+		req.Header[name] = values
+	}
+
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", key)
+
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+
+	br := bufio.NewReader(conn)
+
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		conn.Close()
+		return nil, resp, fmt.Errorf("kuromitest: handshake failed with status %s", resp.Status)
+	}
+
+	if resp.Header.Get("Sec-WebSocket-Accept") != acceptKey(key) {
+		conn.Close()
+		return nil, resp, errors.New("kuromitest: invalid Sec-WebSocket-Accept")
+	}
+
+	return &FrameConn{conn: conn, br: br}, resp, nil
+}
+
+// acceptKey returns the Sec-WebSocket-Accept of key.
+func acceptKey(key string) string {
+	h := sha1.Sum([]byte(key + "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"))
+
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+// Conn returns the underlying connection, e.g. to set deadlines.
+func (c *FrameConn) Conn() net.Conn {
+	return c.conn
+}
+
+// WriteFrame writes f.
+func (c *FrameConn) WriteFrame(f Frame) error {
+	length := f.Length
+	if length == 0 {
+		length = uint64(len(f.Payload))
+	}
+
+	b := []byte{byte(f.Opcode)&0xf | (f.RSV&7)<<4}
+	if f.Fin {
+		b[0] |= 0x80
+	}
+
+	var mask byte
+	if !f.Unmasked {
+		mask = 0x80
+	}
+
+	switch {
+	case length < 126:
+		b = append(b, mask|byte(length))
+	case length <= 0xffff:
+		b = binary.BigEndian.AppendUint16(append(b, mask|126), uint16(length))
+	default:
+		b = binary.BigEndian.AppendUint64(append(b, mask|127), length)
+	}
+
+	payload := f.Payload
+
+	if !f.Unmasked {
+		key := make([]byte, 4)
+		rand.Read(key)
+		b = append(b, key...)
+
+		payload = make([]byte, len(f.Payload))
+		for i, v := range f.Payload {
+			payload[i] = v ^ key[i%4]
+		}
+	}
+
+	_, err := c.conn.Write(append(b, payload...))
+
+	return err
+}
+
+// Write writes frames in order.
+func (c *FrameConn) Write(frames ...Frame) error {
+	for _, f := range frames {
+		if err := c.WriteFrame(f); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// WriteRaw writes p to the connection as it is, e.g. a truncated frame header.
+func (c *FrameConn) WriteRaw(p []byte) error {
+	_, err := c.conn.Write(p)
+
+	return err
+}
+
+// ReadFrame reads the next frame sent by the server.
+func (c *FrameConn) ReadFrame() (Frame, error) {
+	var h [2]byte
+	if _, err := io.ReadFull(c.br, h[:]); err != nil {
+		return Frame{}, err
+	}
+
+	f := Frame{
+		Fin:    h[0]&0x80 != 0,
+		RSV:    h[0] >> 4 & 7,
+		Opcode: Opcode(h[0] & 0xf),
+		Length: uint64(h[1] & 0x7f),
+	}
+
+	switch f.Length {
+	case 126:
+		var b [2]byte
+		if _, err := io.ReadFull(c.br, b[:]); err != nil {
+			return Frame{}, err
+		}
+		f.Length = uint64(binary.BigEndian.Uint16(b[:]))
+	case 127:
+		var b [8]byte
+		if _, err := io.ReadFull(c.br, b[:]); err != nil {
+			return Frame{}, err
+		}
+		f.Length = binary.BigEndian.Uint64(b[:])
+	}
+
+	var key [4]byte
+	f.Unmasked = h[1]&0x80 == 0
+	if !f.Unmasked {
+		if _, err := io.ReadFull(c.br, key[:]); err != nil {
+			return Frame{}, err
+		}
+	}
+
+	f.Payload = make([]byte, f.Length)
+	if _, err := io.ReadFull(c.br, f.Payload); err != nil {
+		return Frame{}, err
+	}
+
+	if !f.Unmasked {
+		for i := range f.Payload {
+			f.Payload[i] ^= key[i%4]
+		}
+	}
+
+	return f, nil
+}
+
+// ReadClose reads frames until the server sends a close frame and returns its
+// code and reason. Other frames are discarded, pings are not answered.
+func (c *FrameConn) ReadClose() (uint16, string, error) {
+	for {
+		f, err := c.ReadFrame()
+		if err != nil {
+			return 0, "", err
+		}
+
+		if f.Opcode != OpClose {
+			continue
+		}
+
+		if len(f.Payload) < 2 {
+			return 1005, "", nil
+		}
+
+		reason := f.Payload[2:]
+		if !utf8.Valid(reason) {
+			return 0, "", errors.New("kuromitest: close reason is not valid UTF-8")
+		}
+
+		return binary.BigEndian.Uint16(f.Payload), string(reason), nil
+	}
+}
+
+// Abort drops the connection without a close frame.
+func (c *FrameConn) Abort() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,134 @@
+package kuromitest
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+
+	"github.com/coder/websocket"
+	"github.com/fshiori/kuromi"
+)
+
+// ErrListenerClosed is returned by the Accept and Dial methods of a closed Listener.
+var ErrListenerClosed = errors.New("kuromitest: listener closed")
+
+// Listener is an in-memory net.Listener. Its connections are pipes created by
+// Dial, so no TCP ports are used. Unlike net.Pipe writes are buffered like
+// those of a TCP connection, so a peer that is not reading, e.g. a client
+// between two reads or a server answering a ping, does not block the other.
+// Connections report loopback addresses and every client gets its own port,
+// so IP filters and bans work as usual.
+type Listener struct {
+	conns chan net.Conn
+	done  chan struct{}
+	once  sync.Once
+	ports atomic.Uint32
+}
+
+// NewListener returns an in-memory listener.
+func NewListener() *Listener {
+	return &Listener{
+		conns: make(chan net.Conn),
+		done:  make(chan struct{}),
+	}
+}
+
+// Accept implements net.Listener.
+func (l *Listener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.done:
+		return nil, ErrListenerClosed
+	}
+}
+
+// Close implements net.Listener. Connections already accepted stay open.
+func (l *Listener) Close() error {
+	l.once.Do(func() { close(l.done) })
+
+	return nil
+}
+
+// Addr implements net.Listener.
+func (l *Listener) Addr() net.Addr {
+	return listenerAddr
+}
+
+// Dial connects to the listener, the network and address are ignored. Its
+// signature matches http.Transport.DialContext.
+func (l *Listener) Dial(ctx context.Context, _, _ string) (net.Conn, error) {
+	addr := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1024 + int(l.ports.Add(1)%64511)}
+	client, server := newPipe(addr, listenerAddr)
+
+	select {
+	case l.conns <- server:
+		return client, nil
+	case <-l.done:
+		return nil, ErrListenerClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+var listenerAddr = &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 80}
+
+// Server serves a kuromi instance over in-memory connections, for fast handler
+// tests and benchmarks without TCP or httptest:
+//
+//	srv := kuromitest.NewServer(k)
+//	defer srv.Close()
+//
+//	c, _, err := srv.Dial(ctx, "/ws", nil)
+type Server struct {
+	Listener *Listener
+	k        *kuromi.Kuromi
+}
+
+// NewServer starts serving k on all paths with Kuromi.Serve.
+func NewServer(k *kuromi.Kuromi) *Server {
+	l := NewListener()
+
+	go k.Serve(l)
+
+	return &Server{Listener: l, k: k}
+}
+
+// URL returns the websocket URL of path on the server.
+func (s *Server) URL(path string) string {
+	return "ws://kuromitest" + path
+}
+
+// Client returns an HTTP client connecting to the server, e.g. for the
+// HTTPClient of websocket.DialOptions.
+func (s *Server) Client() *http.Client {
+	return &http.Client{Transport: &http.Transport{DialContext: s.Listener.Dial}}
+}
+
+// Dial opens a websocket connection to path on the server with opts, which
+// may be nil. The HTTP client of opts is replaced with the one of Client.
+func (s *Server) Dial(ctx context.Context, path string, opts *websocket.DialOptions) (*websocket.Conn, *http.Response, error) {
+	o := websocket.DialOptions{}
+	if opts != nil {
+		o = *opts
+	}
+
+	o.HTTPClient = s.Client()
+
+	return websocket.Dial(ctx, s.URL(path), &o)
+}
+
+// Close stops accepting connections and closes the kuromi instance, closing
+// its sessions.
+func (s *Server) Close() error {
+	s.Listener.Close()
+
+	if err := s.k.Close(); err != nil && !errors.Is(err, kuromi.ErrClosed) {
+		return err
+	}
+
+	return nil
+}
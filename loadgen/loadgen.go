@@ -0,0 +1,280 @@
+// Package loadgen generates websocket load against a kuromi server, for
+// benchmarks and performance regression tests of application handlers.
+//
+// Every message sent starts with a header carrying its send time, so the
+// delivery latency of each message written back by the server, e.g. by an
+// echo or broadcast handler, is measured on the receiving connection.
+package loadgen
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/coder/websocket"
+)
+
+// HeaderSize is the size in bytes of the header every message starts with.
+const HeaderSize = 4 + 8
+
+const magic = 0x6b75726f // "kuro"
+
+var ErrNoURL = errors.New("loadgen: no url")
+
+// Config describes a load test.
+type Config struct {
+	URL         string                 // Websocket url of the server.
+	Connections int                    // Number of concurrent connections.
+	Senders     int                    // Number of connections sending messages, 0 means all of them.
+	Rate        float64                // Messages per second per sending connection, 0 only connects.
+	Size        int                    // Message size in bytes, at least HeaderSize.
+	Binary      bool                   // Send binary instead of text messages.
+	Duration    time.Duration          // Duration of the test.
+	Ramp        time.Duration          // Time over which the connections are opened.
+	Lifetime    time.Duration          // Time after which connections are closed and reopened, 0 keeps them open.
+	DialOptions *websocket.DialOptions // Optional options used when dialing.
+}
+
+// Result is the outcome of a load test.
+type Result struct {
+	Elapsed     time.Duration
+	Connected   int64           // Successful connection attempts.
+	DialErrors  int64           // Failed connection attempts.
+	Sent        int64           // Messages sent.
+	Received    int64           // Messages received.
+	WriteErrors int64           // Failed writes.
+	ReadErrors  int64           // Connections failing while reading.
+	Latencies   []time.Duration // Delivery latencies of received messages, sorted.
+}
+
+// Percentile returns the p-th percentile, 0 to 100, of the latencies.
+func (r *Result) Percentile(p float64) time.Duration {
+	if len(r.Latencies) == 0 {
+		return 0
+	}
+
+	i := int(math.Ceil(float64(len(r.Latencies))*p/100)) - 1
+	if i < 0 {
+		i = 0
+	}
+
+	return r.Latencies[i]
+}
+
+// Errors returns the total number of errors.
+func (r *Result) Errors() int64 {
+	return r.DialErrors + r.WriteErrors + r.ReadErrors
+}
+
+// ErrorRate returns the fraction of failed connection attempts and writes.
+func (r *Result) ErrorRate() float64 {
+	total := r.Connected + r.DialErrors + r.Sent + r.WriteErrors
+	if total == 0 {
+		return 0
+	}
+
+	return float64(r.Errors()) / float64(total)
+}
+
+func (r *Result) String() string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "duration:     %v\n", r.Elapsed.Round(time.Millisecond))
+	fmt.Fprintf(&b, "connections:  %d ok, %d failed\n", r.Connected, r.DialErrors)
+	fmt.Fprintf(&b, "messages:     %d sent, %d received (%.1f/s sent)\n", r.Sent, r.Received, float64(r.Sent)/r.Elapsed.Seconds())
+	fmt.Fprintf(&b, "errors:       %d write, %d read (%.2f%% error rate)\n", r.WriteErrors, r.ReadErrors, 100*r.ErrorRate())
+
+	if len(r.Latencies) == 0 {
+		b.WriteString("latency:      no messages received\n")
+	} else {
+		fmt.Fprintf(&b, "latency:      p50 %v, p90 %v, p99 %v, max %v\n",
+			r.Percentile(50).Round(time.Microsecond), r.Percentile(90).Round(time.Microsecond),
+			r.Percentile(99).Round(time.Microsecond), r.Percentile(100).Round(time.Microsecond))
+	}
+
+	return b.String()
+}
+
+type runner struct {
+	cfg Config
+
+	mu        sync.Mutex
+	latencies []time.Duration
+
+	connected   atomic.Int64
+	dialErrors  atomic.Int64
+	sent        atomic.Int64
+	received    atomic.Int64
+	writeErrors atomic.Int64
+	readErrors  atomic.Int64
+}
+
+// Run runs the load test described by cfg until cfg.Duration elapsed or ctx is done.
+func Run(ctx context.Context, cfg Config) (*Result, error) {
+	if cfg.URL == "" {
+		return nil, ErrNoURL
+	}
+
+	if cfg.Size < HeaderSize {
+		cfg.Size = HeaderSize
+	}
+
+	if cfg.Senders <= 0 || cfg.Senders > cfg.Connections {
+		cfg.Senders = cfg.Connections
+	}
+
+	if cfg.Duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.Duration)
+		defer cancel()
+	}
+
+	r := &runner{cfg: cfg}
+	start := time.Now()
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < cfg.Connections; i++ {
+		if cfg.Ramp > 0 {
+			select {
+			case <-time.After(cfg.Ramp / time.Duration(cfg.Connections)):
+			case <-ctx.Done():
+			}
+		}
+
+		wg.Add(1)
+
+		go func(sender bool) {
+			defer wg.Done()
+			r.conn(ctx, sender)
+		}(i < cfg.Senders)
+	}
+
+	wg.Wait()
+
+	sort.Slice(r.latencies, func(i, j int) bool { return r.latencies[i] < r.latencies[j] })
+
+	return &Result{
+		Elapsed:     time.Since(start),
+		Connected:   r.connected.Load(),
+		DialErrors:  r.dialErrors.Load(),
+		Sent:        r.sent.Load(),
+		Received:    r.received.Load(),
+		WriteErrors: r.writeErrors.Load(),
+		ReadErrors:  r.readErrors.Load(),
+		Latencies:   r.latencies,
+	}, nil
+}
+
+// conn keeps a connection open, reopening it after cfg.Lifetime, until ctx is done.
+func (r *runner) conn(ctx context.Context, sender bool) {
+	for ctx.Err() == nil {
+		lifeCtx, cancel := ctx, context.CancelFunc(func() {})
+		if r.cfg.Lifetime > 0 {
+			lifeCtx, cancel = context.WithTimeout(ctx, r.cfg.Lifetime)
+		}
+
+		r.session(lifeCtx, sender)
+		cancel()
+
+		if r.cfg.Lifetime <= 0 {
+			return
+		}
+	}
+}
+
+func (r *runner) session(ctx context.Context, sender bool) {
+	c, _, err := websocket.Dial(ctx, r.cfg.URL, r.cfg.DialOptions)
+	if err != nil {
+		if ctx.Err() == nil {
+			r.dialErrors.Add(1)
+			// Back off a little so a failing server is not hammered in a loop.
+			select {
+			case <-time.After(100 * time.Millisecond):
+			case <-ctx.Done():
+			}
+		}
+		return
+	}
+	defer c.CloseNow()
+
+	r.connected.Add(1)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		r.read(ctx, c)
+	}()
+
+	if sender && r.cfg.Rate > 0 {
+		r.write(ctx, c)
+	} else {
+		<-ctx.Done()
+	}
+
+	c.Close(websocket.StatusNormalClosure, "")
+	<-done
+}
+
+func (r *runner) read(ctx context.Context, c *websocket.Conn) {
+	for {
+		_, msg, err := c.Read(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				r.readErrors.Add(1)
+			}
+			return
+		}
+
+		r.received.Add(1)
+
+		if len(msg) >= HeaderSize && binary.BigEndian.Uint32(msg) == magic {
+			sent := int64(binary.BigEndian.Uint64(msg[4:]))
+
+			r.mu.Lock()
+			r.latencies = append(r.latencies, time.Duration(time.Now().UnixNano()-sent))
+			r.mu.Unlock()
+		}
+	}
+}
+
+func (r *runner) write(ctx context.Context, c *websocket.Conn) {
+	t := websocket.MessageText
+	if r.cfg.Binary {
+		t = websocket.MessageBinary
+	}
+
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / r.cfg.Rate))
+	defer ticker.Stop()
+
+	msg := make([]byte, r.cfg.Size)
+	for i := HeaderSize; i < len(msg); i++ {
+		msg[i] = 'x'
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			binary.BigEndian.PutUint32(msg, magic)
+			binary.BigEndian.PutUint64(msg[4:], uint64(time.Now().UnixNano()))
+
+			if err := c.Write(ctx, t, msg); err != nil {
+				if ctx.Err() == nil {
+					r.writeErrors.Add(1)
+				}
+				continue
+			}
+
+			r.sent.Add(1)
+		}
+	}
+}
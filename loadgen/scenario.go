@@ -0,0 +1,41 @@
+package loadgen
+
+import "time"
+
+// ConnectStorm opens n connections at once without sending messages, to
+// measure how the server copes with a burst of upgrades, e.g. after a deploy.
+func ConnectStorm(url string, n int, d time.Duration) Config {
+	return Config{
+		URL:         url,
+		Connections: n,
+		Duration:    d,
+	}
+}
+
+// BroadcastFanOut opens n connections of which a single one sends rate
+// messages per second, measuring the delivery latency of messages the server
+// broadcasts to all of them.
+func BroadcastFanOut(url string, n int, rate float64, d time.Duration) Config {
+	return Config{
+		URL:         url,
+		Connections: n,
+		Senders:     1,
+		Rate:        rate,
+		Size:        64,
+		Duration:    d,
+	}
+}
+
+// Churn keeps n connections sending rate messages per second that are closed
+// and reopened every lifetime, to exercise connect and disconnect handlers.
+func Churn(url string, n int, rate float64, lifetime, d time.Duration) Config {
+	return Config{
+		URL:         url,
+		Connections: n,
+		Rate:        rate,
+		Size:        64,
+		Duration:    d,
+		Ramp:        lifetime,
+		Lifetime:    lifetime,
+	}
+}
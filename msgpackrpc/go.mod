@@ -0,0 +1,15 @@
+module github.com/fshiori/kuromi/msgpackrpc
+
+go 1.22.6
+
+require (
+	github.com/fshiori/kuromi v0.0.0
+	github.com/vmihailenco/msgpack/v5 v5.4.1
+)
+
+require (
+	github.com/coder/websocket v1.8.12 // indirect
+	github.com/vmihailenco/tagparser/v2 v2.0.0 // indirect
+)
+
+replace github.com/fshiori/kuromi => ../
@@ -0,0 +1,307 @@
+// Package msgpackrpc implements MessagePack-RPC over kuromi sessions: clients
+// call and notify registered methods, and the server calls and notifies
+// methods of clients, with every message sent as a binary message.
+//
+//	srv := msgpackrpc.New(k)
+//	srv.Register("add", func(s *kuromi.Session, args msgpackrpc.Args) (any, error) {
+//		var a, b int
+//		if err := args.Decode(&a, &b); err != nil {
+//			return nil, err
+//		}
+//		return a + b, nil
+//	})
+//
+// Methods run on the goroutine handling the messages of the session, so a
+// method calling back into the same session with Call must either run with
+// kuromi.Config.ConcurrentMessageHandling or make the call on another goroutine.
+package msgpackrpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/fshiori/kuromi"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Message types of the MessagePack-RPC specification.
+const (
+	TypeRequest      = 0
+	TypeResponse     = 1
+	TypeNotification = 2
+)
+
+var (
+	ErrMalformedMessage = errors.New("malformed msgpack-rpc message")
+	ErrMethodNotFound   = errors.New("method not found")
+	ErrArgumentCount    = errors.New("wrong number of arguments")
+)
+
+// Handlers is implemented by *kuromi.Kuromi, *kuromi.Route and *kuromi.Namespace.
+type Handlers interface {
+	HandleDisconnect(func(*kuromi.Session))
+	HandleMessageBinary(func(*kuromi.Session, []byte))
+}
+
+// Method handles a call or notification of a session. The result of
+// notifications is discarded.
+type Method func(s *kuromi.Session, args Args) (any, error)
+
+// Args are the arguments of a call.
+type Args []msgpack.RawMessage
+
+// Decode decodes the arguments into v in order. It returns ErrArgumentCount if
+// the number of arguments differs from len(v).
+func (a Args) Decode(v ...any) error {
+	if len(a) != len(v) {
+		return fmt.Errorf("%w: got %d, want %d", ErrArgumentCount, len(a), len(v))
+	}
+
+	for i, arg := range a {
+		if err := msgpack.Unmarshal(arg, v[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Result is the result of a call to a client.
+type Result msgpack.RawMessage
+
+// Decode decodes the result into v.
+func (r Result) Decode(v any) error {
+	return msgpack.Unmarshal(r, v)
+}
+
+// RemoteError is the error a client responded to a call with.
+type RemoteError struct {
+	Value any
+}
+
+func (e *RemoteError) Error() string {
+	return fmt.Sprintf("msgpack-rpc: remote error: %v", e.Value)
+}
+
+// response is the response to a call to a client.
+type response struct {
+	err    msgpack.RawMessage
+	result msgpack.RawMessage
+}
+
+// Server dispatches the MessagePack-RPC messages of the sessions of the handlers it was created with.
+type Server struct {
+	mu      sync.RWMutex
+	methods map[string]Method
+	pending map[*kuromi.Session]map[uint32]chan response
+	msgid   atomic.Uint32
+
+	invalid func(s *kuromi.Session, msg []byte, err error)
+}
+
+// New creates a Server and installs its disconnect and binary message handlers on h.
+func New(h Handlers) *Server {
+	srv := &Server{
+		methods: make(map[string]Method),
+		pending: make(map[*kuromi.Session]map[uint32]chan response),
+	}
+
+	h.HandleDisconnect(srv.disconnect)
+	h.HandleMessageBinary(srv.message)
+
+	return srv
+}
+
+// Register registers fn as the method name.
+func (srv *Server) Register(name string, fn Method) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	srv.methods[name] = fn
+}
+
+// HandleInvalid fires fn with the message and the reason when a session sends a
+// malformed message, a notification of an unknown method or a response to an
+// unknown call.
+func (srv *Server) HandleInvalid(fn func(s *kuromi.Session, msg []byte, err error)) {
+	srv.invalid = fn
+}
+
+// Call calls the method of the client of s with args and waits for its response
+// until ctx is done. A *RemoteError is returned if the client responded with an error.
+func (srv *Server) Call(ctx context.Context, s *kuromi.Session, method string, args ...any) (Result, error) {
+	id := srv.msgid.Add(1)
+	reply := make(chan response, 1)
+
+	srv.mu.Lock()
+	if srv.pending[s] == nil {
+		srv.pending[s] = make(map[uint32]chan response)
+	}
+	srv.pending[s][id] = reply
+	srv.mu.Unlock()
+
+	defer srv.forget(s, id)
+
+	if args == nil {
+		args = []any{}
+	}
+
+	if err := srv.write(s, []any{TypeRequest, id, method, args}); err != nil {
+		return nil, err
+	}
+
+	select {
+	case res, ok := <-reply:
+		if !ok {
+			return nil, kuromi.ErrSessionClosed
+		}
+
+		if !isNil(res.err) {
+			var value any
+			if err := msgpack.Unmarshal(res.err, &value); err != nil {
+				return nil, err
+			}
+
+			return nil, &RemoteError{Value: value}
+		}
+
+		return Result(res.result), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Notify sends a notification of method with args to the client of s.
+func (srv *Server) Notify(s *kuromi.Session, method string, args ...any) error {
+	if args == nil {
+		args = []any{}
+	}
+
+	return srv.write(s, []any{TypeNotification, method, args})
+}
+
+func (srv *Server) write(s *kuromi.Session, v any) error {
+	msg, err := msgpack.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	return s.WriteBinary(msg)
+}
+
+func (srv *Server) forget(s *kuromi.Session, id uint32) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	delete(srv.pending[s], id)
+	if len(srv.pending[s]) == 0 {
+		delete(srv.pending, s)
+	}
+}
+
+func (srv *Server) disconnect(s *kuromi.Session) {
+	srv.mu.Lock()
+	pending := srv.pending[s]
+	delete(srv.pending, s)
+	srv.mu.Unlock()
+
+	for _, reply := range pending {
+		close(reply)
+	}
+}
+
+func (srv *Server) message(s *kuromi.Session, msg []byte) {
+	var parts []msgpack.RawMessage
+	if err := msgpack.Unmarshal(msg, &parts); err != nil || len(parts) < 3 {
+		srv.fail(s, msg, ErrMalformedMessage)
+		return
+	}
+
+	var typ int
+	if err := msgpack.Unmarshal(parts[0], &typ); err != nil {
+		srv.fail(s, msg, ErrMalformedMessage)
+		return
+	}
+
+	switch {
+	case typ == TypeRequest && len(parts) == 4:
+		var id uint32
+		var method string
+		var args Args
+
+		if msgpack.Unmarshal(parts[1], &id) != nil || msgpack.Unmarshal(parts[2], &method) != nil || msgpack.Unmarshal(parts[3], &args) != nil {
+			srv.fail(s, msg, ErrMalformedMessage)
+			return
+		}
+
+		result, err := srv.call(s, method, args)
+
+		var errValue any
+		if err != nil {
+			errValue, result = err.Error(), nil
+		}
+
+		if werr := srv.write(s, []any{TypeResponse, id, errValue, result}); werr != nil {
+			srv.fail(s, msg, werr)
+		}
+	case typ == TypeResponse && len(parts) == 4:
+		var id uint32
+		if msgpack.Unmarshal(parts[1], &id) != nil {
+			srv.fail(s, msg, ErrMalformedMessage)
+			return
+		}
+
+		// Taking the call out of pending makes this the only sender on reply.
+		srv.mu.Lock()
+		reply, ok := srv.pending[s][id]
+		delete(srv.pending[s], id)
+		srv.mu.Unlock()
+
+		if !ok {
+			srv.fail(s, msg, fmt.Errorf("%w: unknown msgid %d", ErrMalformedMessage, id))
+			return
+		}
+
+		reply <- response{err: parts[2], result: parts[3]}
+	case typ == TypeNotification && len(parts) == 3:
+		var method string
+		var args Args
+
+		if msgpack.Unmarshal(parts[1], &method) != nil || msgpack.Unmarshal(parts[2], &args) != nil {
+			srv.fail(s, msg, ErrMalformedMessage)
+			return
+		}
+
+		if _, err := srv.call(s, method, args); errors.Is(err, ErrMethodNotFound) {
+			srv.fail(s, msg, err)
+		}
+	default:
+		srv.fail(s, msg, ErrMalformedMessage)
+	}
+}
+
+func (srv *Server) call(s *kuromi.Session, method string, args Args) (any, error) {
+	srv.mu.RLock()
+	fn, ok := srv.methods[method]
+	srv.mu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrMethodNotFound, method)
+	}
+
+	return fn(s, args)
+}
+
+func (srv *Server) fail(s *kuromi.Session, msg []byte, err error) {
+	if srv.invalid != nil {
+		srv.invalid(s, msg, err)
+	}
+}
+
+// isNil reports whether raw is the msgpack encoding of nil.
+func isNil(raw msgpack.RawMessage) bool {
+	return len(raw) == 0 || (len(raw) == 1 && raw[0] == 0xc0)
+}
@@ -0,0 +1,323 @@
+// Package mux multiplexes independent logical streams over the websocket of a
+// kuromi session, so e.g. a control stream and a bulk data stream share a
+// connection without a slow consumer of one stream blocking the other.
+//
+// Every frame is a binary message: a frame type byte, the stream ID as an
+// unsigned varint, and a payload.
+//
+//	OPEN   (1)  payload is the label of the stream
+//	DATA   (2)  payload is a message of the stream
+//	CLOSE  (3)  no payload, the sender will not send on the stream anymore
+//	WINDOW (4)  payload is an unsigned varint of bytes added to the send window
+//
+// Clients open streams with odd IDs and the server with even IDs. Each side
+// may send up to Window bytes of DATA on a stream before the other side
+// grants more with WINDOW frames as it reads them. A session may have
+// DefaultMaxStreams streams open at once, see Mux.SetMaxStreams.
+//
+//	m := mux.New(k)
+//	m.HandleStream(func(st *mux.Stream) {
+//		for {
+//			msg, err := st.Read(ctx)
+//			...
+//		}
+//	})
+package mux
+
+import (
+	"encoding/binary"
+	"errors"
+	"sync"
+
+	"github.com/fshiori/kuromi"
+)
+
+// Frame types.
+const (
+	FrameOpen   byte = 1
+	FrameData   byte = 2
+	FrameClose  byte = 3
+	FrameWindow byte = 4
+)
+
+// Window is the initial send window of a stream in bytes.
+const Window = 256 << 10
+
+// DefaultMaxStreams is the default number of streams a session may have open at once.
+const DefaultMaxStreams = 256
+
+var (
+	ErrMalformedFrame = errors.New("malformed mux frame")
+	ErrStreamClosed   = errors.New("mux stream is closed")
+	ErrWindowExceeded = errors.New("mux stream window exceeded")
+	ErrMessageTooLong = errors.New("mux message exceeds the stream window")
+	ErrTooManyStreams = errors.New("mux stream limit reached")
+)
+
+// Handlers is implemented by *kuromi.Kuromi, *kuromi.Route and *kuromi.Namespace.
+type Handlers interface {
+	HandleConnect(func(*kuromi.Session))
+	HandleDisconnect(func(*kuromi.Session))
+	HandleMessageBinary(func(*kuromi.Session, []byte))
+}
+
+// Mux routes the frames of the sessions of the handlers it was created with to their streams.
+type Mux struct {
+	mu    sync.RWMutex
+	conns map[*kuromi.Session]*Conn
+
+	stream     func(*Stream)
+	invalid    func(s *kuromi.Session, msg []byte, err error)
+	maxStreams int
+}
+
+// New creates a Mux and installs its connect, disconnect and binary message
+// handlers on h. They replace any handlers set on h before, and must not be
+// replaced afterwards; use Mux.Conn to reach the streams of a session instead.
+func New(h Handlers) *Mux {
+	m := &Mux{
+		conns:      make(map[*kuromi.Session]*Conn),
+		maxStreams: DefaultMaxStreams,
+	}
+
+	h.HandleConnect(m.connect)
+	h.HandleDisconnect(m.disconnect)
+	h.HandleMessageBinary(m.message)
+
+	return m
+}
+
+// HandleStream fires fn on a new goroutine when a client opens a stream.
+func (m *Mux) HandleStream(fn func(*Stream)) {
+	m.stream = fn
+}
+
+// HandleInvalid fires fn with the message and the reason when a session sends a
+// malformed frame or a frame exceeding the window of its stream.
+func (m *Mux) HandleInvalid(fn func(s *kuromi.Session, msg []byte, err error)) {
+	m.invalid = fn
+}
+
+// SetMaxStreams sets the number of streams a session may have open at once, 0
+// removes the limit. Streams opened by a client beyond it are closed right away
+// and reported to HandleInvalid with ErrTooManyStreams, Conn.Open returns it.
+func (m *Mux) SetMaxStreams(n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.maxStreams = n
+}
+
+// Conn returns the streams of s, or nil if s is not connected.
+func (m *Mux) Conn(s *kuromi.Session) *Conn {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.conns[s]
+}
+
+func (m *Mux) connect(s *kuromi.Session) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.conns[s] = &Conn{
+		session:    s,
+		streams:    make(map[uint64]*Stream),
+		nextID:     2,
+		maxStreams: m.maxStreams,
+	}
+}
+
+func (m *Mux) disconnect(s *kuromi.Session) {
+	m.mu.Lock()
+	c, ok := m.conns[s]
+	delete(m.conns, s)
+	m.mu.Unlock()
+
+	if ok {
+		c.closeAll()
+	}
+}
+
+func (m *Mux) message(s *kuromi.Session, msg []byte) {
+	c := m.Conn(s)
+	if c == nil {
+		return
+	}
+
+	if len(msg) < 2 {
+		m.fail(s, msg, ErrMalformedFrame)
+		return
+	}
+
+	id, n := binary.Uvarint(msg[1:])
+	if n <= 0 {
+		m.fail(s, msg, ErrMalformedFrame)
+		return
+	}
+
+	payload := msg[1+n:]
+
+	switch msg[0] {
+	case FrameOpen:
+		if id%2 == 0 {
+			m.fail(s, msg, ErrMalformedFrame)
+			return
+		}
+
+		st, err := c.open(id, string(payload))
+		if err != nil {
+			c.write(FrameClose, id, nil)
+			m.fail(s, msg, err)
+			return
+		}
+
+		if st != nil && m.stream != nil {
+			go m.stream(st)
+		}
+	case FrameData:
+		if st := c.get(id); st != nil {
+			if err := st.receive(payload); err != nil {
+				m.fail(s, msg, err)
+			}
+		}
+	case FrameClose:
+		if st := c.get(id); st != nil {
+			st.remoteClose()
+		}
+	case FrameWindow:
+		credit, n := binary.Uvarint(payload)
+		if n <= 0 {
+			m.fail(s, msg, ErrMalformedFrame)
+			return
+		}
+
+		if st := c.get(id); st != nil {
+			st.grant(credit)
+		}
+	default:
+		m.fail(s, msg, ErrMalformedFrame)
+	}
+}
+
+func (m *Mux) fail(s *kuromi.Session, msg []byte, err error) {
+	if m.invalid != nil {
+		m.invalid(s, msg, err)
+	}
+}
+
+// Conn is the set of streams of a session.
+type Conn struct {
+	session *kuromi.Session
+
+	mu         sync.Mutex
+	streams    map[uint64]*Stream
+	nextID     uint64
+	closed     bool
+	maxStreams int
+}
+
+// Session returns the session carrying the streams.
+func (c *Conn) Session() *kuromi.Session {
+	return c.session
+}
+
+// Open opens a stream with label to the client.
+func (c *Conn) Open(label string) (*Stream, error) {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil, kuromi.ErrSessionClosed
+	}
+
+	if c.full() {
+		c.mu.Unlock()
+		return nil, ErrTooManyStreams
+	}
+
+	id := c.nextID
+	c.nextID += 2
+
+	st := newStream(c, id, label)
+	c.streams[id] = st
+	c.mu.Unlock()
+
+	if err := c.write(FrameOpen, id, []byte(label)); err != nil {
+		c.remove(id)
+		return nil, err
+	}
+
+	return st, nil
+}
+
+// Streams returns the open streams of the session.
+func (c *Conn) Streams() []*Stream {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	streams := make([]*Stream, 0, len(c.streams))
+	for _, st := range c.streams {
+		streams = append(streams, st)
+	}
+
+	return streams
+}
+
+// open registers a stream opened by the client, returning nil for reused IDs.
+func (c *Conn) open(id uint64, label string) (*Stream, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.streams[id]; ok || c.closed {
+		return nil, nil
+	}
+
+	if c.full() {
+		return nil, ErrTooManyStreams
+	}
+
+	st := newStream(c, id, label)
+	c.streams[id] = st
+
+	return st, nil
+}
+
+// full reports whether the session has the maximum number of streams open, c.mu must be held.
+func (c *Conn) full() bool {
+	return c.maxStreams > 0 && len(c.streams) >= c.maxStreams
+}
+
+func (c *Conn) get(id uint64) *Stream {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.streams[id]
+}
+
+func (c *Conn) remove(id uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.streams, id)
+}
+
+func (c *Conn) closeAll() {
+	c.mu.Lock()
+	c.closed = true
+	streams := c.streams
+	c.streams = make(map[uint64]*Stream)
+	c.mu.Unlock()
+
+	for _, st := range streams {
+		st.terminate()
+	}
+}
+
+func (c *Conn) write(typ byte, id uint64, payload []byte) error {
+	frame := make([]byte, 0, 1+binary.MaxVarintLen64+len(payload))
+	frame = append(frame, typ)
+	frame = binary.AppendUvarint(frame, id)
+	frame = append(frame, payload...)
+
+	return c.session.WriteBinary(frame)
+}
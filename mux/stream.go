@@ -0,0 +1,214 @@
+package mux
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"sync"
+
+	"github.com/fshiori/kuromi"
+)
+
+// Stream is a logical stream of messages over a session.
+type Stream struct {
+	conn  *Conn
+	id    uint64
+	label string
+
+	mu       sync.Mutex
+	changed  chan struct{} // Closed when the stream changes.
+	queue    [][]byte
+	recv     uint64 // Bytes the client may still send before being granted more.
+	consumed uint64 // Bytes read since the last grant.
+	send     uint64 // Bytes that may still be sent.
+	closed   bool   // Closed locally.
+	eof      bool   // Closed by the client.
+	gone     bool   // The session disconnected.
+}
+
+func newStream(c *Conn, id uint64, label string) *Stream {
+	return &Stream{
+		conn:    c,
+		id:      id,
+		label:   label,
+		changed: make(chan struct{}),
+		recv:    Window,
+		send:    Window,
+	}
+}
+
+// ID returns the ID of the stream.
+func (st *Stream) ID() uint64 {
+	return st.id
+}
+
+// Label returns the label the stream was opened with, e.g. "control".
+func (st *Stream) Label() string {
+	return st.label
+}
+
+// Session returns the session carrying the stream.
+func (st *Stream) Session() *kuromi.Session {
+	return st.conn.session
+}
+
+// notify wakes up the goroutines waiting for the stream to change. It must be
+// called with the stream locked.
+func (st *Stream) notify() {
+	close(st.changed)
+	st.changed = make(chan struct{})
+}
+
+// Read returns the next message of the stream, waiting for it until ctx is done.
+// It returns io.EOF once the client closed the stream and its messages were read.
+func (st *Stream) Read(ctx context.Context) ([]byte, error) {
+	for {
+		st.mu.Lock()
+
+		if len(st.queue) > 0 {
+			msg := st.queue[0]
+			st.queue[0] = nil
+			st.queue = st.queue[1:]
+
+			var grant uint64
+
+			st.consumed += uint64(len(msg))
+			if st.consumed >= Window/2 && !st.eof && !st.gone {
+				grant, st.consumed = st.consumed, 0
+				st.recv += grant
+			}
+			st.mu.Unlock()
+
+			if grant > 0 {
+				st.conn.write(FrameWindow, st.id, binary.AppendUvarint(nil, grant))
+			}
+
+			return msg, nil
+		}
+
+		switch {
+		case st.gone:
+			st.mu.Unlock()
+			return nil, kuromi.ErrSessionClosed
+		case st.eof:
+			st.mu.Unlock()
+			return nil, io.EOF
+		}
+
+		changed := st.changed
+		st.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Write sends msg on the stream, waiting until ctx is done for the client to
+// grant enough window. Messages longer than Window cannot be sent.
+func (st *Stream) Write(ctx context.Context, msg []byte) error {
+	if len(msg) > Window {
+		return ErrMessageTooLong
+	}
+
+	for {
+		st.mu.Lock()
+
+		switch {
+		case st.gone:
+			st.mu.Unlock()
+			return kuromi.ErrSessionClosed
+		case st.closed:
+			st.mu.Unlock()
+			return ErrStreamClosed
+		case st.send >= uint64(len(msg)):
+			st.send -= uint64(len(msg))
+			st.mu.Unlock()
+
+			return st.conn.write(FrameData, st.id, msg)
+		}
+
+		changed := st.changed
+		st.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Close closes the sending side of the stream. The stream is removed once both
+// sides closed it.
+func (st *Stream) Close() error {
+	st.mu.Lock()
+	if st.closed || st.gone {
+		st.mu.Unlock()
+		return nil
+	}
+
+	st.closed = true
+	done := st.eof
+	st.notify()
+	st.mu.Unlock()
+
+	if done {
+		st.conn.remove(st.id)
+	}
+
+	return st.conn.write(FrameClose, st.id, nil)
+}
+
+// receive queues a message sent by the client.
+func (st *Stream) receive(msg []byte) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if st.eof {
+		return ErrStreamClosed
+	}
+
+	if uint64(len(msg)) > st.recv {
+		return ErrWindowExceeded
+	}
+
+	st.recv -= uint64(len(msg))
+	st.queue = append(st.queue, msg)
+	st.notify()
+
+	return nil
+}
+
+// grant adds credit to the send window.
+func (st *Stream) grant(credit uint64) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.send += credit
+	st.notify()
+}
+
+// remoteClose marks the stream closed by the client.
+func (st *Stream) remoteClose() {
+	st.mu.Lock()
+	st.eof = true
+	done := st.closed
+	st.notify()
+	st.mu.Unlock()
+
+	if done {
+		st.conn.remove(st.id)
+	}
+}
+
+// terminate fails the stream after its session disconnected.
+func (st *Stream) terminate() {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.gone = true
+	st.notify()
+}
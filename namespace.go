@@ -0,0 +1,109 @@
+package kuromi
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/coder/websocket"
+)
+
+// Namespace is a logically separate application served over the same
+// endpoints as the rest of the kuromi instance. Sessions select a namespace
+// when connecting with the Config.NamespaceParam query parameter, e.g.
+// /ws?namespace=chat. Handlers that are not set on the namespace fall back
+// to the handlers of the kuromi instance.
+type Namespace struct {
+	handlers
+	name   string
+	kuromi *Kuromi
+}
+
+// Namespace returns the namespace called name, creating it if it does not exist yet.
+func (k *Kuromi) Namespace(name string) *Namespace {
+	k.namespacesMu.Lock()
+	defer k.namespacesMu.Unlock()
+
+	if ns, ok := k.namespaces[name]; ok {
+		return ns
+	}
+
+	ns := &Namespace{
+		handlers: handlers{parent: &k.handlers},
+		name:     name,
+		kuromi:   k,
+	}
+	k.namespaces[name] = ns
+
+	return ns
+}
+
+// namespaceName returns the namespace requested by r, or an empty string if
+// r does not request one or no namespaces are registered.
+func (k *Kuromi) namespaceName(r *http.Request) string {
+	if k.Config.NamespaceParam == "" {
+		return ""
+	}
+
+	k.namespacesMu.RLock()
+	n := len(k.namespaces)
+	k.namespacesMu.RUnlock()
+
+	if n == 0 {
+		return ""
+	}
+
+	return r.URL.Query().Get(k.Config.NamespaceParam)
+}
+
+func (k *Kuromi) lookupNamespace(name string) (*Namespace, bool) {
+	k.namespacesMu.RLock()
+	defer k.namespacesMu.RUnlock()
+
+	ns, ok := k.namespaces[name]
+
+	return ns, ok
+}
+
+// Name returns the name of the namespace.
+func (ns *Namespace) Name() string {
+	return ns.name
+}
+
+// Broadcast broadcasts a text message to all sessions in the namespace.
+func (ns *Namespace) Broadcast(msg []byte) error {
+	return ns.kuromi.broadcast(context.Background(), envelope{t: websocket.MessageText, msg: msg, filter: ns.member})
+}
+
+// BroadcastFilter broadcasts a text message to all sessions in the namespace that fn returns true for.
+func (ns *Namespace) BroadcastFilter(msg []byte, fn func(*Session) bool) error {
+	return ns.kuromi.broadcast(context.Background(), envelope{t: websocket.MessageText, msg: msg, filter: func(s *Session) bool {
+		return ns.member(s) && fn(s)
+	}})
+}
+
+// BroadcastBinary broadcasts a binary message to all sessions in the namespace.
+func (ns *Namespace) BroadcastBinary(msg []byte) error {
+	return ns.kuromi.broadcast(context.Background(), envelope{t: websocket.MessageBinary, msg: msg, filter: ns.member})
+}
+
+// Sessions returns all sessions in the namespace.
+func (ns *Namespace) Sessions() []*Session {
+	var sessions []*Session
+
+	ns.kuromi.hub.sessions.each(func(s *Session) {
+		if ns.member(s) {
+			sessions = append(sessions, s)
+		}
+	})
+
+	return sessions
+}
+
+// Len returns the number of sessions in the namespace.
+func (ns *Namespace) Len() int {
+	return len(ns.Sessions())
+}
+
+func (ns *Namespace) member(s *Session) bool {
+	return s.namespace == ns
+}
@@ -0,0 +1,169 @@
+package kuromi
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/coder/websocket"
+)
+
+// Option configures a kuromi instance created with New or NewWithOptions.
+type Option func(*Kuromi)
+
+// WithConfig replaces the default configuration with c.
+func WithConfig(c *Config) Option {
+	return func(k *Kuromi) {
+		k.Config = c
+	}
+}
+
+// WithAcceptOptions sets the options used to accept websocket connections.
+func WithAcceptOptions(opts *websocket.AcceptOptions) Option {
+	return func(k *Kuromi) {
+		k.AcceptOptions = opts
+	}
+}
+
+// WithWriteWait sets Config.WriteWait.
+func WithWriteWait(d time.Duration) Option {
+	return func(k *Kuromi) {
+		k.Config.WriteWait = d
+	}
+}
+
+// WithPongWait sets Config.PongWait.
+func WithPongWait(d time.Duration) Option {
+	return func(k *Kuromi) {
+		k.Config.PongWait = d
+	}
+}
+
+// WithPingPeriod sets Config.PingPeriod.
+func WithPingPeriod(d time.Duration) Option {
+	return func(k *Kuromi) {
+		k.Config.PingPeriod = d
+	}
+}
+
+// WithMaxMessageSize sets Config.MaxMessageSize.
+func WithMaxMessageSize(n int64) Option {
+	return func(k *Kuromi) {
+		k.Config.MaxMessageSize = n
+	}
+}
+
+// WithMessageBufferSize sets Config.MessageBufferSize.
+func WithMessageBufferSize(n int) Option {
+	return func(k *Kuromi) {
+		k.Config.MessageBufferSize = n
+	}
+}
+
+// WithMessageHandlerWorkers turns on concurrent message handling by n workers.
+func WithMessageHandlerWorkers(n int) Option {
+	return func(k *Kuromi) {
+		k.Config.ConcurrentMessageHandling = true
+		k.Config.MessageHandlerWorkers = n
+	}
+}
+
+// WithMessageHandlerTimeout sets Config.MessageHandlerTimeout.
+func WithMessageHandlerTimeout(d time.Duration) Option {
+	return func(k *Kuromi) {
+		k.Config.MessageHandlerTimeout = d
+	}
+}
+
+// Validate reports an error wrapping ErrInvalidConfig if the configuration
+// is invalid or combines settings that would misbehave at runtime.
+func (c *Config) Validate() error {
+	switch {
+	case c.WriteWait <= 0:
+		return invalidConfig("WriteWait must be positive")
+	case c.PongWait <= 0:
+		return invalidConfig("PongWait must be positive")
+	case c.PingPeriod <= 0:
+		return invalidConfig("PingPeriod must be positive")
+	case c.PingPeriod >= c.PongWait:
+		return invalidConfig("PingPeriod must be less than PongWait")
+	case c.MessageBufferSize <= 0:
+		return invalidConfig("MessageBufferSize must be positive")
+	case c.MessageHandlerWorkers < 0:
+		return invalidConfig("MessageHandlerWorkers must not be negative")
+	case c.MessageHandlerWorkers > 0 && c.MessageHandlerQueueSize <= 0:
+		return invalidConfig("MessageHandlerQueueSize must be positive when using MessageHandlerWorkers")
+	case c.MessageOrderingKey != nil && !c.OrderedMessageHandling:
+		return invalidConfig("MessageOrderingKey requires OrderedMessageHandling")
+	case c.MessageHandlerTimeout < 0:
+		return invalidConfig("MessageHandlerTimeout must not be negative")
+	case c.CloseOnHandlerTimeout && c.MessageHandlerTimeout == 0:
+		return invalidConfig("CloseOnHandlerTimeout requires MessageHandlerTimeout")
+	case c.RoomHistorySize < 0:
+		return invalidConfig("RoomHistorySize must not be negative")
+	case c.RoomHistoryTTL < 0:
+		return invalidConfig("RoomHistoryTTL must not be negative")
+	case c.ResumeGracePeriod > 0 && c.ResumeParam == "":
+		return invalidConfig("ResumeParam must be set when using ResumeGracePeriod")
+	case c.RetransmitBufferSize < 0:
+		return invalidConfig("RetransmitBufferSize must not be negative")
+	case c.AckTimeout <= 0:
+		return invalidConfig("AckTimeout must be positive")
+	case c.AckRetries < 0:
+		return invalidConfig("AckRetries must not be negative")
+	case c.MessageTTL < 0:
+		return invalidConfig("MessageTTL must not be negative")
+	case c.CompressionThreshold < 0:
+		return invalidConfig("CompressionThreshold must not be negative")
+	case c.FloodMessageRate > 0 && c.FloodBurst < 1:
+		return invalidConfig("FloodBurst must be positive when using FloodMessageRate")
+	case c.FloodMaxErrors > 0 && c.FloodWindow <= 0:
+		return invalidConfig("FloodWindow must be positive when using FloodMaxErrors")
+	case c.SlowConsumerHighWater < 0:
+		return invalidConfig("SlowConsumerHighWater must not be negative")
+	case c.SlowConsumerThreshold < 0:
+		return invalidConfig("SlowConsumerThreshold must not be negative")
+	case c.MaxWriteFailures < 0:
+		return invalidConfig("MaxWriteFailures must not be negative")
+	case c.WriteWatchdogGrace < 0:
+		return invalidConfig("WriteWatchdogGrace must not be negative")
+	case c.BulkBufferSize < 0:
+		return invalidConfig("BulkBufferSize must not be negative")
+	case c.MaxPingFailures < 0:
+		return invalidConfig("MaxPingFailures must not be negative")
+	case c.WriteRetries < 0:
+		return invalidConfig("WriteRetries must not be negative")
+	case c.WriteRetries > 0 && c.WriteRetryBackoff <= 0:
+		return invalidConfig("WriteRetryBackoff must be positive when using WriteRetries")
+	case c.BroadcastWorkers < 0:
+		return invalidConfig("BroadcastWorkers must not be negative")
+	case c.BroadcastRate < 0:
+		return invalidConfig("BroadcastRate must not be negative")
+	case c.BroadcastBurst < 0:
+		return invalidConfig("BroadcastBurst must not be negative")
+	case c.BroadcastByteRate < 0:
+		return invalidConfig("BroadcastByteRate must not be negative")
+	case c.BroadcastByteBurst < 0:
+		return invalidConfig("BroadcastByteBurst must not be negative")
+	case c.EgressByteRate < 0:
+		return invalidConfig("EgressByteRate must not be negative")
+	case c.EgressByteBurst < 0:
+		return invalidConfig("EgressByteBurst must not be negative")
+	case c.SessionCookie != nil && len(c.SessionCookie.Secret) == 0:
+		return invalidConfig("SessionCookie.Secret must not be empty")
+	case c.ShutdownTimeout < 0:
+		return invalidConfig("ShutdownTimeout must not be negative")
+	case c.Broker != nil && c.NodeID == "":
+		return invalidConfig("NodeID must be set when using Broker")
+	case strings.Contains(c.NodeID, ":"):
+		return invalidConfig("NodeID must not contain ':'")
+	case c.Broker != nil && c.ClusterHeartbeat <= 0:
+		return invalidConfig("ClusterHeartbeat must be positive when using Broker")
+	}
+
+	return nil
+}
+
+func invalidConfig(reason string) error {
+	return fmt.Errorf("%w: %s", ErrInvalidConfig, reason)
+}
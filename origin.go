@@ -0,0 +1,92 @@
+package kuromi
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// CheckOrigin sets fn to decide whether to accept requests based on their
+// Origin header, replacing the same-origin check and AcceptOptions.OriginPatterns.
+// Rejected requests are answered with 403 Forbidden. See AllowOrigins, SameOrigin
+// and AllowSubdomains for common policies.
+func (k *Kuromi) CheckOrigin(fn func(*http.Request) bool) {
+	k.checkOrigin = fn
+}
+
+// SameOrigin accepts requests without an Origin header and requests whose Origin
+// host equals the Host of the request, which is the default policy.
+func SameOrigin(r *http.Request) bool {
+	u, ok := origin(r)
+	if !ok {
+		return u == nil
+	}
+
+	return strings.EqualFold(u.Host, r.Host)
+}
+
+// AllowOrigins returns an origin check accepting requests without an Origin header
+// and requests from the given origins. Origins are either hosts, e.g.
+// "example.com" or "example.com:8080", matching any scheme, or full origins, e.g.
+// "https://example.com".
+func AllowOrigins(origins ...string) func(*http.Request) bool {
+	return func(r *http.Request) bool {
+		u, ok := origin(r)
+		if !ok {
+			return u == nil
+		}
+
+		for _, o := range origins {
+			if strings.Contains(o, "://") {
+				if strings.EqualFold(o, u.Scheme+"://"+u.Host) {
+					return true
+				}
+			} else if strings.EqualFold(o, u.Host) {
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
+// AllowSubdomains returns an origin check accepting requests without an Origin
+// header and requests from the given domains and any of their subdomains, e.g.
+// AllowSubdomains("example.com") accepts example.com and app.example.com, but
+// not badexample.com.
+func AllowSubdomains(domains ...string) func(*http.Request) bool {
+	return func(r *http.Request) bool {
+		u, ok := origin(r)
+		if !ok {
+			return u == nil
+		}
+
+		host := strings.ToLower(u.Hostname())
+
+		for _, d := range domains {
+			d = strings.ToLower(strings.TrimPrefix(d, "*."))
+
+			if host == d || strings.HasSuffix(host, "."+d) {
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
+// origin parses the Origin header of r. It returns a nil url and false if there
+// is none and a non-nil url and false if it is invalid.
+func origin(r *http.Request) (*url.URL, bool) {
+	o := r.Header.Get("Origin")
+	if o == "" {
+		return nil, false
+	}
+
+	u, err := url.Parse(o)
+	if err != nil || u.Host == "" {
+		return &url.URL{}, false
+	}
+
+	return u, true
+}
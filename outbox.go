@@ -0,0 +1,119 @@
+package kuromi
+
+import (
+	"sync"
+	"time"
+
+	"github.com/coder/websocket"
+)
+
+// OutboxMessage is a message queued for a disconnected user.
+type OutboxMessage struct {
+	Type    websocket.MessageType
+	Data    []byte
+	Queued  time.Time // Time the message was queued at.
+	Expires time.Time // Zero if the message does not expire.
+}
+
+// Expired reports whether the message expired at time now.
+func (m OutboxMessage) Expired(now time.Time) bool {
+	return !m.Expires.IsZero() && now.After(m.Expires)
+}
+
+// OutboxStore stores the messages sent to users while they are disconnected,
+// see Config.Outbox. Implementations must be safe for concurrent use.
+type OutboxStore interface {
+	// Push queues msg for the user id.
+	Push(id string, msg OutboxMessage) error
+	// Pop removes and returns the messages queued for the user id, oldest first.
+	Pop(id string) ([]OutboxMessage, error)
+}
+
+// MemoryOutbox is an in-memory OutboxStore keeping at most Limit messages per
+// user, dropping the oldest ones when it is full. Messages of the user that
+// expired by the time a message is queued are dropped.
+type MemoryOutbox struct {
+	Limit int // Maximum number of messages per user, 0 means unbounded.
+	mu    sync.Mutex
+	users map[string][]OutboxMessage
+}
+
+// NewMemoryOutbox creates a MemoryOutbox keeping at most limit messages per user.
+func NewMemoryOutbox(limit int) *MemoryOutbox {
+	return &MemoryOutbox{
+		Limit: limit,
+		users: make(map[string][]OutboxMessage),
+	}
+}
+
+// Push implements OutboxStore.
+func (o *MemoryOutbox) Push(id string, msg OutboxMessage) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	queued := o.users[id][:0]
+	for _, m := range o.users[id] {
+		if !m.Expired(msg.Queued) {
+			queued = append(queued, m)
+		}
+	}
+
+	queued = append(queued, msg)
+
+	if o.Limit > 0 && len(queued) > o.Limit {
+		queued = queued[len(queued)-o.Limit:]
+	}
+
+	if o.users == nil {
+		o.users = make(map[string][]OutboxMessage)
+	}
+
+	o.users[id] = queued
+
+	return nil
+}
+
+// Pop implements OutboxStore.
+func (o *MemoryOutbox) Pop(id string) ([]OutboxMessage, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	queued := o.users[id]
+	delete(o.users, id)
+
+	return queued, nil
+}
+
+// enqueue stores message in the outbox of the user id.
+func (k *Kuromi) enqueue(id string, message envelope) error {
+	msg := OutboxMessage{Type: message.t, Data: message.msg, Queued: k.now()}
+
+	if k.Config.OutboxTTL > 0 {
+		msg.Expires = msg.Queued.Add(k.Config.OutboxTTL)
+	}
+
+	return k.Config.Outbox.Push(id, msg)
+}
+
+// deliverOutbox writes the messages queued for the user of s to s.
+func (k *Kuromi) deliverOutbox(s *Session, id string) {
+	if k.Config.Outbox == nil {
+		return
+	}
+
+	queued, err := k.Config.Outbox.Pop(id)
+	if err != nil {
+		s.handlers.onError(s, err)
+		return
+	}
+
+	now := k.now()
+
+	for _, msg := range queued {
+		if msg.Expired(now) {
+			continue
+		}
+
+		s.writeMessage(envelope{t: msg.Type, msg: msg.Data})
+	}
+}
@@ -0,0 +1,38 @@
+package kuromi
+
+import (
+	"bytes"
+
+	"github.com/coder/websocket"
+)
+
+// When Config.PingPayload is set every ping is followed by the text message
+// PingPrefix and the payload, e.g. "kuromi.ping:1700000000000". Clients echo
+// the payload by sending the text message PongPrefix followed by it, e.g.
+// "kuromi.pong:1700000000000", which is passed to HandlePong. Websocket pings
+// cannot carry custom payloads through every transport, so the payload travels
+// as an application message.
+const (
+	PingPrefix = "kuromi.ping:"
+	PongPrefix = "kuromi.pong:"
+)
+
+// writePingPayload writes the ping payload of the session.
+func (s *Session) writePingPayload(payload []byte) error {
+	msg := append([]byte(PingPrefix), payload...)
+
+	return s.writeRaw(envelope{t: websocket.MessageText, msg: msg})
+}
+
+// handlePong passes the payload of a pong message to HandlePong and reports
+// whether message was a pong message.
+func (s *Session) handlePong(message []byte) bool {
+	if s.kuromi.Config.PingPayload == nil || !bytes.HasPrefix(message, []byte(PongPrefix)) {
+		return false
+	}
+
+	payload := message[len(PongPrefix):]
+	s.protect(func() { s.handlers.onPong(s, payload) })
+
+	return true
+}
@@ -0,0 +1,117 @@
+package kuromi
+
+import (
+	"hash/fnv"
+	"sync"
+
+	"github.com/coder/websocket"
+)
+
+type workerPool struct {
+	jobs     chan func()
+	keyed    []chan func()
+	quit     chan struct{}
+	stopOnce sync.Once
+}
+
+func newWorkerPool(workers, queueSize int) *workerPool {
+	p := &workerPool{
+		jobs:  make(chan func(), queueSize),
+		keyed: make([]chan func(), workers),
+		quit:  make(chan struct{}),
+	}
+
+	// The keyed queues share queueSize, so ordered handling queues no more
+	// messages than unordered handling.
+	keyedSize := max(1, queueSize/workers)
+
+	for i := range p.keyed {
+		p.keyed[i] = make(chan func(), keyedSize)
+		go p.work(p.keyed[i])
+	}
+
+	return p
+}
+
+// work runs jobs from the shared queue and from the worker's own keyed queue.
+// Jobs in the keyed queue are run in the order they were submitted.
+func (p *workerPool) work(keyed chan func()) {
+	for {
+		select {
+		case job := <-p.jobs:
+			job()
+		case job := <-keyed:
+			job()
+		case <-p.quit:
+			return
+		}
+	}
+}
+
+// submit queues job without blocking and reports whether it was accepted.
+func (p *workerPool) submit(job func()) bool {
+	return p.enqueue(p.jobs, job)
+}
+
+// submitKeyed queues job on the worker owning key, so jobs with the same key
+// are run one at a time in submission order.
+func (p *workerPool) submitKeyed(key uint64, job func()) bool {
+	return p.enqueue(p.keyed[key%uint64(len(p.keyed))], job)
+}
+
+func (p *workerPool) enqueue(queue chan func(), job func()) bool {
+	select {
+	case <-p.quit:
+		return false
+	default:
+	}
+
+	select {
+	case queue <- job:
+		return true
+	default:
+		return false
+	}
+}
+
+func (p *workerPool) stop() {
+	p.stopOnce.Do(func() {
+		close(p.quit)
+	})
+}
+
+// workers returns the message handler worker pool, starting it on first use.
+func (k *Kuromi) workers() *workerPool {
+	k.poolOnce.Do(func() {
+		k.pool = newWorkerPool(k.Config.MessageHandlerWorkers, k.Config.MessageHandlerQueueSize)
+	})
+
+	return k.pool
+}
+
+func (k *Kuromi) stopWorkers() {
+	k.poolOnce.Do(func() {})
+
+	if k.pool != nil {
+		k.pool.stop()
+	}
+}
+
+// dispatch hands a message to the worker pool and reports whether it was accepted.
+func (k *Kuromi) dispatch(s *Session, t websocket.MessageType, message []byte, header Header) bool {
+	job := func() { s.handleMessage(t, message, header) }
+
+	if !k.Config.OrderedMessageHandling {
+		return k.workers().submit(job)
+	}
+
+	key := s.id
+
+	if k.Config.MessageOrderingKey != nil {
+		h := fnv.New64a()
+		h.Write([]byte(k.Config.MessageOrderingKey(s, message)))
+		key = h.Sum64()
+	}
+
+	return k.workers().submitKeyed(key, job)
+}
@@ -0,0 +1,74 @@
+package kuromi
+
+import (
+	"context"
+
+	"github.com/coder/websocket"
+)
+
+// Priority is the lane of the output queue of a session a message is queued in.
+// Queued messages of a higher priority are written first, so small control
+// messages are not stuck behind large payloads on slow links. Messages of the
+// same priority are written in order. Sequence numbers, see
+// Config.SequenceMessages, are assigned when messages are queued, so clients
+// may receive them out of order across priorities.
+type Priority int
+
+const (
+	// PriorityInteractive is the priority of messages written without one.
+	PriorityInteractive Priority = iota
+	// PriorityControl is for small control messages, e.g. heartbeats, which are
+	// written before all other queued messages. At most 16 can be queued.
+	PriorityControl
+	// PriorityBulk is for large or background payloads, written when no other
+	// messages are queued. See Config.BulkBufferSize.
+	PriorityBulk
+)
+
+// controlBufferSize is the number of PriorityControl messages queued for a session at most.
+const controlBufferSize = 16
+
+// lane returns the queue of messages with priority p.
+func (s *Session) lane(p Priority) chan envelope {
+	switch {
+	case p == PriorityControl:
+		return s.control
+	case p == PriorityBulk && s.bulk != nil:
+		return s.bulk
+	}
+
+	return s.output
+}
+
+// dequeue takes the queued message of the highest priority without blocking.
+func (s *Session) dequeue() (envelope, bool) {
+	for _, lane := range [...]chan envelope{s.control, s.output, s.bulk} {
+		select {
+		case message := <-lane:
+			return message, true
+		default:
+		}
+	}
+
+	return envelope{}, false
+}
+
+// WriteWithPriority writes a text message to the session in the lane of priority p.
+func (s *Session) WriteWithPriority(msg []byte, p Priority) error {
+	return s.write(envelope{t: websocket.MessageText, msg: msg, priority: p})
+}
+
+// WriteBinaryWithPriority writes a binary message to the session in the lane of priority p.
+func (s *Session) WriteBinaryWithPriority(msg []byte, p Priority) error {
+	return s.write(envelope{t: websocket.MessageBinary, msg: msg, priority: p})
+}
+
+// BroadcastWithPriority broadcasts a text message to all sessions in the lane of priority p.
+func (k *Kuromi) BroadcastWithPriority(msg []byte, p Priority) error {
+	return k.broadcast(context.Background(), envelope{t: websocket.MessageText, msg: msg, priority: p})
+}
+
+// BroadcastBinaryWithPriority broadcasts a binary message to all sessions in the lane of priority p.
+func (k *Kuromi) BroadcastBinaryWithPriority(msg []byte, p Priority) error {
+	return k.broadcast(context.Background(), envelope{t: websocket.MessageBinary, msg: msg, priority: p})
+}
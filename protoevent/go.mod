@@ -0,0 +1,12 @@
+module github.com/fshiori/kuromi/protoevent
+
+go 1.22.6
+
+require (
+	github.com/fshiori/kuromi v0.0.0
+	google.golang.org/protobuf v1.34.1
+)
+
+require github.com/coder/websocket v1.8.12 // indirect
+
+replace github.com/fshiori/kuromi => ../
@@ -0,0 +1,227 @@
+// Package protoevent maps protobuf message types to type IDs carried in binary
+// messages, so sessions can exchange several protobuf messages over one
+// connection with typed handlers instead of hand-decoding HandleMessageBinary.
+//
+// Every binary message is the type ID as an unsigned varint followed by the
+// protobuf encoding of the message.
+//
+//	r := protoevent.New(k)
+//	protoevent.Register[*pb.Move](r, 1)
+//	protoevent.Register[*pb.Chat](r, 2)
+//	protoevent.OnProto(r, func(s *kuromi.Session, m *pb.Chat) {
+//		r.BroadcastProto(k, m)
+//	})
+package protoevent
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/fshiori/kuromi"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+var (
+	ErrMalformed     = errors.New("malformed protobuf event")
+	ErrUnknownType   = errors.New("unknown protobuf event type id")
+	ErrUnregistered  = errors.New("protobuf message type is not registered")
+	ErrDuplicateType = errors.New("protobuf message type or type id is already registered")
+	ErrNoHandler     = errors.New("no handler for protobuf event type")
+)
+
+// Handlers is implemented by *kuromi.Kuromi, *kuromi.Route and *kuromi.Namespace.
+type Handlers interface {
+	HandleMessageBinary(func(*kuromi.Session, []byte))
+}
+
+// event is a registered message type.
+type event struct {
+	typ     protoreflect.MessageType
+	handler func(*kuromi.Session, proto.Message)
+}
+
+func (e *event) decode(body []byte) (proto.Message, error) {
+	m := e.typ.New().Interface()
+	if err := proto.Unmarshal(body, m); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+
+	return m, nil
+}
+
+// Registry maps protobuf message types to type IDs and dispatches the binary
+// messages of sessions to the handlers of their type.
+type Registry struct {
+	mu     sync.RWMutex
+	byID   map[uint64]*event
+	byName map[protoreflect.FullName]uint64
+
+	invalid func(s *kuromi.Session, msg []byte, err error)
+}
+
+// New creates a Registry and installs its binary message handler on h.
+func New(h Handlers) *Registry {
+	r := &Registry{
+		byID:   make(map[uint64]*event),
+		byName: make(map[protoreflect.FullName]uint64),
+	}
+
+	h.HandleMessageBinary(r.dispatch)
+
+	return r
+}
+
+// Register maps the message type T, e.g. *pb.Chat, to id. IDs below 128 take a
+// single byte on the wire. An error is returned if T or id is already registered.
+func Register[T proto.Message](r *Registry, id uint64) error {
+	var zero T
+	typ := zero.ProtoReflect().Type()
+	name := typ.Descriptor().FullName()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.byID[id]; ok {
+		return fmt.Errorf("%w: %d", ErrDuplicateType, id)
+	}
+
+	if _, ok := r.byName[name]; ok {
+		return fmt.Errorf("%w: %s", ErrDuplicateType, name)
+	}
+
+	r.byID[id] = &event{typ: typ}
+	r.byName[name] = id
+
+	return nil
+}
+
+// OnProto fires fn with the messages of type T sent by sessions. It panics if T
+// is not registered.
+func OnProto[T proto.Message](r *Registry, fn func(*kuromi.Session, T)) {
+	var zero T
+	name := zero.ProtoReflect().Descriptor().FullName()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	id, ok := r.byName[name]
+	if !ok {
+		panic(fmt.Sprintf("protoevent: %s is not registered", name))
+	}
+
+	r.byID[id].handler = func(s *kuromi.Session, m proto.Message) {
+		fn(s, m.(T))
+	}
+}
+
+// HandleInvalid fires fn with the message and the reason when a session sends a
+// binary message that is malformed, of an unknown type or of a type without handler.
+func (r *Registry) HandleInvalid(fn func(s *kuromi.Session, msg []byte, err error)) {
+	r.invalid = fn
+}
+
+// Marshal returns the binary message carrying m.
+func (r *Registry) Marshal(m proto.Message) ([]byte, error) {
+	name := m.ProtoReflect().Descriptor().FullName()
+
+	r.mu.RLock()
+	id, ok := r.byName[name]
+	r.mu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnregistered, name)
+	}
+
+	return proto.MarshalOptions{}.MarshalAppend(binary.AppendUvarint(nil, id), m)
+}
+
+// Unmarshal decodes a binary message into a new message of its registered type.
+func (r *Registry) Unmarshal(msg []byte) (proto.Message, error) {
+	e, body, err := r.lookup(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	return e.decode(body)
+}
+
+// WriteProto writes m to s as a binary message.
+func (r *Registry) WriteProto(s *kuromi.Session, m proto.Message) error {
+	msg, err := r.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	return s.WriteBinary(msg)
+}
+
+// BroadcastProto broadcasts m to all sessions of k as a binary message.
+func (r *Registry) BroadcastProto(k *kuromi.Kuromi, m proto.Message) error {
+	msg, err := r.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	return k.BroadcastBinary(msg)
+}
+
+// BroadcastProtoRoom broadcasts m to all sessions in room as a binary message.
+func (r *Registry) BroadcastProtoRoom(k *kuromi.Kuromi, room string, m proto.Message) error {
+	msg, err := r.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	return k.BroadcastRoomBinary(room, msg)
+}
+
+func (r *Registry) lookup(msg []byte) (*event, []byte, error) {
+	id, n := binary.Uvarint(msg)
+	if n <= 0 {
+		return nil, nil, ErrMalformed
+	}
+
+	r.mu.RLock()
+	e, ok := r.byID[id]
+	r.mu.RUnlock()
+
+	if !ok {
+		return nil, nil, fmt.Errorf("%w: %d", ErrUnknownType, id)
+	}
+
+	return e, msg[n:], nil
+}
+
+func (r *Registry) dispatch(s *kuromi.Session, msg []byte) {
+	e, body, err := r.lookup(msg)
+	if err != nil {
+		r.fail(s, msg, err)
+		return
+	}
+
+	r.mu.RLock()
+	handler := e.handler
+	r.mu.RUnlock()
+
+	if handler == nil {
+		r.fail(s, msg, fmt.Errorf("%w: %s", ErrNoHandler, e.typ.Descriptor().FullName()))
+		return
+	}
+
+	m, err := e.decode(body)
+	if err != nil {
+		r.fail(s, msg, err)
+		return
+	}
+
+	handler(s, m)
+}
+
+func (r *Registry) fail(s *kuromi.Session, msg []byte, err error) {
+	if r.invalid != nil {
+		r.invalid(s, msg, err)
+	}
+}
@@ -0,0 +1,201 @@
+// Package proxyproto implements the HAProxy PROXY protocol, versions 1 and 2,
+// for kuromi servers behind TCP load balancers. Connections accepted by a
+// Listener report the client address sent by the load balancer as their
+// remote address, so Session.ClientIP reflects the real client:
+//
+//	l, err := net.Listen("tcp", ":8080")
+//	...
+//	k.Serve(proxyproto.NewListener(l))
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultHeaderTimeout is how long a connection has to send its PROXY header
+// when Listener.HeaderTimeout is not set.
+const DefaultHeaderTimeout = 10 * time.Second
+
+// ErrInvalidHeader is returned by reads of a connection that did not start with a valid PROXY header.
+var ErrInvalidHeader = errors.New("proxyproto: invalid PROXY header")
+
+var v2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// maxV1HeaderLength is the maximum length of a version 1 header, including CRLF.
+const maxV1HeaderLength = 107
+
+// Listener accepts connections starting with a PROXY header.
+type Listener struct {
+	net.Listener
+	HeaderTimeout time.Duration       // How long a connection has to send its header, DefaultHeaderTimeout if 0.
+	Trusted       func(net.Addr) bool // Optional function reporting whether a load balancer is trusted, connections from other addresses are used as is.
+}
+
+// NewListener returns a Listener accepting connections from l.
+func NewListener(l net.Listener) *Listener {
+	return &Listener{Listener: l}
+}
+
+// Accept accepts a connection. The PROXY header is read on the first call to
+// Read or RemoteAddr, so a slow client does not block accepting other connections.
+func (l *Listener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	if l.Trusted != nil && !l.Trusted(c.RemoteAddr()) {
+		return c, nil
+	}
+
+	timeout := l.HeaderTimeout
+	if timeout == 0 {
+		timeout = DefaultHeaderTimeout
+	}
+
+	return &Conn{Conn: c, r: bufio.NewReader(c), timeout: timeout}, nil
+}
+
+// Conn is a connection accepted by a Listener.
+type Conn struct {
+	net.Conn
+	r       *bufio.Reader
+	timeout time.Duration
+	once    sync.Once
+	remote  net.Addr
+	err     error
+}
+
+// Read reads data from the connection, after its PROXY header.
+func (c *Conn) Read(p []byte) (int, error) {
+	c.once.Do(c.readHeader)
+
+	if c.err != nil {
+		return 0, c.err
+	}
+
+	return c.r.Read(p)
+}
+
+// RemoteAddr returns the client address sent in the PROXY header, or the
+// address of the load balancer if the header did not carry one.
+func (c *Conn) RemoteAddr() net.Addr {
+	c.once.Do(c.readHeader)
+
+	if c.remote != nil {
+		return c.remote
+	}
+
+	return c.Conn.RemoteAddr()
+}
+
+func (c *Conn) readHeader() {
+	c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+	defer c.Conn.SetReadDeadline(time.Time{})
+
+	start, err := c.r.Peek(len(v2Signature))
+	if err != nil {
+		c.err = fmt.Errorf("%w: %v", ErrInvalidHeader, err)
+		return
+	}
+
+	if bytes.Equal(start, v2Signature) {
+		c.remote, c.err = readV2(c.r)
+	} else {
+		c.remote, c.err = readV1(c.r)
+	}
+}
+
+// readV1 reads a human-readable header, e.g. "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n".
+func readV1(r *bufio.Reader) (net.Addr, error) {
+	var line []byte
+
+	for len(line) < maxV1HeaderLength {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidHeader, err)
+		}
+
+		line = append(line, b)
+
+		if bytes.HasSuffix(line, []byte("\r\n")) {
+			break
+		}
+	}
+
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, ErrInvalidHeader
+	}
+
+	fields := strings.Fields(string(line))
+	if len(fields) < 2 || fields[0] != "PROXY" {
+		return nil, ErrInvalidHeader
+	}
+
+	switch fields[1] {
+	case "UNKNOWN":
+		return nil, nil
+	case "TCP4", "TCP6":
+	default:
+		return nil, ErrInvalidHeader
+	}
+
+	if len(fields) != 6 {
+		return nil, ErrInvalidHeader
+	}
+
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if ip == nil || err != nil {
+		return nil, ErrInvalidHeader
+	}
+
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// readV2 reads a binary header.
+func readV2(r *bufio.Reader) (net.Addr, error) {
+	var hdr [16]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidHeader, err)
+	}
+
+	if hdr[12]>>4 != 2 {
+		return nil, ErrInvalidHeader
+	}
+
+	body := make([]byte, binary.BigEndian.Uint16(hdr[14:16]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidHeader, err)
+	}
+
+	// LOCAL connections, e.g. health checks of the load balancer, carry no address.
+	if hdr[12]&0x0f == 0 {
+		return nil, nil
+	}
+
+	switch hdr[13] >> 4 {
+	case 1: // AF_INET
+		if len(body) < 12 {
+			return nil, ErrInvalidHeader
+		}
+		return &net.TCPAddr{IP: net.IP(body[0:4]), Port: int(binary.BigEndian.Uint16(body[8:10]))}, nil
+	case 2: // AF_INET6
+		if len(body) < 36 {
+			return nil, ErrInvalidHeader
+		}
+		return &net.TCPAddr{IP: net.IP(body[0:16]), Port: int(binary.BigEndian.Uint16(body[32:34]))}, nil
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,148 @@
+package kuromi
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync"
+	"time"
+)
+
+// BroadcastLimitPolicy is what happens to broadcasts exceeding the instance-wide
+// rate limit set with Config.BroadcastRate and Config.BroadcastByteRate.
+type BroadcastLimitPolicy int
+
+const (
+	// BroadcastLimitWait blocks the broadcast until it is within the limit or
+	// its context is done, which is the default.
+	BroadcastLimitWait BroadcastLimitPolicy = iota
+	// BroadcastLimitDrop silently drops the broadcast.
+	BroadcastLimitDrop
+	// BroadcastLimitError drops the broadcast and returns ErrBroadcastLimited.
+	BroadcastLimitError
+)
+
+// tokenBucket is a token bucket refilled at a rate per second up to a burst.
+// It is not safe for concurrent use.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// wait returns how long it takes until n tokens can be taken, at most burst
+// are required so larger amounts are not refused forever.
+func (b *tokenBucket) wait(n, rate, burst float64, now time.Time) time.Duration {
+	if b.last.IsZero() {
+		b.tokens = burst
+	} else {
+		b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*rate)
+	}
+
+	b.last = now
+
+	need := math.Min(n, burst)
+	if b.tokens >= need {
+		return 0
+	}
+
+	return time.Duration(math.Ceil((need - b.tokens) / rate * float64(time.Second)))
+}
+
+// take takes n tokens, the bucket may go into debt.
+func (b *tokenBucket) take(n float64) {
+	b.tokens -= n
+}
+
+// burstOf returns burst, or rate if burst is not positive, but at least 1.
+func burstOf(rate float64, burst int) float64 {
+	if burst > 0 {
+		return float64(burst)
+	}
+
+	return math.Max(1, rate)
+}
+
+// broadcastLimiter limits the message and byte rate of all broadcasts.
+type broadcastLimiter struct {
+	mu       sync.Mutex
+	messages tokenBucket
+	bytes    tokenBucket
+}
+
+// errBroadcastDropped makes broadcast drop a message without returning an error.
+var errBroadcastDropped = errors.New("broadcast dropped")
+
+// admitBroadcast applies the broadcast rate limit to a broadcast of size bytes
+// and reports whether it may be sent, or the error to return if it is rejected.
+func (k *Kuromi) admitBroadcast(ctx context.Context, size int) (bool, error) {
+	err := k.limitBroadcast(ctx, size)
+	if err == errBroadcastDropped {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+// limitBroadcast waits until a broadcast of size bytes is within the broadcast
+// rate limit or applies Config.BroadcastLimitPolicy.
+func (k *Kuromi) limitBroadcast(ctx context.Context, size int) error {
+	c := k.Config
+	if c.BroadcastRate <= 0 && c.BroadcastByteRate <= 0 {
+		return nil
+	}
+
+	for {
+		wait := k.reserveBroadcast(size)
+		if wait == 0 {
+			return nil
+		}
+
+		switch c.BroadcastLimitPolicy {
+		case BroadcastLimitDrop:
+			k.hub.stats.limited.Add(1)
+			return errBroadcastDropped
+		case BroadcastLimitError:
+			k.hub.stats.limited.Add(1)
+			return ErrBroadcastLimited
+		}
+
+		timer := k.clock().NewTimer(wait)
+
+		select {
+		case <-timer.C():
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+// reserveBroadcast takes the tokens for a broadcast of size bytes and returns 0,
+// or returns how long to wait if they are not available.
+func (k *Kuromi) reserveBroadcast(size int) time.Duration {
+	c := k.Config
+	l := &k.broadcastLimit
+	now := k.now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var wait time.Duration
+
+	if c.BroadcastRate > 0 {
+		wait = l.messages.wait(1, c.BroadcastRate, burstOf(c.BroadcastRate, c.BroadcastBurst), now)
+	}
+
+	if c.BroadcastByteRate > 0 {
+		wait = max(wait, l.bytes.wait(float64(size), c.BroadcastByteRate, burstOf(c.BroadcastByteRate, c.BroadcastByteBurst), now))
+	}
+
+	if wait > 0 {
+		return wait
+	}
+
+	l.messages.take(1)
+	l.bytes.take(float64(size))
+
+	return 0
+}
@@ -0,0 +1,22 @@
+package kuromi
+
+import "bytes"
+
+// Clients send delivery receipts as the text message ReceiptPrefix followed by
+// an application defined message id, e.g. "kuromi.receipt:msg-42". Receipts
+// are passed to the handler set with HandleReceipt instead of the message handler.
+const ReceiptPrefix = "kuromi.receipt:"
+
+// handleReceipt passes the receipt in message to the receipt handler and
+// reports whether message was one.
+func (s *Session) handleReceipt(message []byte) bool {
+	if !bytes.HasPrefix(message, []byte(ReceiptPrefix)) {
+		return false
+	}
+
+	id := string(message[len(ReceiptPrefix):])
+
+	s.protect(func() { s.handlers.onReceipt(s, id) })
+
+	return true
+}
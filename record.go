@@ -0,0 +1,183 @@
+package kuromi
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/coder/websocket"
+)
+
+// RecordedFrame is a message received from a session, recorded with Session.Record.
+// Recordings are stored as one JSON encoded frame per line.
+type RecordedFrame struct {
+	Offset time.Duration         `json:"offset"` // Time since the recording started.
+	Type   websocket.MessageType `json:"type"`
+	Data   []byte                `json:"data"`
+}
+
+type recorder struct {
+	mu    sync.Mutex
+	enc   *json.Encoder
+	start time.Time
+	err   error
+}
+
+func (rec *recorder) record(t websocket.MessageType, msg []byte) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	if rec.err != nil {
+		return
+	}
+
+	rec.err = rec.enc.Encode(RecordedFrame{Offset: time.Since(rec.start), Type: t, Data: msg})
+}
+
+// Record starts recording the messages received from the session to w, replacing
+// any previous recording. Recordings can be fed back through the handlers with
+// Kuromi.Replay to reproduce an incident.
+func (s *Session) Record(w io.Writer) {
+	s.rwmutex.Lock()
+	defer s.rwmutex.Unlock()
+
+	s.recorder = &recorder{enc: json.NewEncoder(w), start: time.Now()}
+}
+
+// StopRecording stops recording the messages received from the session and
+// returns the first error writing the recording, if any.
+func (s *Session) StopRecording() error {
+	s.rwmutex.Lock()
+	defer s.rwmutex.Unlock()
+
+	rec := s.recorder
+	s.recorder = nil
+
+	if rec == nil {
+		return nil
+	}
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	return rec.err
+}
+
+func (s *Session) record(t websocket.MessageType, msg []byte) {
+	s.rwmutex.RLock()
+	rec := s.recorder
+	s.rwmutex.RUnlock()
+
+	if rec != nil {
+		rec.record(t, msg)
+	}
+}
+
+// ReplayOptions configures Kuromi.Replay.
+type ReplayOptions struct {
+	Request  *http.Request                       // Request of the replayed session, a GET / request if nil.
+	Keys     map[string]any                      // Keys of the replayed session.
+	Realtime bool                                // Wait for the recorded offset before passing a message on, instead of replaying as fast as possible.
+	OnWrite  func(websocket.MessageType, []byte) // Optional function receiving the messages written to the replayed session.
+}
+
+// Replay feeds a recording made with Session.Record through the handlers of the
+// kuromi instance as a new session, which connects, receives the recorded
+// messages and disconnects. It returns when the session disconnected.
+func (k *Kuromi) Replay(ctx context.Context, r io.Reader, opts ReplayOptions) error {
+	if k.hub.closed() {
+		return ErrClosed
+	}
+
+	req := opts.Request
+	if req == nil {
+		req, _ = http.NewRequestWithContext(ctx, http.MethodGet, "/", nil)
+	}
+
+	c := &replayTransport{
+		ctx:      ctx,
+		dec:      json.NewDecoder(bufio.NewReader(r)),
+		realtime: opts.Realtime,
+		onWrite:  opts.OnWrite,
+		start:    time.Now(),
+		closed:   make(chan struct{}),
+	}
+
+	c.session = k.newSession(req, opts.Keys, c, k.lookupRoute(req.URL.Path), nil, "")
+	k.serve(c.session)
+
+	if c.err != nil && !errors.Is(c.err, io.EOF) {
+		return c.err
+	}
+
+	return ctx.Err()
+}
+
+// replayTransport is a transport reading recorded frames.
+type replayTransport struct {
+	ctx      context.Context
+	dec      *json.Decoder
+	realtime bool
+	onWrite  func(websocket.MessageType, []byte)
+	start    time.Time
+	session  *Session
+	err      error
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+func (rt *replayTransport) Read(ctx context.Context) (websocket.MessageType, []byte, error) {
+	var f RecordedFrame
+
+	if err := rt.dec.Decode(&f); err != nil {
+		rt.err = err
+		rt.session.Flush(rt.ctx)
+		return 0, nil, websocket.CloseError{Code: websocket.StatusNormalClosure}
+	}
+
+	if rt.realtime {
+		timer := time.NewTimer(time.Until(rt.start.Add(f.Offset)))
+		defer timer.Stop()
+
+		select {
+		case <-timer.C:
+		case <-rt.ctx.Done():
+			return 0, nil, rt.ctx.Err()
+		case <-rt.closed:
+			return 0, nil, net.ErrClosed
+		}
+	}
+
+	if err := rt.ctx.Err(); err != nil {
+		return 0, nil, err
+	}
+
+	return f.Type, f.Data, nil
+}
+
+func (rt *replayTransport) Write(ctx context.Context, typ websocket.MessageType, p []byte) error {
+	if rt.onWrite != nil {
+		rt.onWrite(typ, p)
+	}
+
+	return nil
+}
+
+func (rt *replayTransport) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (rt *replayTransport) Close(code websocket.StatusCode, reason string) error {
+	rt.closeOnce.Do(func() { close(rt.closed) })
+
+	return nil
+}
+
+func (rt *replayTransport) SetReadLimit(n int64) {}
@@ -0,0 +1,177 @@
+package kuromi
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// parkedSession is the state of a disconnected session kept for Config.ResumeGracePeriod.
+type parkedSession struct {
+	keys          map[string]any
+	user          string
+	rooms         map[string]any
+	tags          []string
+	roles         []string
+	subscriptions []string
+	queued        []envelope
+	data          any
+	timer         Timer
+}
+
+type resumeStore struct {
+	mu     sync.Mutex
+	parked map[string]*parkedSession
+}
+
+func newResumeStore() *resumeStore {
+	return &resumeStore{
+		parked: make(map[string]*parkedSession),
+	}
+}
+
+func (rs *resumeStore) park(token string, p *parkedSession, grace time.Duration, clock Clock) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	p.timer = clock.AfterFunc(grace, func() {
+		rs.mu.Lock()
+		defer rs.mu.Unlock()
+
+		if rs.parked[token] == p {
+			delete(rs.parked, token)
+		}
+	})
+
+	rs.parked[token] = p
+}
+
+// take removes and returns the state parked under token.
+func (rs *resumeStore) take(token string) (*parkedSession, bool) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	p, ok := rs.parked[token]
+	if !ok {
+		return nil, false
+	}
+
+	p.timer.Stop()
+	delete(rs.parked, token)
+
+	return p, true
+}
+
+func newResumeToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+
+	return hex.EncodeToString(b)
+}
+
+// ResumeToken returns the token a client can use to resume the session after
+// disconnecting, or an empty string if Config.ResumeGracePeriod is not set.
+// The token is only valid once; a resumed session is issued a new token.
+func (s *Session) ResumeToken() string {
+	return s.resumeToken
+}
+
+// Resumed reports whether the session resumed the state of a disconnected session.
+func (s *Session) Resumed() bool {
+	return s.resumed
+}
+
+// park keeps the state of the disconnected session s so it can be resumed.
+// It must be called before s is untracked.
+func (k *Kuromi) park(s *Session) {
+	if s.resumeToken == "" || k.hub.closed() {
+		return
+	}
+
+	p := &parkedSession{
+		keys:          s.KeysSnapshot(),
+		user:          s.UserID(),
+		rooms:         make(map[string]any),
+		tags:          s.Tags(),
+		roles:         s.Roles(),
+		subscriptions: s.Subscriptions(),
+		data:          s.data,
+	}
+
+	for _, room := range s.Rooms() {
+		if meta, ok := k.rooms.meta(room, s); ok {
+			p.rooms[room] = meta
+		}
+	}
+
+	for {
+		message, ok := s.dequeue()
+		if !ok {
+			break
+		}
+
+		s.pending.Add(-1)
+
+		if message.t != CloseMessage && !message.canceled() {
+			p.queued = append(p.queued, message)
+		}
+	}
+
+	k.resumes.park(s.resumeToken, p, k.Config.ResumeGracePeriod, k.clock())
+}
+
+// resume restores the state parked under the resume token of the request onto s.
+func (k *Kuromi) resume(s *Session) {
+	token := s.Request.URL.Query().Get(k.Config.ResumeParam)
+	if token == "" {
+		return
+	}
+
+	p, ok := k.resumes.take(token)
+	if !ok {
+		return
+	}
+
+	s.resumed = true
+
+	if p.data != nil {
+		s.data = p.data
+	}
+
+	s.rwmutex.Lock()
+	if s.Keys == nil {
+		s.Keys = make(map[string]any)
+	}
+
+	for key, value := range p.keys {
+		if _, exists := s.Keys[key]; !exists {
+			s.Keys[key] = value
+		}
+	}
+	s.rwmutex.Unlock()
+
+	if p.user != "" {
+		s.BindUser(p.user)
+	}
+
+	for room, meta := range p.rooms {
+		s.join(room, meta, false)
+	}
+
+	for _, tag := range p.tags {
+		s.AddTag(tag)
+	}
+
+	for _, role := range p.roles {
+		s.AddRole(role)
+	}
+
+	for _, pattern := range p.subscriptions {
+		s.Subscribe(pattern)
+	}
+
+	for _, message := range p.queued {
+		s.writeMessage(message)
+	}
+}
@@ -0,0 +1,56 @@
+package kuromi
+
+import (
+	"context"
+	"errors"
+	"net"
+
+	"github.com/coder/websocket"
+)
+
+// writeWithRetry writes message to the session, writing it again up to
+// Config.WriteRetries times with exponential backoff if the write failed with
+// a transient error.
+func (s *Session) writeWithRetry(message envelope) error {
+	err := s.writeRaw(message)
+	backoff := s.kuromi.Config.WriteRetryBackoff
+
+	for i := 0; i < s.kuromi.Config.WriteRetries && err != nil && transientWriteError(err); i++ {
+		timer := s.kuromi.clock().NewTimer(backoff)
+
+		select {
+		case <-timer.C():
+		case <-s.outputDone:
+			timer.Stop()
+			return err
+		}
+
+		backoff *= 2
+		err = s.writeRaw(message)
+	}
+
+	return err
+}
+
+// transientWriteError reports whether a write failed with err may succeed when
+// retried, i.e. it timed out without the connection being closed. Note that
+// websocket connections are closed when a frame times out while being written,
+// so only writes that timed out waiting for a concurrent write are retried.
+func transientWriteError(err error) bool {
+	if errors.Is(err, net.ErrClosed) || errors.Is(err, ErrWriteClosed) {
+		return false
+	}
+
+	var ce websocket.CloseError
+	if errors.As(err, &ce) {
+		return false
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+
+	var ne net.Error
+
+	return errors.As(err, &ne) && ne.Timeout()
+}
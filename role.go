@@ -0,0 +1,136 @@
+package kuromi
+
+import (
+	"context"
+
+	"github.com/coder/websocket"
+)
+
+// AddRole grants the session role, e.g. "admins", so it can be targeted with BroadcastRoles.
+func (s *Session) AddRole(role string) error {
+	s.rwmutex.Lock()
+	defer s.rwmutex.Unlock()
+
+	if !s.open {
+		return ErrSessionClosed
+	}
+
+	if s.roles == nil {
+		s.roles = make(map[string]struct{})
+	}
+	s.roles[role] = struct{}{}
+	s.kuromi.roles.add(role, s)
+
+	return nil
+}
+
+// RemoveRole revokes role from the session.
+func (s *Session) RemoveRole(role string) {
+	s.rwmutex.Lock()
+	delete(s.roles, role)
+	s.rwmutex.Unlock()
+
+	s.kuromi.roles.del(role, s)
+}
+
+// SetRoles replaces the roles of the session with roles.
+func (s *Session) SetRoles(roles ...string) error {
+	if s.closed() {
+		return ErrSessionClosed
+	}
+
+	keep := make(map[string]struct{}, len(roles))
+	for _, role := range roles {
+		keep[role] = struct{}{}
+	}
+
+	for _, role := range s.Roles() {
+		if _, ok := keep[role]; !ok {
+			s.RemoveRole(role)
+		}
+	}
+
+	for role := range keep {
+		if err := s.AddRole(role); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// HasRole reports whether the session has role.
+func (s *Session) HasRole(role string) bool {
+	s.rwmutex.RLock()
+	defer s.rwmutex.RUnlock()
+
+	_, ok := s.roles[role]
+
+	return ok
+}
+
+// Roles returns the roles of the session.
+func (s *Session) Roles() []string {
+	s.rwmutex.RLock()
+	defer s.rwmutex.RUnlock()
+
+	roles := make([]string, 0, len(s.roles))
+	for role := range s.roles {
+		roles = append(roles, role)
+	}
+
+	return roles
+}
+
+// RoleSessions returns the sessions with role.
+func (k *Kuromi) RoleSessions(role string) []*Session {
+	return k.roles.get(role)
+}
+
+// BroadcastRoles broadcasts a text message to all sessions with at least one
+// of roles, e.g. []string{"admins", "moderators"}. Sessions with several of
+// the roles receive the message once. Like BroadcastTag it only visits the
+// sessions with the roles, and role changes apply to the next broadcast.
+func (k *Kuromi) BroadcastRoles(roles []string, msg []byte) error {
+	return k.broadcastRoles(roles, envelope{t: websocket.MessageText, msg: msg})
+}
+
+// BroadcastRolesBinary broadcasts a binary message to all sessions with at least one of roles.
+func (k *Kuromi) BroadcastRolesBinary(roles []string, msg []byte) error {
+	return k.broadcastRoles(roles, envelope{t: websocket.MessageBinary, msg: msg})
+}
+
+func (k *Kuromi) broadcastRoles(roles []string, message envelope) error {
+	if len(roles) == 1 {
+		return k.broadcastIndexed(k.roles, roles[0], message)
+	}
+
+	if k.hub.closed() {
+		return ErrClosed
+	}
+
+	if ok, err := k.admitBroadcast(context.Background(), len(message.msg)); !ok {
+		return err
+	}
+
+	seen := make(map[*Session]struct{})
+
+	for _, role := range roles {
+		for _, s := range k.roles.get(role) {
+			if _, ok := seen[s]; ok {
+				continue
+			}
+
+			seen[s] = struct{}{}
+			s.writeMessage(message)
+		}
+	}
+
+	return nil
+}
+
+func (k *Kuromi) unrole(s *Session) {
+	for _, role := range s.Roles() {
+		k.roles.del(role, s)
+	}
+}
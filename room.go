@@ -0,0 +1,364 @@
+package kuromi
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/coder/websocket"
+)
+
+// Presence describes a session present in a room.
+type Presence struct {
+	SessionID string    `json:"session_id"`
+	UserID    string    `json:"user_id,omitempty"`
+	Meta      any       `json:"meta,omitempty"`
+	JoinedAt  time.Time `json:"joined_at"`
+}
+
+// PresenceDiff describes the sessions that joined and left a room.
+// A session updating its metadata shows up in both Leaves and Joins.
+type PresenceDiff struct {
+	Room   string     `json:"room"`
+	Joins  []Presence `json:"joins"`
+	Leaves []Presence `json:"leaves"`
+}
+
+type roomMember struct {
+	meta     any
+	joinedAt time.Time
+}
+
+type roomRegistry struct {
+	mu    sync.RWMutex
+	rooms map[string]map[*Session]*roomMember
+}
+
+func newRoomRegistry() *roomRegistry {
+	return &roomRegistry{
+		rooms: make(map[string]map[*Session]*roomMember),
+	}
+}
+
+// join adds s to room and returns the previous presence of s if it was already a member.
+func (rr *roomRegistry) join(room string, s *Session, meta any) (Presence, bool, Presence) {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+
+	members, ok := rr.rooms[room]
+	if !ok {
+		members = make(map[*Session]*roomMember)
+		rr.rooms[room] = members
+	}
+
+	old, existed := members[s]
+
+	var prev Presence
+	if existed {
+		prev = presenceOf(s, old)
+	}
+
+	m := &roomMember{meta: meta, joinedAt: s.kuromi.now()}
+	if existed {
+		m.joinedAt = old.joinedAt
+	}
+
+	members[s] = m
+
+	return presenceOf(s, m), existed, prev
+}
+
+func (rr *roomRegistry) leave(room string, s *Session) (Presence, bool) {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+
+	members := rr.rooms[room]
+
+	m, ok := members[s]
+	if !ok {
+		return Presence{}, false
+	}
+
+	delete(members, s)
+
+	if len(members) == 0 {
+		delete(rr.rooms, room)
+	}
+
+	return presenceOf(s, m), true
+}
+
+func (rr *roomRegistry) meta(room string, s *Session) (any, bool) {
+	rr.mu.RLock()
+	defer rr.mu.RUnlock()
+
+	m, ok := rr.rooms[room][s]
+	if !ok {
+		return nil, false
+	}
+
+	return m.meta, true
+}
+
+func (rr *roomRegistry) has(room string, s *Session) bool {
+	rr.mu.RLock()
+	defer rr.mu.RUnlock()
+
+	_, ok := rr.rooms[room][s]
+
+	return ok
+}
+
+func (rr *roomRegistry) sessions(room string) []*Session {
+	rr.mu.RLock()
+	defer rr.mu.RUnlock()
+
+	sessions := make([]*Session, 0, len(rr.rooms[room]))
+	for s := range rr.rooms[room] {
+		sessions = append(sessions, s)
+	}
+
+	return sessions
+}
+
+func (rr *roomRegistry) presence(room string) []Presence {
+	rr.mu.RLock()
+	defer rr.mu.RUnlock()
+
+	presence := make([]Presence, 0, len(rr.rooms[room]))
+	for s, m := range rr.rooms[room] {
+		presence = append(presence, presenceOf(s, m))
+	}
+
+	return presence
+}
+
+func (rr *roomRegistry) names() []string {
+	rr.mu.RLock()
+	defer rr.mu.RUnlock()
+
+	names := make([]string, 0, len(rr.rooms))
+	for name := range rr.rooms {
+		names = append(names, name)
+	}
+
+	return names
+}
+
+func (rr *roomRegistry) len(room string) int {
+	rr.mu.RLock()
+	defer rr.mu.RUnlock()
+
+	return len(rr.rooms[room])
+}
+
+func presenceOf(s *Session, m *roomMember) Presence {
+	return Presence{
+		SessionID: s.ID(),
+		UserID:    s.UserID(),
+		Meta:      m.meta,
+		JoinedAt:  m.joinedAt,
+	}
+}
+
+// Join adds the session to room, see JoinWithMeta.
+func (s *Session) Join(room string) error {
+	return s.JoinWithMeta(room, nil)
+}
+
+// JoinWithMeta adds the session to room with presence metadata, e.g. a
+// display name or status. Joining a room the session is already in updates
+// its metadata.
+//
+// When Config.RoomHistorySize is set the recent messages of the room are
+// replayed to the session when it joins.
+//
+// An error is returned if the Authorizer does not allow the session to join room.
+func (s *Session) JoinWithMeta(room string, meta any) error {
+	return s.join(room, meta, true)
+}
+
+func (s *Session) join(room string, meta any, replay bool) error {
+	if s.closed() {
+		return ErrSessionClosed
+	}
+
+	if err := s.kuromi.authorize(s, room, RoomJoin); err != nil {
+		return err
+	}
+
+	s.rwmutex.Lock()
+	if !s.open {
+		s.rwmutex.Unlock()
+		return ErrSessionClosed
+	}
+	if s.rooms == nil {
+		s.rooms = make(map[string]struct{})
+	}
+	s.rooms[room] = struct{}{}
+	s.rwmutex.Unlock()
+
+	p, existed, prev := s.kuromi.rooms.join(room, s, meta)
+
+	// The registry reads the session under its own lock, so the session cannot
+	// be registered under s.rwmutex. A session closed meanwhile may already
+	// have left its rooms, so it is removed again.
+	if s.closed() {
+		s.kuromi.rooms.leave(room, s)
+
+		if s.kuromi.rooms.len(room) == 0 {
+			s.kuromi.roomMetrics.remove(room)
+		}
+
+		return ErrSessionClosed
+	}
+
+	if replay && !existed {
+		s.kuromi.replayHistory(room, s)
+	}
+
+	if !existed {
+		s.kuromi.emit(WebhookRoomJoin, s, room)
+	}
+
+	diff := PresenceDiff{Room: room, Joins: []Presence{p}}
+	if existed {
+		diff.Leaves = []Presence{prev}
+	}
+
+	s.kuromi.presenceChanged(diff)
+
+	return nil
+}
+
+// Leave removes the session from room.
+func (s *Session) Leave(room string) {
+	s.rwmutex.Lock()
+	delete(s.rooms, room)
+	s.rwmutex.Unlock()
+
+	if p, ok := s.kuromi.rooms.leave(room, s); ok {
+		if s.kuromi.rooms.len(room) == 0 {
+			s.kuromi.roomMetrics.remove(room)
+		}
+
+		s.kuromi.presenceChanged(PresenceDiff{Room: room, Leaves: []Presence{p}})
+		s.kuromi.emit(WebhookRoomLeave, s, room)
+	}
+}
+
+// Rooms returns the rooms the session is in.
+func (s *Session) Rooms() []string {
+	s.rwmutex.RLock()
+	defer s.rwmutex.RUnlock()
+
+	rooms := make([]string, 0, len(s.rooms))
+	for room := range s.rooms {
+		rooms = append(rooms, room)
+	}
+
+	return rooms
+}
+
+// InRoom reports whether the session is in room.
+func (s *Session) InRoom(room string) bool {
+	return s.kuromi.rooms.has(room, s)
+}
+
+// HandlePresence fires fn when sessions join or leave a room.
+func (k *Kuromi) HandlePresence(fn func(PresenceDiff)) {
+	k.presenceHandler = fn
+}
+
+// Presence returns the presence of all sessions in room. In a cluster it
+// returns the presence across all nodes, kept by the owner of the room.
+func (k *Kuromi) Presence(room string) []Presence {
+	if k.clustered() {
+		return k.clusterPresence(room)
+	}
+
+	return k.rooms.presence(room)
+}
+
+// Rooms returns the names of all rooms with at least one session.
+func (k *Kuromi) Rooms() []string {
+	return k.rooms.names()
+}
+
+// RoomSessions returns the sessions in room.
+func (k *Kuromi) RoomSessions(room string) []*Session {
+	return k.rooms.sessions(room)
+}
+
+// BroadcastRoom broadcasts a text message to all sessions in room.
+func (k *Kuromi) BroadcastRoom(room string, msg []byte) error {
+	return k.broadcastRoom(room, envelope{t: websocket.MessageText, msg: msg})
+}
+
+// BroadcastRoomBinary broadcasts a binary message to all sessions in room.
+func (k *Kuromi) BroadcastRoomBinary(room string, msg []byte) error {
+	return k.broadcastRoom(room, envelope{t: websocket.MessageBinary, msg: msg})
+}
+
+func (k *Kuromi) broadcastRoom(room string, message envelope) error {
+	if k.hub.closed() {
+		return ErrClosed
+	}
+
+	if ok, err := k.admitBroadcast(context.Background(), len(message.msg)); !ok {
+		return err
+	}
+
+	k.recordHistory(room, message)
+	k.roomMetrics.broadcast(room, len(message.msg), k.now(), k.rooms.len)
+
+	message.filter = func(s *Session) bool {
+		return k.rooms.has(room, s) && k.authorize(s, room, RoomRead) == nil
+	}
+
+	start := time.Now()
+	message.done = func() {
+		k.roomMetrics.delivered(room, time.Since(start))
+	}
+
+	return k.queueBroadcast(context.Background(), message)
+}
+
+func (k *Kuromi) presenceChanged(diff PresenceDiff) {
+	if k.clustered() {
+		k.announcePresence(diff.Room)
+	}
+
+	if k.presenceHandler != nil {
+		k.presenceHandler(diff)
+	}
+
+	if !k.Config.BroadcastPresence || k.hub.closed() {
+		return
+	}
+
+	msg, err := json.Marshal(struct {
+		Type string `json:"type"`
+		PresenceDiff
+	}{"presence_diff", diff})
+
+	if err != nil {
+		return
+	}
+
+	if ok, _ := k.admitBroadcast(context.Background(), len(msg)); !ok {
+		return
+	}
+
+	for _, s := range k.rooms.sessions(diff.Room) {
+		s.writeMessage(envelope{t: websocket.MessageText, msg: msg})
+	}
+}
+
+// leaveRooms removes a disconnected session from all its rooms.
+func (k *Kuromi) leaveRooms(s *Session) {
+	for _, room := range s.Rooms() {
+		s.Leave(room)
+	}
+}
@@ -0,0 +1,137 @@
+package kuromi
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// roomRateWindow is the window over which RoomMetrics.MessageRate is measured.
+const roomRateWindow = time.Second
+
+// RoomMetrics holds the metrics of a room.
+type RoomMetrics struct {
+	Room             string        `json:"room"`
+	Members          int           `json:"members"`
+	Messages         uint64        `json:"messages"`          // Messages broadcast to the room.
+	Bytes            uint64        `json:"bytes"`             // Bytes broadcast to the room, not multiplied by the members.
+	MessageRate      float64       `json:"message_rate"`      // Messages broadcast per second over the last second.
+	BroadcastLatency time.Duration `json:"broadcast_latency"` // Moving average of the time between a broadcast and the message being queued for all members.
+}
+
+type roomCounter struct {
+	messages    uint64
+	bytes       uint64
+	windowStart time.Time
+	current     uint64
+	previous    uint64
+	latency     time.Duration
+}
+
+// roll moves the rate window forward to now.
+func (c *roomCounter) roll(now time.Time) {
+	elapsed := now.Sub(c.windowStart)
+
+	switch {
+	case elapsed >= 2*roomRateWindow:
+		c.windowStart = now
+		c.previous = 0
+		c.current = 0
+	case elapsed >= roomRateWindow:
+		c.windowStart = c.windowStart.Add(roomRateWindow)
+		c.previous = c.current
+		c.current = 0
+	}
+}
+
+type roomMetrics struct {
+	mu    sync.Mutex
+	rooms map[string]*roomCounter
+}
+
+func newRoomMetrics() *roomMetrics {
+	return &roomMetrics{rooms: make(map[string]*roomCounter)}
+}
+
+// broadcast counts a broadcast of n bytes to room unless it has no members.
+// The members are checked under rm.mu, so a counter created while the last
+// member leaves is removed by Leave.
+func (rm *roomMetrics) broadcast(room string, n int, now time.Time, members func(string) int) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	if members(room) == 0 {
+		return
+	}
+
+	c, ok := rm.rooms[room]
+	if !ok {
+		c = &roomCounter{windowStart: now}
+		rm.rooms[room] = c
+	}
+
+	c.roll(now)
+	c.messages++
+	c.bytes += uint64(n)
+	c.current++
+}
+
+func (rm *roomMetrics) delivered(room string, latency time.Duration) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	c, ok := rm.rooms[room]
+	if !ok {
+		return
+	}
+
+	if c.latency == 0 {
+		c.latency = latency
+	} else {
+		c.latency += (latency - c.latency) / 8
+	}
+}
+
+func (rm *roomMetrics) remove(room string) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	delete(rm.rooms, room)
+}
+
+func (rm *roomMetrics) get(room string, members int, now time.Time) RoomMetrics {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	m := RoomMetrics{Room: room, Members: members}
+
+	if c, ok := rm.rooms[room]; ok {
+		c.roll(now)
+		m.Messages = c.messages
+		m.Bytes = c.bytes
+		m.MessageRate = float64(c.previous) / roomRateWindow.Seconds()
+		m.BroadcastLatency = c.latency
+	}
+
+	return m
+}
+
+// RoomMetrics returns the metrics of all rooms with at least one session,
+// sorted by name. Metrics are reset when the last session leaves a room.
+func (k *Kuromi) RoomMetrics() []RoomMetrics {
+	names := k.rooms.names()
+	sort.Strings(names)
+
+	now := k.now()
+	metrics := make([]RoomMetrics, 0, len(names))
+	for _, room := range names {
+		metrics = append(metrics, k.roomMetrics.get(room, k.rooms.len(room), now))
+	}
+
+	return metrics
+}
+
+// RoomMetricsFor returns the metrics of room.
+func (k *Kuromi) RoomMetricsFor(room string) RoomMetrics {
+	return k.roomMetrics.get(room, k.rooms.len(room), k.now())
+}
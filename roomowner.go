@@ -0,0 +1,328 @@
+package kuromi
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"slices"
+	"strconv"
+)
+
+// ringReplicas is the number of points of a node on the hash ring.
+const ringReplicas = 64
+
+// hashRing assigns rooms to nodes by consistent hashing, so only the rooms of
+// a node joining or leaving the cluster change owner.
+type hashRing struct {
+	points []uint64
+	nodes  map[uint64]string
+}
+
+func newHashRing(nodes []string) *hashRing {
+	r := &hashRing{nodes: make(map[uint64]string, len(nodes)*ringReplicas)}
+
+	for _, node := range nodes {
+		for i := 0; i < ringReplicas; i++ {
+			p := ringHash(node + "#" + strconv.Itoa(i))
+			r.points = append(r.points, p)
+			r.nodes[p] = node
+		}
+	}
+
+	slices.Sort(r.points)
+
+	return r
+}
+
+// owner returns the node owning key.
+func (r *hashRing) owner(key string) string {
+	if len(r.points) == 0 {
+		return ""
+	}
+
+	h := ringHash(key)
+
+	i, _ := slices.BinarySearch(r.points, h)
+	if i == len(r.points) {
+		i = 0
+	}
+
+	return r.nodes[r.points[i]]
+}
+
+func ringHash(key string) uint64 {
+	sum := sha256.Sum256([]byte(key))
+	return binary.BigEndian.Uint64(sum[:8])
+}
+
+// RoomOwner returns the node owning room in a cluster, see Config.Broker. The
+// owner keeps the history and the presence of the room across the cluster.
+// Rooms are assigned to the live nodes by consistent hashing, and are handed
+// off to other nodes when nodes join or leave the cluster. Without a broker
+// the local node owns all rooms.
+func (k *Kuromi) RoomOwner(room string) string {
+	if !k.clustered() {
+		return k.Config.NodeID
+	}
+
+	k.cluster.mu.Lock()
+	defer k.cluster.mu.Unlock()
+
+	return k.cluster.ring.owner(room)
+}
+
+// ownsRoom reports whether the local node owns room.
+func (k *Kuromi) ownsRoom(room string) bool {
+	return k.RoomOwner(room) == k.Config.NodeID
+}
+
+// Nodes returns the live nodes of the cluster, including the local node.
+func (k *Kuromi) Nodes() []string {
+	if !k.clustered() {
+		return nil
+	}
+
+	k.cluster.mu.Lock()
+	defer k.cluster.mu.Unlock()
+
+	nodes := make([]string, 0, len(k.cluster.seen))
+	for node := range k.cluster.seen {
+		nodes = append(nodes, node)
+	}
+
+	slices.Sort(nodes)
+
+	return nodes
+}
+
+// heartbeat announces the node to the cluster and expires silent nodes until the node leaves.
+func (k *Kuromi) heartbeat() {
+	interval := k.Config.ClusterHeartbeat
+
+	ticker := k.clock().NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-k.cluster.quit:
+			return
+		case <-ticker.C():
+			k.publishNode(clusterChannel, nodeMessage{Kind: nodeHeartbeat})
+
+			var expired []string
+
+			k.cluster.mu.Lock()
+			for node, seen := range k.cluster.seen {
+				if node != k.Config.NodeID && k.now().Sub(seen) > 3*interval {
+					expired = append(expired, node)
+				}
+			}
+			k.cluster.mu.Unlock()
+
+			for _, node := range expired {
+				k.nodeGone(node)
+			}
+		}
+	}
+}
+
+// nodeSeen records a heartbeat of node, rebalancing the rooms if it joined.
+func (k *Kuromi) nodeSeen(node string) {
+	c := &k.cluster
+
+	c.mu.Lock()
+	_, known := c.seen[node]
+	c.seen[node] = k.now()
+	if !known {
+		c.ring = newHashRing(keys(c.seen))
+	}
+	c.mu.Unlock()
+
+	if !known {
+		// Let the new node know about this one without waiting for the next heartbeat.
+		k.publishNode(clusterChannel, nodeMessage{Kind: nodeHeartbeat})
+		k.rebalance()
+	}
+}
+
+// nodeGone removes node from the cluster and rebalances the rooms.
+func (k *Kuromi) nodeGone(node string) {
+	c := &k.cluster
+
+	if node == k.Config.NodeID {
+		return
+	}
+
+	c.mu.Lock()
+	_, known := c.seen[node]
+	delete(c.seen, node)
+	if known {
+		c.ring = newHashRing(keys(c.seen))
+	}
+	for room, nodes := range c.presence {
+		delete(nodes, node)
+		if len(nodes) == 0 {
+			delete(c.presence, room)
+		}
+	}
+	c.mu.Unlock()
+
+	if known {
+		k.rebalance()
+	}
+}
+
+// rebalance hands the state of the rooms the node no longer owns off to their
+// owners and announces the presence of its sessions to the owners of their rooms.
+func (k *Kuromi) rebalance() {
+	for _, room := range k.history.names() {
+		if owner := k.RoomOwner(room); owner != k.Config.NodeID {
+			history := k.history.get(room, k.Config.RoomHistoryTTL, k.now())
+			k.history.clear(room)
+
+			m := nodeMessage{Kind: nodeHandoff, Room: room, History: make([]nodeMessage, len(history))}
+			for i, message := range history {
+				m.History[i] = nodeMessage{Type: message.t, Data: message.msg}
+			}
+
+			k.sendNode(owner, m)
+		}
+	}
+
+	k.cluster.mu.Lock()
+	for room := range k.cluster.presence {
+		if k.cluster.ring.owner(room) != k.Config.NodeID {
+			delete(k.cluster.presence, room)
+		}
+	}
+	k.cluster.mu.Unlock()
+
+	for _, room := range k.rooms.names() {
+		k.announcePresence(room)
+	}
+}
+
+// announcePresence sends the presence of the local sessions in room to its owner.
+func (k *Kuromi) announcePresence(room string) {
+	k.sendNode(k.RoomOwner(room), nodeMessage{Kind: nodePresence, Room: room, Presence: k.rooms.presence(room)})
+}
+
+// recordHistory keeps a message broadcast to room in the history of its owner.
+func (k *Kuromi) recordHistory(room string, message envelope) {
+	if k.Config.RoomHistorySize <= 0 {
+		return
+	}
+
+	if k.clustered() {
+		if owner := k.RoomOwner(room); owner != k.Config.NodeID {
+			k.sendNode(owner, nodeMessage{Kind: nodeHistory, Room: room, Type: message.t, Data: message.msg})
+			return
+		}
+	}
+
+	k.history.record(room, message, k.Config.RoomHistorySize, k.Config.RoomHistoryTTL, k.now())
+}
+
+// clusterPresence returns the presence of room across the cluster, asking its
+// owner for it. The local presence is returned if the owner does not answer in time.
+func (k *Kuromi) clusterPresence(room string) []Presence {
+	c := &k.cluster
+
+	owner := k.RoomOwner(room)
+	if owner == k.Config.NodeID {
+		return k.ownedPresence(room)
+	}
+
+	reply := make(chan []Presence, 1)
+
+	c.mu.Lock()
+	c.nextReq++
+	id := c.nextReq
+	c.pending[id] = reply
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+	}()
+
+	if err := k.sendNode(owner, nodeMessage{Kind: nodePresenceQuery, Room: room, Request: id}); err == nil {
+		timer := k.clock().NewTimer(k.Config.ClusterHeartbeat)
+		defer timer.Stop()
+
+		select {
+		case presence := <-reply:
+			return presence
+		case <-timer.C():
+		}
+	}
+
+	return k.rooms.presence(room)
+}
+
+// ownedPresence returns the presence of a room owned by the node.
+func (k *Kuromi) ownedPresence(room string) []Presence {
+	k.cluster.mu.Lock()
+	defer k.cluster.mu.Unlock()
+
+	var presence []Presence
+	for _, p := range k.cluster.presence[room] {
+		presence = append(presence, p...)
+	}
+
+	return presence
+}
+
+// handleRoomMessage handles the room state messages of other nodes.
+func (k *Kuromi) handleRoomMessage(m nodeMessage) {
+	c := &k.cluster
+
+	switch m.Kind {
+	case nodeHistory:
+		k.history.record(m.Room, envelope{t: m.Type, msg: m.Data}, k.Config.RoomHistorySize, k.Config.RoomHistoryTTL, k.now())
+	case nodeHandoff:
+		for _, h := range m.History {
+			k.history.record(m.Room, envelope{t: h.Type, msg: h.Data}, k.Config.RoomHistorySize, k.Config.RoomHistoryTTL, k.now())
+		}
+	case nodeReplay:
+		for _, message := range k.history.get(m.Room, k.Config.RoomHistoryTTL, k.now()) {
+			k.sendToSession(m.Session, message)
+		}
+	case nodePresence:
+		c.mu.Lock()
+		if len(m.Presence) == 0 {
+			delete(c.presence[m.Room], m.From)
+			if len(c.presence[m.Room]) == 0 {
+				delete(c.presence, m.Room)
+			}
+		} else {
+			if c.presence[m.Room] == nil {
+				c.presence[m.Room] = make(map[string][]Presence)
+			}
+			c.presence[m.Room][m.From] = m.Presence
+		}
+		c.mu.Unlock()
+	case nodePresenceQuery:
+		k.sendNode(m.From, nodeMessage{Kind: nodePresenceReply, Request: m.Request, Presence: k.ownedPresence(m.Room)})
+	case nodePresenceReply:
+		c.mu.Lock()
+		reply, ok := c.pending[m.Request]
+		c.mu.Unlock()
+
+		if ok {
+			select {
+			case reply <- m.Presence:
+			default:
+			}
+		}
+	}
+}
+
+func keys[V any](m map[string]V) []string {
+	ks := make([]string, 0, len(m))
+	for k := range m {
+		ks = append(ks, k)
+	}
+
+	return ks
+}
@@ -0,0 +1,91 @@
+package kuromi
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/coder/websocket"
+)
+
+// Route is an endpoint of a kuromi instance with its own set of handlers.
+// Handlers that are not set on the route fall back to the handlers of the
+// kuromi instance. Routes share the hub, config and sessions of the instance.
+type Route struct {
+	handlers
+	path   string
+	kuromi *Kuromi
+}
+
+// Route returns the route for path, creating it if it does not exist yet.
+// Requests for path served through Kuromi.ServeHTTP use the handlers of the route.
+func (k *Kuromi) Route(path string) *Route {
+	k.routesMu.Lock()
+	defer k.routesMu.Unlock()
+
+	if route, ok := k.routes[path]; ok {
+		return route
+	}
+
+	route := &Route{
+		handlers: handlers{parent: &k.handlers},
+		path:     path,
+		kuromi:   k,
+	}
+	k.routes[path] = route
+
+	return route
+}
+
+func (k *Kuromi) lookupRoute(path string) *Route {
+	k.routesMu.RLock()
+	defer k.routesMu.RUnlock()
+
+	return k.routes[path]
+}
+
+// Path returns the path of the route.
+func (rt *Route) Path() string {
+	return rt.path
+}
+
+// ServeHTTP implements http.Handler, upgrading the request to a session of the route.
+func (rt *Route) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rt.kuromi.serveHTTP(w, r, nil, rt)
+}
+
+// HandleRequest upgrades the request to a session of the route.
+func (rt *Route) HandleRequest(w http.ResponseWriter, r *http.Request) error {
+	return rt.kuromi.handleRequest(w, r, nil, rt)
+}
+
+// HandleRequestWithKeys does the same as HandleRequest but populates session.Keys with keys.
+func (rt *Route) HandleRequestWithKeys(w http.ResponseWriter, r *http.Request, keys map[string]any) error {
+	return rt.kuromi.handleRequest(w, r, keys, rt)
+}
+
+// Broadcast broadcasts a text message to all sessions of the route.
+func (rt *Route) Broadcast(msg []byte) error {
+	return rt.kuromi.broadcast(context.Background(), envelope{t: websocket.MessageText, msg: msg, filter: rt.member})
+}
+
+// BroadcastBinary broadcasts a binary message to all sessions of the route.
+func (rt *Route) BroadcastBinary(msg []byte) error {
+	return rt.kuromi.broadcast(context.Background(), envelope{t: websocket.MessageBinary, msg: msg, filter: rt.member})
+}
+
+// Len returns the number of sessions connected to the route.
+func (rt *Route) Len() int {
+	n := 0
+
+	rt.kuromi.hub.sessions.each(func(s *Session) {
+		if rt.member(s) {
+			n++
+		}
+	})
+
+	return n
+}
+
+func (rt *Route) member(s *Session) bool {
+	return s.route == rt
+}
@@ -0,0 +1,112 @@
+package kuromi
+
+import (
+	"container/heap"
+	"sync/atomic"
+	"time"
+
+	"github.com/coder/websocket"
+)
+
+const (
+	schedulePending int32 = iota
+	scheduleFired
+	scheduleCanceled
+)
+
+// ScheduledMessage is a message scheduled with BroadcastAfter or WriteAfter.
+type ScheduledMessage struct {
+	at      time.Time
+	message envelope
+	session *Session // nil for broadcasts
+	state   atomic.Int32
+}
+
+// Cancel prevents the message from being sent. It returns false if the
+// message was already sent or canceled.
+func (sm *ScheduledMessage) Cancel() bool {
+	return sm.state.CompareAndSwap(schedulePending, scheduleCanceled)
+}
+
+// scheduleQueue is a min-heap of scheduled messages ordered by due time.
+type scheduleQueue []*ScheduledMessage
+
+func (q scheduleQueue) Len() int           { return len(q) }
+func (q scheduleQueue) Less(i, j int) bool { return q[i].at.Before(q[j].at) }
+func (q scheduleQueue) Swap(i, j int)      { q[i], q[j] = q[j], q[i] }
+
+func (q *scheduleQueue) Push(x any) {
+	*q = append(*q, x.(*ScheduledMessage))
+}
+
+func (q *scheduleQueue) Pop() any {
+	old := *q
+	n := len(old)
+	sm := old[n-1]
+	old[n-1] = nil
+	*q = old[:n-1]
+
+	return sm
+}
+
+// next returns the time until the first message is due, or false if there is none.
+func (q scheduleQueue) next(now time.Time) (time.Duration, bool) {
+	if len(q) == 0 {
+		return 0, false
+	}
+
+	return q[0].at.Sub(now), true
+}
+
+// due removes and returns the messages due at time now.
+func (q *scheduleQueue) due(now time.Time) []*ScheduledMessage {
+	var due []*ScheduledMessage
+
+	for q.Len() > 0 && !(*q)[0].at.After(now) {
+		due = append(due, heap.Pop(q).(*ScheduledMessage))
+	}
+
+	return due
+}
+
+// BroadcastAfter broadcasts a text message to all sessions after d.
+// The returned ScheduledMessage can be used to cancel the broadcast.
+func (k *Kuromi) BroadcastAfter(d time.Duration, msg []byte) (*ScheduledMessage, error) {
+	return k.schedule(d, envelope{t: websocket.MessageText, msg: msg}, nil)
+}
+
+// BroadcastBinaryAfter broadcasts a binary message to all sessions after d.
+func (k *Kuromi) BroadcastBinaryAfter(d time.Duration, msg []byte) (*ScheduledMessage, error) {
+	return k.schedule(d, envelope{t: websocket.MessageBinary, msg: msg}, nil)
+}
+
+// WriteAfter writes a text message to the session after d.
+// The returned ScheduledMessage can be used to cancel the write.
+func (s *Session) WriteAfter(d time.Duration, msg []byte) (*ScheduledMessage, error) {
+	if s.closed() {
+		return nil, ErrSessionClosed
+	}
+
+	return s.kuromi.schedule(d, envelope{t: websocket.MessageText, msg: msg}, s)
+}
+
+// WriteBinaryAfter writes a binary message to the session after d.
+func (s *Session) WriteBinaryAfter(d time.Duration, msg []byte) (*ScheduledMessage, error) {
+	if s.closed() {
+		return nil, ErrSessionClosed
+	}
+
+	return s.kuromi.schedule(d, envelope{t: websocket.MessageBinary, msg: msg}, s)
+}
+
+func (k *Kuromi) schedule(d time.Duration, message envelope, s *Session) (*ScheduledMessage, error) {
+	if k.hub.closed() {
+		return nil, ErrClosed
+	}
+
+	sm := &ScheduledMessage{at: k.now().Add(d), message: message, session: s}
+
+	k.hub.schedule <- sm
+
+	return sm, nil
+}
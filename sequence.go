@@ -0,0 +1,98 @@
+package kuromi
+
+import (
+	"bytes"
+	"strconv"
+	"sync"
+)
+
+// When Config.SequenceMessages is set every text and binary message written to a
+// session is prefixed with its sequence number in decimal followed by
+// SequenceSeparator, e.g. "42\nhello". Sequence numbers start at 1 and increase
+// by one per message, so a client noticing a gap, e.g. because a message was
+// dropped from a full buffer, can send the text message ResendPrefix followed by
+// the first missing sequence number, e.g. "kuromi.resend:40", to have the
+// messages from that number on written again from the retransmit buffer.
+const (
+	SequenceSeparator = '\n'
+	ResendPrefix      = "kuromi.resend:"
+)
+
+type sequencer struct {
+	mu   sync.Mutex
+	last uint64
+	sent ring
+}
+
+// stamp assigns the next sequence number to message and keeps it for retransmission.
+func (sq *sequencer) stamp(message envelope, size int) envelope {
+	sq.last++
+	message.seq = sq.last
+
+	if size > 0 {
+		sq.sent.push(message, size)
+	}
+
+	return message
+}
+
+// since returns the kept messages with a sequence number of at least seq and
+// whether all messages from seq on were kept.
+func (sq *sequencer) since(seq uint64) ([]envelope, bool) {
+	sq.mu.Lock()
+	defer sq.mu.Unlock()
+
+	var msgs []envelope
+
+	for _, message := range sq.sent.all() {
+		if message.seq >= seq {
+			msgs = append(msgs, message)
+		}
+	}
+
+	complete := seq > sq.last || (len(msgs) > 0 && msgs[0].seq == seq)
+
+	return msgs, complete
+}
+
+func frameSequence(seq uint64, msg []byte) []byte {
+	b := strconv.AppendUint(make([]byte, 0, 21+len(msg)), seq, 10)
+	b = append(b, SequenceSeparator)
+
+	return append(b, msg...)
+}
+
+// Seq returns the sequence number of the last message written to the session,
+// see Config.SequenceMessages.
+func (s *Session) Seq() uint64 {
+	s.sequencer.mu.Lock()
+	defer s.sequencer.mu.Unlock()
+
+	return s.sequencer.last
+}
+
+// handleResend writes the messages requested by a resend request again and
+// reports whether message was a resend request.
+func (s *Session) handleResend(message []byte) bool {
+	if !bytes.HasPrefix(message, []byte(ResendPrefix)) {
+		return false
+	}
+
+	seq, err := strconv.ParseUint(string(message[len(ResendPrefix):]), 10, 64)
+	if err != nil {
+		s.handlers.onError(s, ErrInvalidResend)
+		return true
+	}
+
+	msgs, complete := s.sequencer.since(seq)
+
+	for _, m := range msgs {
+		s.queue(m)
+	}
+
+	if !complete {
+		s.handlers.onError(s, ErrResendUnavailable)
+	}
+
+	return true
+}
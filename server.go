@@ -0,0 +1,56 @@
+package kuromi
+
+import (
+	"net"
+	"net/http"
+	"time"
+)
+
+// Timeouts of the servers created by ListenAndServe, ListenAndServeTLS and Serve.
+// Read and write timeouts are not set, they would close long lived sessions.
+const (
+	serverReadHeaderTimeout = 10 * time.Second
+	serverIdleTimeout       = 2 * time.Minute
+)
+
+// ListenAndServe listens on the TCP network address addr and serves k on path,
+// for services that are nothing but a websocket endpoint. Other paths respond
+// with 404. The server gets header read and idle timeouts and is attached to
+// k with AttachToServer. Like http.ListenAndServe it always returns a non-nil error.
+func ListenAndServe(addr, path string, k *Kuromi) error {
+	return newServer(addr, path, k).ListenAndServe()
+}
+
+// ListenAndServeTLS acts identically to ListenAndServe, except that it expects
+// HTTPS connections using the certificate and key in certFile and keyFile.
+func ListenAndServeTLS(addr, path, certFile, keyFile string, k *Kuromi) error {
+	return newServer(addr, path, k).ListenAndServeTLS(certFile, keyFile)
+}
+
+// Serve accepts connections on l and serves k on all paths, e.g. for systemd
+// socket activation or in-process listeners in tests. The server is set up like
+// the one of ListenAndServe. Serve always returns a non-nil error and closes l.
+func (k *Kuromi) Serve(l net.Listener) error {
+	return newServer("", "/", k).Serve(l)
+}
+
+// newServer returns an http.Server serving k on path.
+func newServer(addr, path string, k *Kuromi) *http.Server {
+	if path == "" {
+		path = "/"
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(path, k)
+
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: serverReadHeaderTimeout,
+		IdleTimeout:       serverIdleTimeout,
+	}
+
+	k.AttachToServer(srv)
+
+	return srv
+}
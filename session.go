@@ -2,8 +2,13 @@ package kuromi
 
 import (
 	"context"
+	"errors"
+	"math"
 	"net/http"
+	"runtime/debug"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/coder/websocket"
@@ -11,27 +16,125 @@ import (
 
 // Session wrapper around websocket connections.
 type Session struct {
-	Request    *http.Request
-	Keys       map[string]any
-	conn       *websocket.Conn
-	output     chan envelope
-	outputDone chan struct{}
-	kuromi     *Kuromi
-	open       bool
-	rwmutex    *sync.RWMutex
+	id            uint64
+	Request       *http.Request
+	Keys          map[string]any // Use Set, Get and KeysSnapshot when other goroutines may access the session.
+	conn          Transport
+	output        chan envelope // Interactive messages, see Priority.
+	control       chan envelope
+	bulk          chan envelope // Nil unless Config.BulkBufferSize is set.
+	outputDone    chan struct{}
+	kuromi        *Kuromi
+	handlers      *handlers
+	route         *Route
+	namespace     *Namespace
+	open          bool
+	rwmutex       *sync.RWMutex
+	user          string
+	rooms         map[string]struct{}
+	tags          map[string]struct{}
+	roles         map[string]struct{}
+	subscriptions map[string]struct{}
+	resumeToken   string
+	resumed       bool
+	sequencer     sequencer
+	connectedAt   time.Time
+	readLimit     atomic.Int64
+	subprotocol   string
+	version       string
+	flood         floodGuard
+	recorder      *recorder
+	traffic       trafficCounter
+	latency       atomic.Int64
+	slow          slowConsumer
+	tripped       atomic.Bool
+	indexing      bool
+	indexed       map[string]any
+	keyTimers     map[string]*keyTimer
+	data          any
+	closeCode     StatusCode
+	closeReason   string
+	cookieID      string
+	transforms    []SessionTransform
+	pending       atomic.Int64
+	draining      atomic.Bool
+	watchdog      Timer
+	egress        egressLimit
+	correlationID string
+	messages      atomic.Uint64
 }
 
-func (s *Session) writeMessage(message envelope) {
+// flushInterval is how often Flush checks whether the output queue is empty.
+const flushInterval = 10 * time.Millisecond
+
+func (s *Session) writeMessage(message envelope) DeliveryStatus {
+	if s.tripped.Load() {
+		s.deadLetter(message, ErrCircuitOpen)
+		return DroppedSessionClosed
+	}
+
 	if s.closed() {
-		s.kuromi.errorHandler(s, ErrWriteClosed)
-		return
+		s.handlers.onError(s, ErrWriteClosed)
+		s.deadLetter(message, ErrWriteClosed)
+		return DroppedSessionClosed
 	}
 
+	if s.draining.Load() && message.t != CloseMessage {
+		s.handlers.onError(s, ErrSessionDraining)
+		s.deadLetter(message, ErrSessionDraining)
+		return DroppedSessionClosed
+	}
+
+	if s.kuromi.Config.MessageTTL > 0 && message.expires.IsZero() && message.t != CloseMessage {
+		message.expires = s.kuromi.now().Add(s.kuromi.Config.MessageTTL)
+	}
+
+	if s.kuromi.Config.SequenceMessages && message.t != CloseMessage {
+		// Stamp and enqueue under the lock so messages are queued in sequence
+		// order, but run the handlers after releasing it as they may write to
+		// the session again.
+		s.sequencer.mu.Lock()
+		message = s.sequencer.stamp(message, s.kuromi.Config.RetransmitBufferSize)
+		ok := s.enqueue(message)
+		s.sequencer.mu.Unlock()
+
+		return s.queued(message, ok)
+	}
+
+	return s.queue(message)
+}
+
+// queue puts message in the output queue of the session.
+func (s *Session) queue(message envelope) DeliveryStatus {
+	return s.queued(message, s.enqueue(message))
+}
+
+// enqueue puts message in the output queue of the session without blocking and
+// reports whether there was room for it.
+func (s *Session) enqueue(message envelope) bool {
+	s.pending.Add(1)
+
 	select {
-	case s.output <- message:
+	case s.lane(message.priority) <- message:
+		return true
 	default:
-		s.kuromi.errorHandler(s, ErrMessageBufferFull)
+		s.pending.Add(-1)
+		return false
+	}
+}
+
+// queued runs the handlers for message after enqueue reported ok and returns
+// its delivery status.
+func (s *Session) queued(message envelope, ok bool) DeliveryStatus {
+	if ok {
+		s.checkSlowConsumer()
+		return Delivered
 	}
+
+	s.handlers.onError(s, ErrMessageBufferFull)
+	s.deadLetter(message, ErrMessageBufferFull)
+
+	return DroppedBufferFull
 }
 
 func (s *Session) writeRaw(message envelope) error {
@@ -39,14 +142,36 @@ func (s *Session) writeRaw(message envelope) error {
 		return ErrWriteClosed
 	}
 
+	msg := message.msg
+	if len(message.header) > 0 && s.kuromi.Config.MessageHeaders {
+		msg = frameHeader(message.header, msg)
+	}
+	if message.ackID != "" {
+		msg = frameAckRequest(message.ackID, msg)
+	}
+	if message.seq > 0 {
+		msg = frameSequence(message.seq, msg)
+	}
+
+	t := message.t
+	if len(s.transforms) > 0 {
+		var err error
+		if t, msg, err = s.transformOutbound(t, msg); err != nil {
+			return err
+		}
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), s.kuromi.Config.WriteWait)
 	defer cancel()
-	err := s.conn.Write(ctx, message.t, message.msg)
+	defer s.watch()()
+	err := s.conn.Write(ctx, t, msg)
 
 	if err != nil {
 		return err
 	}
 
+	s.countOut(len(msg))
+
 	return nil
 }
 
@@ -65,111 +190,289 @@ func (s *Session) closeWithMsg(code websocket.StatusCode, reason string) {
 	s.rwmutex.Lock()
 	open := s.open
 	s.open = false
+	if open && s.closeCode == 0 {
+		s.closeCode, s.closeReason = code, reason
+	}
 	s.rwmutex.Unlock()
 	if open {
 		s.conn.Close(code, reason)
 		close(s.outputDone)
-		if s.kuromi.closeHandler != nil {
-			s.kuromi.closeHandler(s, int(code), reason)
-		}
+		s.handlers.onClose(s, int(code), reason)
 	}
 }
 
-func (s *Session) ping() {
+func (s *Session) ping() error {
 	ctx, cancel := context.WithTimeout(context.Background(), s.kuromi.Config.WriteWait)
 	defer cancel()
+
+	start := time.Now()
+
+	disarm := s.watch()
 	err := s.conn.Ping(ctx)
-	if err != nil && s.kuromi.pongHandler != nil {
-		s.kuromi.pongHandler(s)
+	disarm()
+
+	if err != nil {
+		return err
+	}
+
+	rtt := time.Since(start)
+	s.latency.Store(int64(rtt))
+	s.protect(func() { s.handlers.onLatency(s, rtt) })
+
+	if fn := s.kuromi.Config.PingPayload; fn != nil {
+		return s.writePingPayload(fn(s))
 	}
+
+	return nil
 }
 
 func (s *Session) writePump() {
-	ticker := time.NewTicker(s.kuromi.Config.PingPeriod)
+	ticker := s.kuromi.clock().NewTicker(s.kuromi.Config.PingPeriod)
 	defer ticker.Stop()
 
+	failures, pingFailures := 0, 0
+
 loop:
 	for {
-		select {
-		case msg := <-s.output:
-			if msg.t == CloseMessage {
-				s.closeWithMsg(msg.code, string(msg.msg))
-				return
+		msg, ok := s.dequeue()
+
+		if !ok {
+			select {
+			case msg = <-s.control:
+			case msg = <-s.output:
+			case msg = <-s.bulk:
+			case <-ticker.C():
+				s.checkSlowConsumer()
+
+				if err := s.ping(); err != nil && !s.closed() {
+					pingFailures++
+					s.protect(func() { s.handlers.onPingFailure(s, err) })
+
+					if pingFailures >= s.kuromi.Config.MaxPingFailures {
+						s.closeWithMsg(StatusGoingAway, ErrPingFailed.Error())
+						return
+					}
+
+					continue
+				}
+
+				pingFailures = 0
+
+				// Pongs echoing a ping payload are reported by handlePong.
+				if s.kuromi.Config.PingPayload == nil {
+					s.protect(func() { s.handlers.onPong(s, nil) })
+				}
+
+				continue
+			case _, ok := <-s.outputDone:
+				if !ok {
+					break loop
+				}
+
+				continue
 			}
+		}
 
-			err := s.writeRaw(msg)
+		if msg.t == CloseMessage {
+			s.pending.Add(-1)
+			s.closeWithMsg(msg.code, string(msg.msg))
+			return
+		}
 
-			if err != nil {
-				s.kuromi.errorHandler(s, err)
-				break loop
-			}
+		if msg.queued != nil && !msg.queued.claim() {
+			s.pending.Add(-1)
+			continue
+		}
 
-			if msg.t == websocket.MessageText {
-				s.kuromi.messageSentHandler(s, msg.msg)
-			}
+		if !s.throttle(len(msg.msg)) {
+			s.pending.Add(-1)
+			s.deadLetter(msg, ErrWriteClosed)
+			break loop
+		}
 
-			if msg.t == websocket.MessageBinary {
-				s.kuromi.messageSentHandlerBinary(s, msg.msg)
-			}
-		case <-ticker.C:
-			s.ping()
-		case _, ok := <-s.outputDone:
-			if !ok {
+		if msg.expired(s.kuromi.now()) {
+			s.pending.Add(-1)
+			s.deadLetter(msg, ErrMessageExpired)
+			continue
+		}
+
+		err := s.writeWithRetry(msg)
+		s.pending.Add(-1)
+
+		if err != nil {
+			s.handlers.onError(s, err)
+			s.handlers.onSendError(s, msg.msg, err)
+			s.deadLetter(msg, err)
+
+			failures++
+			if failures >= s.kuromi.Config.MaxWriteFailures {
+				// Trip the breaker: later messages are dropped without calling the error handler.
+				s.tripped.Store(true)
 				break loop
 			}
+
+			continue
 		}
+
+		failures = 0
+
+		s.handlers.onSent(s, msg.t, msg.msg)
+		s.handlers.onSentMessage(s, SentMessage{Type: msg.t, Msg: msg.msg, Header: msg.header})
 	}
 
 	s.close()
 }
 
 func (s *Session) readPump() {
-	s.conn.SetReadLimit(s.kuromi.Config.MaxMessageSize)
+	if s.readLimit.Load() == 0 {
+		s.SetReadLimit(s.kuromi.Config.MaxMessageSize)
+	}
 
 	for {
 		// TODO: add timeout ref: readdeadline
 		t, message, err := s.conn.Read(context.Background())
 
 		if err != nil {
-			s.kuromi.errorHandler(s, err)
+			s.disconnected(err)
+			s.handlers.onError(s, err)
 			break
 		}
 
-		if s.kuromi.Config.ConcurrentMessageHandling {
-			go s.handleMessage(t, message)
+		s.countIn(len(message))
+
+		if len(s.transforms) > 0 {
+			raw := message
+			if t, message, err = s.transformInbound(t, message); err != nil {
+				s.handlers.onError(s, err)
+				s.handlers.onVerifyFailure(s, raw, err)
+				s.recordFault()
+				continue
+			}
+		}
+
+		s.record(t, message)
+
+		if s.checkFlood() {
+			continue
+		}
+
+		if t == websocket.MessageText && s.handleControl(message) {
+			continue
+		}
+
+		var header Header
+		if s.kuromi.Config.MessageHeaders {
+			header, message = parseHeader(message)
+		}
+
+		if !s.validateMessage(t, message) {
+			continue
+		}
+
+		if !s.kuromi.Config.ConcurrentMessageHandling {
+			s.handleMessage(t, message, header)
+		} else if s.kuromi.Config.MessageHandlerWorkers > 0 {
+			if !s.kuromi.dispatch(s, t, message, header) {
+				s.handlers.onError(s, ErrHandlerQueueFull)
+			}
 		} else {
-			s.handleMessage(t, message)
+			go s.handleMessage(t, message, header)
 		}
 	}
 }
 
-func (s *Session) handleMessage(t websocket.MessageType, message []byte) {
-	switch t {
-	case websocket.MessageText:
-		s.kuromi.messageHandler(s, message)
-	case websocket.MessageBinary:
-		s.kuromi.messageHandlerBinary(s, message)
+// handleControl handles the protocol messages sent by clients, e.g. resend
+// requests, and reports whether message was one.
+func (s *Session) handleControl(message []byte) bool {
+	if s.kuromi.Config.SequenceMessages && s.handleResend(message) {
+		return true
 	}
+
+	return s.handlePong(message) || s.handleAck(message) || s.handleReceipt(message)
 }
 
-// Write writes message to session.
-func (s *Session) Write(msg []byte) error {
-	if s.closed() {
-		return ErrSessionClosed
+func (s *Session) handleMessage(t websocket.MessageType, message []byte, header Header) {
+	if t != websocket.MessageText && t != websocket.MessageBinary {
+		return
 	}
 
-	s.writeMessage(envelope{t: websocket.MessageText, msg: msg})
+	timeout := s.kuromi.Config.MessageHandlerTimeout
 
-	return nil
+	if timeout <= 0 {
+		s.protect(func() { s.handleError(s.processMessage(s.messageContext(header), t, message)) })
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(s.messageContext(header), timeout)
+	defer cancel()
+
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		s.protect(func() { s.handleError(s.processMessage(ctx, t, message)) })
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		s.handlers.onError(s, ErrHandlerTimeout)
+
+		if s.kuromi.Config.CloseOnHandlerTimeout {
+			s.CloseWithMsg(websocket.StatusInternalError, ErrHandlerTimeout.Error())
+		}
+	}
+}
+
+// handleError passes a non-nil error returned by a handler to the error handler
+// and closes the session if it carries a close status.
+func (s *Session) handleError(err error) {
+	if err == nil {
+		return
+	}
+
+	s.handlers.onError(s, err)
+	s.recordFault()
+
+	var se *StatusError
+	if errors.As(err, &se) {
+		s.CloseWithMsg(se.Code, se.Reason)
+	}
+}
+
+// protect runs fn and recovers a panic raised by it, passing a *PanicError
+// to the error handler and closing the session.
+func (s *Session) protect(fn func()) {
+	defer func() {
+		if v := recover(); v != nil {
+			s.handlers.onError(s, &PanicError{Value: v, Stack: debug.Stack()})
+			s.CloseWithMsg(websocket.StatusInternalError, "")
+		}
+	}()
+
+	fn()
+}
+
+// Write writes message to session.
+func (s *Session) Write(msg []byte) error {
+	return s.write(envelope{t: websocket.MessageText, msg: msg})
 }
 
 // WriteBinary writes a binary message to session.
 func (s *Session) WriteBinary(msg []byte) error {
+	return s.write(envelope{t: websocket.MessageBinary, msg: msg})
+}
+
+func (s *Session) write(message envelope) error {
 	if s.closed() {
 		return ErrSessionClosed
 	}
 
-	s.writeMessage(envelope{t: websocket.MessageBinary, msg: msg})
+	if s.draining.Load() {
+		return ErrSessionDraining
+	}
+
+	s.writeMessage(message)
 
 	return nil
 }
@@ -197,17 +500,63 @@ func (s *Session) CloseWithMsg(code websocket.StatusCode, reason string) error {
 	return nil
 }
 
+// Flush blocks until all queued messages have been sent to the session.
+// It returns ErrSessionClosed if the session is closed before the queue is empty
+// and ctx.Err() if ctx is done first.
+func (s *Session) Flush(ctx context.Context) error {
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	for s.pending.Load() > 0 {
+		if s.closed() {
+			return ErrSessionClosed
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+
+	return nil
+}
+
+// Drain stops the session from accepting new messages, waits for the queued
+// messages to be sent and then closes the session. The session is closed even
+// if ctx is done before the queue is flushed, in which case ctx.Err() is returned.
+func (s *Session) Drain(ctx context.Context) error {
+	if s.closed() {
+		return ErrSessionClosed
+	}
+
+	s.draining.Store(true)
+
+	err := s.Flush(ctx)
+
+	s.close()
+
+	return err
+}
+
 // Set is used to store a new key/value pair exclusively for this session.
 // It also lazy initializes s.Keys if it was not used previously.
 func (s *Session) Set(key string, value any) {
 	s.rwmutex.Lock()
 	defer s.rwmutex.Unlock()
 
+	s.set(key, value)
+}
+
+// set stores key, replacing any expiring value, s.rwmutex must be held.
+func (s *Session) set(key string, value any) {
 	if s.Keys == nil {
 		s.Keys = make(map[string]any)
 	}
 
 	s.Keys[key] = value
+	s.stopKeyTimer(key)
+	s.indexKey(key, value)
 }
 
 // Get returns the value for the given key, ie: (value, true).
@@ -232,6 +581,82 @@ func (s *Session) MustGet(key string) any {
 	panic("Key \"" + key + "\" does not exist")
 }
 
+// KeysSnapshot returns a copy of Keys taken under the session lock, which
+// can be iterated while other goroutines call Set or UnSet.
+func (s *Session) KeysSnapshot() map[string]any {
+	s.rwmutex.RLock()
+	defer s.rwmutex.RUnlock()
+
+	keys := make(map[string]any, len(s.Keys))
+	for key, value := range s.Keys {
+		keys[key] = value
+	}
+
+	return keys
+}
+
+// GetString returns the string value for the given key, ie: (value, true).
+// If the value does not exist or is not a string it returns ("", false).
+func (s *Session) GetString(key string) (string, bool) {
+	value, _ := s.Get(key)
+	v, ok := value.(string)
+	return v, ok
+}
+
+// GetInt64 returns the integer value for the given key as an int64, ie: (value, true).
+// Values of any integer type are converted if they fit in an int64.
+// If the value does not exist or is not an integer it returns (0, false).
+func (s *Session) GetInt64(key string) (int64, bool) {
+	value, _ := s.Get(key)
+
+	switch v := value.(type) {
+	case int:
+		return int64(v), true
+	case int8:
+		return int64(v), true
+	case int16:
+		return int64(v), true
+	case int32:
+		return int64(v), true
+	case int64:
+		return v, true
+	case uint:
+		if uint64(v) > math.MaxInt64 {
+			return 0, false
+		}
+		return int64(v), true
+	case uint8:
+		return int64(v), true
+	case uint16:
+		return int64(v), true
+	case uint32:
+		return int64(v), true
+	case uint64:
+		if v > math.MaxInt64 {
+			return 0, false
+		}
+		return int64(v), true
+	}
+
+	return 0, false
+}
+
+// GetBool returns the bool value for the given key, ie: (value, true).
+// If the value does not exist or is not a bool it returns (false, false).
+func (s *Session) GetBool(key string) (bool, bool) {
+	value, _ := s.Get(key)
+	v, ok := value.(bool)
+	return v, ok
+}
+
+// GetTime returns the time.Time value for the given key, ie: (value, true).
+// If the value does not exist or is not a time.Time it returns (time.Time{}, false).
+func (s *Session) GetTime(key string) (time.Time, bool) {
+	value, _ := s.Get(key)
+	v, ok := value.(time.Time)
+	return v, ok
+}
+
 // UnSet will delete the key and has no return value
 func (s *Session) UnSet(key string) {
 	s.rwmutex.Lock()
@@ -239,6 +664,86 @@ func (s *Session) UnSet(key string) {
 	if s.Keys != nil {
 		delete(s.Keys, key)
 	}
+	s.stopKeyTimer(key)
+	s.unindexKey(key)
+}
+
+// ID returns the identifier of the session, unique within the kuromi instance.
+// With Config.NodeID set it is prefixed with the node, e.g. "node-1:42", making
+// it unique within the cluster, see Kuromi.SendToSession.
+func (s *Session) ID() string {
+	if node := s.kuromi.Config.NodeID; node != "" {
+		return node + ":" + strconv.FormatUint(s.id, 10)
+	}
+
+	return strconv.FormatUint(s.id, 10)
+}
+
+// SetReadLimit sets the maximum size in bytes of messages read from the session,
+// overriding Config.MaxMessageSize, e.g. to accept a large upload after the session
+// authenticated. Messages exceeding the limit close the session.
+func (s *Session) SetReadLimit(n int64) {
+	s.readLimit.Store(n)
+	s.conn.SetReadLimit(n)
+}
+
+// ResetReadLimit restores the read limit of the session to Config.MaxMessageSize.
+func (s *Session) ResetReadLimit() {
+	s.SetReadLimit(s.kuromi.Config.MaxMessageSize)
+}
+
+// ReadLimit returns the maximum size in bytes of messages read from the session.
+func (s *Session) ReadLimit() int64 {
+	if n := s.readLimit.Load(); n != 0 {
+		return n
+	}
+
+	return s.kuromi.Config.MaxMessageSize
+}
+
+// ConnectedAt returns the time the session connected.
+func (s *Session) ConnectedAt() time.Time {
+	return s.connectedAt
+}
+
+// DisconnectStatus returns the close code and reason of a closed session. The
+// code is the one sent by the session when it closed the connection, the one
+// sent to it when kuromi closed it, or StatusAbnormalClosure if the connection
+// failed without a close frame, e.g. because of a network failure.
+// It returns (0, "") while the session is open.
+func (s *Session) DisconnectStatus() (StatusCode, string) {
+	s.rwmutex.RLock()
+	defer s.rwmutex.RUnlock()
+
+	return s.closeCode, s.closeReason
+}
+
+// disconnected records the close status of a session whose read failed with err.
+func (s *Session) disconnected(err error) {
+	s.rwmutex.Lock()
+	defer s.rwmutex.Unlock()
+
+	if !s.open || s.closeCode != 0 {
+		return
+	}
+
+	var ce CloseError
+	if errors.As(err, &ce) {
+		s.closeCode, s.closeReason = ce.Code, ce.Reason
+	} else {
+		s.closeCode = StatusAbnormalClosure
+	}
+}
+
+// Latency returns the round-trip time of the last ping answered by the session,
+// or 0 if no ping has been answered yet.
+func (s *Session) Latency() time.Duration {
+	return time.Duration(s.latency.Load())
+}
+
+// Pending returns the number of messages queued for the session that have not been sent yet.
+func (s *Session) Pending() int {
+	return int(s.pending.Load())
 }
 
 // IsClosed returns the status of the connection.
@@ -246,8 +751,29 @@ func (s *Session) IsClosed() bool {
 	return s.closed()
 }
 
+// Route returns the route the session connected through, or nil if it was not served by a route.
+func (s *Session) Route() *Route {
+	return s.route
+}
+
+// Namespace returns the namespace the session selected when connecting, or nil if it did not select one.
+func (s *Session) Namespace() *Namespace {
+	return s.namespace
+}
+
 // WebsocketConnection returns the underlying websocket connection.
 // This can be used to e.g. set/read additional websocket options or to write sychronous messages.
+// It returns nil for sessions served over the Server-Sent Events fallback.
 func (s *Session) WebsocketConnection() *websocket.Conn {
-	return s.conn
+	c, _ := unwrapTransport(s.conn).(*websocket.Conn)
+	return c
+}
+
+// Transport returns the name of the transport of the session, "websocket" or "sse".
+func (s *Session) Transport() string {
+	if _, ok := unwrapTransport(s.conn).(*sseTransport); ok {
+		return "sse"
+	}
+
+	return "websocket"
 }
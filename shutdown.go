@@ -0,0 +1,89 @@
+package kuromi
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// shutdownPollInterval is how often Shutdown checks whether all sessions disconnected.
+const shutdownPollInterval = 10 * time.Millisecond
+
+type shutdownState struct {
+	once sync.Once
+	done chan struct{}
+	err  error
+}
+
+// Shutdown gracefully shuts the kuromi instance down. It stops accepting new
+// sessions, lets every session write its queued messages, closes it with
+// StatusGoingAway and waits for it to disconnect before closing the instance.
+// Sessions still connected when ctx is done are closed right away and
+// ctx.Err() is returned.
+//
+// Calling Shutdown again waits for the first call to complete, or for ctx.
+func (k *Kuromi) Shutdown(ctx context.Context) error {
+	k.shutdown.once.Do(func() {
+		k.shutdown.done = make(chan struct{})
+		k.shuttingDown.Store(true)
+
+		go func() {
+			k.shutdown.err = k.drainAll(ctx)
+			close(k.shutdown.done)
+		}()
+	})
+
+	select {
+	case <-k.shutdown.done:
+		return k.shutdown.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (k *Kuromi) drainAll(ctx context.Context) error {
+	sessions, err := k.Sessions()
+	if err != nil {
+		return err
+	}
+
+	for _, s := range sessions {
+		go func() {
+			s.draining.Store(true)
+			s.Flush(ctx)
+			s.CloseWithMsg(StatusGoingAway, "server shutting down")
+		}()
+	}
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+
+	for k.Len() > 0 {
+		select {
+		case <-ctx.Done():
+			k.CloseWithMsg(StatusGoingAway, "server shutting down")
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+
+	return k.CloseWithMsg(StatusGoingAway, "server shutting down")
+}
+
+// AttachToServer makes srv.Shutdown shut the kuromi instance down gracefully,
+// waiting at most Config.ShutdownTimeout for the sessions to disconnect.
+//
+// srv.Shutdown does not wait for websocket connections, which are hijacked,
+// so call Shutdown after it returns to wait for the sessions to be drained:
+//
+//	srv.Shutdown(ctx)
+//	k.Shutdown(ctx)
+func (k *Kuromi) AttachToServer(srv *http.Server) {
+	srv.RegisterOnShutdown(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), k.Config.ShutdownTimeout)
+		defer cancel()
+
+		k.Shutdown(ctx)
+	})
+}
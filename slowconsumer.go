@@ -0,0 +1,58 @@
+package kuromi
+
+import (
+	"sync"
+	"time"
+)
+
+// SlowConsumerStats describes a session whose output queue stayed above
+// Config.SlowConsumerHighWater for longer than Config.SlowConsumerThreshold.
+type SlowConsumerStats struct {
+	Pending  int           // Messages queued for the session.
+	Duration time.Duration // How long the queue has been above the high-water mark.
+	Stats    Stats         // Traffic of the session since it connected.
+}
+
+type slowConsumer struct {
+	mu    sync.Mutex
+	since time.Time
+	fired bool
+}
+
+// checkSlowConsumer fires the slow consumer handler once each time the output
+// queue of the session stays above the high-water mark for too long.
+func (s *Session) checkSlowConsumer() {
+	highWater := s.kuromi.Config.SlowConsumerHighWater
+	if highWater <= 0 {
+		return
+	}
+
+	pending := s.Pending()
+	now := s.kuromi.now()
+
+	s.slow.mu.Lock()
+
+	if pending < highWater {
+		s.slow.since = time.Time{}
+		s.slow.fired = false
+		s.slow.mu.Unlock()
+		return
+	}
+
+	if s.slow.since.IsZero() {
+		s.slow.since = now
+	}
+
+	d := now.Sub(s.slow.since)
+	fire := !s.slow.fired && d >= s.kuromi.Config.SlowConsumerThreshold
+	if fire {
+		s.slow.fired = true
+	}
+
+	s.slow.mu.Unlock()
+
+	if fire {
+		stats := SlowConsumerStats{Pending: pending, Duration: d, Stats: s.Stats()}
+		go s.protect(func() { s.handlers.onSlowConsumer(s, stats) })
+	}
+}
@@ -0,0 +1,141 @@
+package socketio
+
+import (
+	"encoding/json"
+	"sync"
+)
+
+// EventHandler handles an event sent by a client. ack is nil if the client
+// did not request an acknowledgement.
+type EventHandler func(s *Socket, args []json.RawMessage, ack AckFunc)
+
+// AckFunc acknowledges an event with args.
+type AckFunc func(args ...any) error
+
+// Namespace is a Socket.IO namespace with its own event handlers and sockets.
+type Namespace struct {
+	name   string
+	server *Server
+
+	mu           sync.RWMutex
+	events       map[string]EventHandler
+	sockets      map[*Socket]struct{}
+	onConnect    func(*Socket) error
+	onDisconnect func(*Socket, string)
+}
+
+// Name returns the name of the namespace.
+func (ns *Namespace) Name() string {
+	return ns.name
+}
+
+// OnConnect fires fn when a client connects to the namespace, after the client
+// received the CONNECT packet. If fn returns an error the socket is removed
+// from the namespace and the client receives it as a connect error.
+func (ns *Namespace) OnConnect(fn func(*Socket) error) {
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+
+	ns.onConnect = fn
+}
+
+// OnDisconnect fires fn with the disconnect reason when a client leaves the namespace.
+func (ns *Namespace) OnDisconnect(fn func(*Socket, string)) {
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+
+	ns.onDisconnect = fn
+}
+
+// On fires fn when a client emits event in the namespace.
+func (ns *Namespace) On(event string, fn EventHandler) {
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+
+	ns.events[event] = fn
+}
+
+// Emit emits event with args to all sockets in the namespace.
+func (ns *Namespace) Emit(event string, args ...any) error {
+	data, err := encodeEvent(event, args)
+	if err != nil {
+		return err
+	}
+
+	for _, socket := range ns.Sockets() {
+		socket.conn.writePacket(packetEvent, ns.name, -1, data)
+	}
+
+	return nil
+}
+
+// Sockets returns all sockets connected to the namespace.
+func (ns *Namespace) Sockets() []*Socket {
+	ns.mu.RLock()
+	defer ns.mu.RUnlock()
+
+	sockets := make([]*Socket, 0, len(ns.sockets))
+	for socket := range ns.sockets {
+		sockets = append(sockets, socket)
+	}
+
+	return sockets
+}
+
+func (ns *Namespace) join(s *Socket) error {
+	ns.mu.Lock()
+	ns.sockets[s] = struct{}{}
+	onConnect := ns.onConnect
+	ns.mu.Unlock()
+
+	if onConnect == nil {
+		return nil
+	}
+
+	if err := onConnect(s); err != nil {
+		ns.mu.Lock()
+		delete(ns.sockets, s)
+		ns.mu.Unlock()
+
+		return err
+	}
+
+	return nil
+}
+
+func (ns *Namespace) leave(s *Socket, reason string) {
+	ns.mu.Lock()
+	_, ok := ns.sockets[s]
+	delete(ns.sockets, s)
+	onDisconnect := ns.onDisconnect
+	ns.mu.Unlock()
+
+	if ok && onDisconnect != nil {
+		onDisconnect(s, reason)
+	}
+}
+
+func (ns *Namespace) dispatch(s *Socket, event string, args []json.RawMessage, ack int) {
+	ns.mu.RLock()
+	fn, ok := ns.events[event]
+	ns.mu.RUnlock()
+
+	if !ok {
+		return
+	}
+
+	var ackFn AckFunc
+
+	if ack >= 0 {
+		ackFn = func(args ...any) error {
+			data, err := json.Marshal(args)
+			if err != nil {
+				return err
+			}
+
+			return s.conn.writePacket(packetAck, ns.name, ack, data)
+		}
+	}
+
+	fn(s, args, ackFn)
+}
@@ -0,0 +1,81 @@
+package socketio
+
+import (
+	"encoding/json"
+	"sync"
+
+	"github.com/fshiori/kuromi"
+)
+
+// Socket is a client connected to a namespace.
+type Socket struct {
+	ID   string          // Socket ID sent to the client.
+	Auth json.RawMessage // Auth payload sent by the client when connecting, if any.
+
+	conn *conn
+	nsp  *Namespace
+
+	mu      sync.Mutex
+	acks    map[int]func([]json.RawMessage)
+	nextAck int
+}
+
+// Session returns the kuromi session carrying the socket.
+func (s *Socket) Session() *kuromi.Session {
+	return s.conn.session
+}
+
+// Namespace returns the namespace of the socket.
+func (s *Socket) Namespace() *Namespace {
+	return s.nsp
+}
+
+// Emit emits event with args to the client.
+func (s *Socket) Emit(event string, args ...any) error {
+	data, err := encodeEvent(event, args)
+	if err != nil {
+		return err
+	}
+
+	return s.conn.writePacket(packetEvent, s.nsp.name, -1, data)
+}
+
+// EmitWithAck emits event with args to the client and fires fn with the
+// arguments of the client's acknowledgement.
+func (s *Socket) EmitWithAck(fn func([]json.RawMessage), event string, args ...any) error {
+	data, err := encodeEvent(event, args)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	id := s.nextAck
+	s.nextAck++
+	s.acks[id] = fn
+	s.mu.Unlock()
+
+	return s.conn.writePacket(packetEvent, s.nsp.name, id, data)
+}
+
+// Disconnect disconnects the socket from its namespace, leaving the
+// underlying connection and other namespaces open.
+func (s *Socket) Disconnect() error {
+	s.conn.mu.Lock()
+	delete(s.conn.sockets, s.nsp.name)
+	s.conn.mu.Unlock()
+
+	s.nsp.leave(s, "server namespace disconnect")
+
+	return s.conn.writePacket(packetDisconnect, s.nsp.name, -1, nil)
+}
+
+func (s *Socket) acked(id int, args []json.RawMessage) {
+	s.mu.Lock()
+	fn, ok := s.acks[id]
+	delete(s.acks, id)
+	s.mu.Unlock()
+
+	if ok {
+		fn(args)
+	}
+}
@@ -0,0 +1,445 @@
+// Package socketio lets Socket.IO clients connect to a kuromi server.
+//
+// It implements the Engine.IO v4 and Socket.IO v5 framing over kuromi
+// sessions: the open handshake, heartbeats, namespaces, events and
+// acknowledgements. Only the websocket transport is supported, so clients
+// must connect with transports: ["websocket"]. Binary attachments are not
+// supported.
+//
+//	k := kuromi.New()
+//	io := socketio.New(k.Route("/socket.io/"))
+//	io.Of("/").On("chat", func(s *socketio.Socket, args []json.RawMessage, ack socketio.AckFunc) {
+//		io.Of("/").Emit("chat", args[0])
+//	})
+//	http.Handle("/socket.io/", k)
+package socketio
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/fshiori/kuromi"
+)
+
+// Engine.IO packet types.
+const (
+	engineOpen    = '0'
+	engineClose   = '1'
+	enginePing    = '2'
+	enginePong    = '3'
+	engineMessage = '4'
+)
+
+// Socket.IO packet types.
+const (
+	packetConnect      = '0'
+	packetDisconnect   = '1'
+	packetEvent        = '2'
+	packetAck          = '3'
+	packetConnectError = '4'
+	packetBinaryEvent  = '5'
+	packetBinaryAck    = '6'
+)
+
+var (
+	ErrUnsupportedVersion = errors.New("unsupported engine.io protocol version")
+	ErrBinaryUnsupported  = errors.New("binary socket.io packets are not supported")
+	ErrMalformedPacket    = errors.New("malformed socket.io packet")
+	ErrUnknownNamespace   = errors.New("invalid namespace")
+)
+
+// Handlers is implemented by *kuromi.Kuromi, *kuromi.Route and *kuromi.Namespace.
+type Handlers interface {
+	HandleConnectErr(func(*kuromi.Session) error)
+	HandleDisconnect(func(*kuromi.Session))
+	HandleMessage(func(*kuromi.Session, []byte))
+}
+
+// Server speaks Socket.IO to the sessions of the handlers it was created with.
+type Server struct {
+	PingInterval time.Duration // Interval between heartbeats sent to clients.
+	PingTimeout  time.Duration // Time a client has to answer a heartbeat before it is disconnected.
+	MaxPayload   int           // Maximum payload size advertised to clients.
+
+	mu         sync.RWMutex
+	namespaces map[string]*Namespace
+	conns      map[*kuromi.Session]*conn
+}
+
+// New creates a Server and installs its connect, disconnect and message handlers on h.
+func New(h Handlers) *Server {
+	srv := &Server{
+		PingInterval: 25 * time.Second,
+		PingTimeout:  20 * time.Second,
+		MaxPayload:   1000000,
+		namespaces:   make(map[string]*Namespace),
+		conns:        make(map[*kuromi.Session]*conn),
+	}
+
+	srv.Of("/")
+
+	h.HandleConnectErr(srv.connect)
+	h.HandleDisconnect(srv.disconnect)
+	h.HandleMessage(srv.message)
+
+	return srv
+}
+
+// Of returns the namespace called name, creating it if it does not exist yet.
+func (srv *Server) Of(name string) *Namespace {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	if ns, ok := srv.namespaces[name]; ok {
+		return ns
+	}
+
+	ns := &Namespace{
+		name:    name,
+		server:  srv,
+		events:  make(map[string]EventHandler),
+		sockets: make(map[*Socket]struct{}),
+	}
+	srv.namespaces[name] = ns
+
+	return ns
+}
+
+func (srv *Server) lookup(name string) (*Namespace, bool) {
+	srv.mu.RLock()
+	defer srv.mu.RUnlock()
+
+	ns, ok := srv.namespaces[name]
+
+	return ns, ok
+}
+
+func (srv *Server) conn(s *kuromi.Session) *conn {
+	srv.mu.RLock()
+	defer srv.mu.RUnlock()
+
+	return srv.conns[s]
+}
+
+func (srv *Server) connect(s *kuromi.Session) error {
+	if s.Request.URL.Query().Get("EIO") != "4" {
+		return &kuromi.StatusError{Code: kuromi.StatusProtocolError, Err: ErrUnsupportedVersion}
+	}
+
+	c := &conn{
+		sid:      newID(),
+		session:  s,
+		server:   srv,
+		sockets:  make(map[string]*Socket),
+		lastPong: time.Now(),
+		done:     make(chan struct{}),
+	}
+
+	srv.mu.Lock()
+	srv.conns[s] = c
+	srv.mu.Unlock()
+
+	open, _ := json.Marshal(map[string]any{
+		"sid":          c.sid,
+		"upgrades":     []string{},
+		"pingInterval": srv.PingInterval.Milliseconds(),
+		"pingTimeout":  srv.PingTimeout.Milliseconds(),
+		"maxPayload":   srv.MaxPayload,
+	})
+
+	if err := c.write(engineOpen, open); err != nil {
+		return err
+	}
+
+	go c.heartbeat()
+
+	return nil
+}
+
+func (srv *Server) disconnect(s *kuromi.Session) {
+	srv.mu.Lock()
+	c, ok := srv.conns[s]
+	delete(srv.conns, s)
+	srv.mu.Unlock()
+
+	if ok {
+		c.close("transport close")
+	}
+}
+
+func (srv *Server) message(s *kuromi.Session, msg []byte) {
+	c := srv.conn(s)
+	if c == nil || len(msg) == 0 {
+		return
+	}
+
+	switch msg[0] {
+	case enginePong:
+		c.pong()
+	case enginePing:
+		c.write(enginePong, msg[1:])
+	case engineClose:
+		s.Close()
+	case engineMessage:
+		if err := c.packet(msg[1:]); err != nil {
+			s.CloseWithMsg(kuromi.StatusProtocolError, err.Error())
+		}
+	}
+}
+
+// conn is the Engine.IO connection of a session.
+type conn struct {
+	sid     string
+	session *kuromi.Session
+	server  *Server
+
+	mu       sync.Mutex
+	sockets  map[string]*Socket
+	lastPong time.Time
+	done     chan struct{}
+	closed   bool
+}
+
+func (c *conn) write(t byte, payload []byte) error {
+	return c.session.Write(append([]byte{t}, payload...))
+}
+
+func (c *conn) writePacket(t byte, nsp string, ack int, data []byte) error {
+	var buf bytes.Buffer
+
+	buf.WriteByte(t)
+
+	if nsp != "/" {
+		buf.WriteString(nsp)
+		buf.WriteByte(',')
+	}
+
+	if ack >= 0 {
+		buf.WriteString(strconv.Itoa(ack))
+	}
+
+	buf.Write(data)
+
+	return c.write(engineMessage, buf.Bytes())
+}
+
+func (c *conn) heartbeat() {
+	ticker := time.NewTicker(c.server.PingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+			c.mu.Lock()
+			late := time.Since(c.lastPong) > c.server.PingInterval+c.server.PingTimeout
+			c.mu.Unlock()
+
+			if late {
+				c.session.CloseWithMsg(kuromi.StatusGoingAway, "ping timeout")
+				return
+			}
+
+			c.write(enginePing, nil)
+		}
+	}
+}
+
+func (c *conn) pong() {
+	c.mu.Lock()
+	c.lastPong = time.Now()
+	c.mu.Unlock()
+}
+
+func (c *conn) close(reason string) {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return
+	}
+	c.closed = true
+	close(c.done)
+	sockets := c.sockets
+	c.sockets = make(map[string]*Socket)
+	c.mu.Unlock()
+
+	for _, socket := range sockets {
+		socket.nsp.leave(socket, reason)
+	}
+}
+
+func (c *conn) socket(nsp string) *Socket {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.sockets[nsp]
+}
+
+// packet handles a Socket.IO packet.
+func (c *conn) packet(p []byte) error {
+	if len(p) == 0 {
+		return ErrMalformedPacket
+	}
+
+	t, rest := p[0], p[1:]
+
+	if t == packetBinaryEvent || t == packetBinaryAck {
+		return ErrBinaryUnsupported
+	}
+
+	nsp := "/"
+
+	if len(rest) > 0 && rest[0] == '/' {
+		end := bytes.IndexByte(rest, ',')
+		if end < 0 {
+			nsp, rest = string(rest), nil
+		} else {
+			nsp, rest = string(rest[:end]), rest[end+1:]
+		}
+	}
+
+	ack := -1
+	i := 0
+
+	for i < len(rest) && rest[i] >= '0' && rest[i] <= '9' {
+		i++
+	}
+
+	if i > 0 {
+		ack, _ = strconv.Atoi(string(rest[:i]))
+		rest = rest[i:]
+	}
+
+	switch t {
+	case packetConnect:
+		return c.connectNamespace(nsp, rest)
+	case packetDisconnect:
+		c.mu.Lock()
+		socket, ok := c.sockets[nsp]
+		delete(c.sockets, nsp)
+		c.mu.Unlock()
+
+		if ok {
+			socket.nsp.leave(socket, "client namespace disconnect")
+		}
+	case packetEvent:
+		socket := c.socket(nsp)
+		if socket == nil {
+			return nil
+		}
+
+		var args []json.RawMessage
+		if err := json.Unmarshal(rest, &args); err != nil || len(args) == 0 {
+			return ErrMalformedPacket
+		}
+
+		var event string
+		if err := json.Unmarshal(args[0], &event); err != nil {
+			return ErrMalformedPacket
+		}
+
+		socket.nsp.dispatch(socket, event, args[1:], ack)
+	case packetAck:
+		socket := c.socket(nsp)
+		if socket == nil {
+			return nil
+		}
+
+		var args []json.RawMessage
+		if err := json.Unmarshal(rest, &args); err != nil {
+			return ErrMalformedPacket
+		}
+
+		socket.acked(ack, args)
+	default:
+		return ErrMalformedPacket
+	}
+
+	return nil
+}
+
+func (c *conn) connectNamespace(nsp string, auth []byte) error {
+	ns, ok := c.server.lookup(nsp)
+	if !ok {
+		return c.connectError(nsp, ErrUnknownNamespace)
+	}
+
+	socket := &Socket{
+		ID:   newID(),
+		Auth: json.RawMessage(auth),
+		conn: c,
+		nsp:  ns,
+		acks: make(map[int]func([]json.RawMessage)),
+	}
+
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	old := c.sockets[nsp]
+	c.sockets[nsp] = socket
+	c.mu.Unlock()
+
+	// A client connecting to a namespace again replaces its socket.
+	if old != nil {
+		old.nsp.leave(old, "client namespace disconnect")
+	}
+
+	data, _ := json.Marshal(map[string]string{"sid": socket.ID})
+
+	if err := c.writePacket(packetConnect, nsp, -1, data); err != nil {
+		return err
+	}
+
+	if err := ns.join(socket); err != nil {
+		c.mu.Lock()
+		if c.sockets[nsp] == socket {
+			delete(c.sockets, nsp)
+		}
+		c.mu.Unlock()
+
+		return c.connectError(nsp, err)
+	}
+
+	// The connection may have closed while OnConnect ran, after its sockets left.
+	c.mu.Lock()
+	closed := c.closed
+	c.mu.Unlock()
+
+	if closed {
+		ns.leave(socket, "transport close")
+	}
+
+	return nil
+}
+
+func (c *conn) connectError(nsp string, err error) error {
+	data, _ := json.Marshal(map[string]string{"message": err.Error()})
+
+	return c.writePacket(packetConnectError, nsp, -1, data)
+}
+
+func newID() string {
+	b := make([]byte, 15)
+	rand.Read(b)
+
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func encodeEvent(event string, args []any) ([]byte, error) {
+	data, err := json.Marshal(append([]any{event}, args...))
+	if err != nil {
+		return nil, fmt.Errorf("socketio: encoding event %q: %w", event, err)
+	}
+
+	return data, nil
+}
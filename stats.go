@@ -0,0 +1,60 @@
+package kuromi
+
+import "sync/atomic"
+
+// Stats holds the number of messages and bytes received from and sent to
+// sessions. Byte counts are the size of the message payloads, including any
+// framing added by kuromi, e.g. sequence numbers.
+type Stats struct {
+	MessagesIn  uint64 `json:"messages_in"`
+	MessagesOut uint64 `json:"messages_out"`
+	BytesIn     uint64 `json:"bytes_in"`
+	BytesOut    uint64 `json:"bytes_out"`
+}
+
+type trafficCounter struct {
+	messagesIn  atomic.Uint64
+	messagesOut atomic.Uint64
+	bytesIn     atomic.Uint64
+	bytesOut    atomic.Uint64
+}
+
+func (c *trafficCounter) in(n int) {
+	c.messagesIn.Add(1)
+	c.bytesIn.Add(uint64(n))
+}
+
+func (c *trafficCounter) out(n int) {
+	c.messagesOut.Add(1)
+	c.bytesOut.Add(uint64(n))
+}
+
+func (c *trafficCounter) stats() Stats {
+	return Stats{
+		MessagesIn:  c.messagesIn.Load(),
+		MessagesOut: c.messagesOut.Load(),
+		BytesIn:     c.bytesIn.Load(),
+		BytesOut:    c.bytesOut.Load(),
+	}
+}
+
+// Stats returns the traffic of the session since it connected.
+func (s *Session) Stats() Stats {
+	return s.traffic.stats()
+}
+
+// Stats returns the traffic of all sessions, including the ones that already
+// disconnected, since the kuromi instance was created.
+func (k *Kuromi) Stats() Stats {
+	return k.traffic.stats()
+}
+
+func (s *Session) countIn(n int) {
+	s.traffic.in(n)
+	s.kuromi.traffic.in(n)
+}
+
+func (s *Session) countOut(n int) {
+	s.traffic.out(n)
+	s.kuromi.traffic.out(n)
+}
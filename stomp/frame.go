@@ -0,0 +1,168 @@
+package stomp
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// Client commands.
+const (
+	CommandConnect     = "CONNECT"
+	CommandStomp       = "STOMP"
+	CommandSend        = "SEND"
+	CommandSubscribe   = "SUBSCRIBE"
+	CommandUnsubscribe = "UNSUBSCRIBE"
+	CommandAck         = "ACK"
+	CommandNack        = "NACK"
+	CommandBegin       = "BEGIN"
+	CommandCommit      = "COMMIT"
+	CommandAbort       = "ABORT"
+	CommandDisconnect  = "DISCONNECT"
+)
+
+// Server commands.
+const (
+	CommandConnected = "CONNECTED"
+	CommandMessage   = "MESSAGE"
+	CommandReceipt   = "RECEIPT"
+	CommandError     = "ERROR"
+)
+
+var ErrMalformedFrame = errors.New("malformed stomp frame")
+
+// Frame is a STOMP frame.
+type Frame struct {
+	Command string
+	Headers map[string]string
+	Body    []byte
+}
+
+// Header returns the value of header name.
+func (f *Frame) Header(name string) string {
+	return f.Headers[name]
+}
+
+var headerEscaper = strings.NewReplacer("\\", "\\\\", "\r", "\\r", "\n", "\\n", ":", "\\c")
+var headerUnescaper = strings.NewReplacer("\\\\", "\\", "\\r", "\r", "\\n", "\n", "\\c", ":")
+
+// escapes reports whether header values of frames with command are escaped.
+// STOMP 1.2 does not escape the headers of CONNECT and CONNECTED frames.
+func escapes(command string) bool {
+	return command != CommandConnect && command != CommandConnected
+}
+
+// Bytes encodes the frame.
+func (f *Frame) Bytes() []byte {
+	var buf bytes.Buffer
+
+	buf.WriteString(f.Command)
+	buf.WriteByte('\n')
+
+	for name, value := range f.Headers {
+		if escapes(f.Command) {
+			name, value = headerEscaper.Replace(name), headerEscaper.Replace(value)
+		}
+
+		buf.WriteString(name)
+		buf.WriteByte(':')
+		buf.WriteString(value)
+		buf.WriteByte('\n')
+	}
+
+	if len(f.Body) > 0 {
+		buf.WriteString("content-length:")
+		buf.WriteString(strconv.Itoa(len(f.Body)))
+		buf.WriteByte('\n')
+	}
+
+	buf.WriteByte('\n')
+	buf.Write(f.Body)
+	buf.WriteByte(0)
+
+	return buf.Bytes()
+}
+
+// parseFrames decodes all frames in data, skipping heart-beat EOLs between them.
+func parseFrames(data []byte) ([]*Frame, error) {
+	var frames []*Frame
+
+	for {
+		data = bytes.TrimLeft(data, "\r\n")
+		if len(data) == 0 {
+			return frames, nil
+		}
+
+		f, rest, err := parseFrame(data)
+		if err != nil {
+			return nil, err
+		}
+
+		frames = append(frames, f)
+		data = rest
+	}
+}
+
+func parseFrame(data []byte) (*Frame, []byte, error) {
+	f := &Frame{Headers: make(map[string]string)}
+
+	line, data, ok := cutLine(data)
+	if !ok || line == "" {
+		return nil, nil, ErrMalformedFrame
+	}
+
+	f.Command = line
+
+	for {
+		line, data, ok = cutLine(data)
+		if !ok {
+			return nil, nil, ErrMalformedFrame
+		}
+
+		if line == "" {
+			break
+		}
+
+		name, value, found := strings.Cut(line, ":")
+		if !found {
+			return nil, nil, ErrMalformedFrame
+		}
+
+		if escapes(f.Command) {
+			name, value = headerUnescaper.Replace(name), headerUnescaper.Replace(value)
+		}
+
+		// Only the first occurrence of a repeated header is used.
+		if _, exists := f.Headers[name]; !exists {
+			f.Headers[name] = value
+		}
+	}
+
+	if cl, ok := f.Headers["content-length"]; ok {
+		n, err := strconv.Atoi(cl)
+		if err != nil || n < 0 || n >= len(data) || data[n] != 0 {
+			return nil, nil, ErrMalformedFrame
+		}
+
+		f.Body, data = data[:n], data[n+1:]
+	} else {
+		end := bytes.IndexByte(data, 0)
+		if end < 0 {
+			return nil, nil, ErrMalformedFrame
+		}
+
+		f.Body, data = data[:end], data[end+1:]
+	}
+
+	return f, data, nil
+}
+
+func cutLine(data []byte) (string, []byte, bool) {
+	i := bytes.IndexByte(data, '\n')
+	if i < 0 {
+		return "", nil, false
+	}
+
+	return strings.TrimSuffix(string(data[:i]), "\r"), data[i+1:], true
+}
@@ -0,0 +1,442 @@
+// Package stomp implements a STOMP 1.2 server over kuromi sessions.
+//
+// Clients connect with the v12.stomp subprotocol, so it has to be listed in
+// Kuromi.AcceptOptions.Subprotocols. Destinations are plain topics: a frame
+// sent to a destination is delivered to every subscription of that
+// destination, unless a send handler is set.
+//
+//	k := kuromi.New()
+//	k.AcceptOptions = &websocket.AcceptOptions{Subprotocols: []string{"v12.stomp"}}
+//	srv := stomp.New(k)
+//	http.Handle("/stomp", k)
+package stomp
+
+import (
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"unicode/utf8"
+
+	"github.com/fshiori/kuromi"
+)
+
+// Ack modes of a subscription. With AckClient an ACK or NACK applies to the
+// message and all earlier messages of the subscription, with
+// AckClientIndividual only to the message.
+const (
+	AckAuto             = "auto"
+	AckClient           = "client"
+	AckClientIndividual = "client-individual"
+)
+
+// MaxPendingAcks is the number of messages per subscription kept waiting for an
+// ACK or NACK. Beyond it the oldest are forgotten and acknowledging them fails.
+const MaxPendingAcks = 1024
+
+var (
+	ErrNotConnected        = errors.New("stomp session is not connected")
+	ErrUnsupportedVersion  = errors.New("supported protocol version is 1.2")
+	ErrMissingHeader       = errors.New("missing required header")
+	ErrUnknownSubscription = errors.New("unknown subscription")
+	ErrUnknownCommand      = errors.New("unknown command")
+	ErrTransactions        = errors.New("transactions are not supported")
+)
+
+// Handlers is implemented by *kuromi.Kuromi, *kuromi.Route and *kuromi.Namespace.
+type Handlers interface {
+	HandleConnect(func(*kuromi.Session))
+	HandleDisconnect(func(*kuromi.Session))
+	HandleMessage(func(*kuromi.Session, []byte))
+	HandleMessageBinary(func(*kuromi.Session, []byte))
+}
+
+// Server routes STOMP frames between the sessions of the handlers it was created with.
+type Server struct {
+	mu           sync.RWMutex
+	conns        map[*kuromi.Session]*conn
+	destinations map[string]map[*subscription]struct{}
+	messageID    atomic.Uint64
+
+	authenticate func(s *kuromi.Session, login, passcode string) error
+	send         func(s *kuromi.Session, f *Frame) error
+	nack         func(s *kuromi.Session, f *Frame)
+}
+
+// New creates a Server and installs its connect, disconnect and message handlers on h.
+func New(h Handlers) *Server {
+	srv := &Server{
+		conns:        make(map[*kuromi.Session]*conn),
+		destinations: make(map[string]map[*subscription]struct{}),
+	}
+
+	h.HandleConnect(srv.connect)
+	h.HandleDisconnect(srv.disconnect)
+	h.HandleMessage(srv.message)
+	h.HandleMessageBinary(srv.message)
+
+	return srv
+}
+
+// HandleAuthenticate fires fn with the login and passcode headers of CONNECT frames.
+// If fn returns an error the client receives an ERROR frame and is disconnected.
+func (srv *Server) HandleAuthenticate(fn func(s *kuromi.Session, login, passcode string) error) {
+	srv.authenticate = fn
+}
+
+// HandleSend fires fn for SEND frames instead of delivering them to the subscribers
+// of their destination. fn can call Publish to deliver the frame. If fn returns an
+// error the client receives an ERROR frame.
+func (srv *Server) HandleSend(fn func(s *kuromi.Session, f *Frame) error) {
+	srv.send = fn
+}
+
+// HandleNack fires fn when a client rejects a message with a NACK frame.
+func (srv *Server) HandleNack(fn func(s *kuromi.Session, f *Frame)) {
+	srv.nack = fn
+}
+
+// Publish sends body to all subscriptions of destination as MESSAGE frames.
+// headers are added to the frames.
+func (srv *Server) Publish(destination string, body []byte, headers map[string]string) {
+	srv.mu.RLock()
+	subs := make([]*subscription, 0, len(srv.destinations[destination]))
+	for sub := range srv.destinations[destination] {
+		subs = append(subs, sub)
+	}
+	srv.mu.RUnlock()
+
+	for _, sub := range subs {
+		f := &Frame{Command: CommandMessage, Headers: make(map[string]string, len(headers)+4), Body: body}
+
+		for name, value := range headers {
+			f.Headers[name] = value
+		}
+
+		id := strconv.FormatUint(srv.messageID.Add(1), 10)
+		f.Headers["destination"] = destination
+		f.Headers["subscription"] = sub.id
+		f.Headers["message-id"] = id
+
+		if sub.ack != AckAuto {
+			f.Headers["ack"] = id
+			sub.conn.pending(id, sub)
+		}
+
+		sub.conn.write(f)
+	}
+}
+
+// Subscribers returns the number of subscriptions to destination.
+func (srv *Server) Subscribers(destination string) int {
+	srv.mu.RLock()
+	defer srv.mu.RUnlock()
+
+	return len(srv.destinations[destination])
+}
+
+func (srv *Server) connect(s *kuromi.Session) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	srv.conns[s] = &conn{
+		session: s,
+		server:  srv,
+		subs:    make(map[string]*subscription),
+		acks:    make(map[string]*subscription),
+	}
+}
+
+func (srv *Server) disconnect(s *kuromi.Session) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	c, ok := srv.conns[s]
+	if !ok {
+		return
+	}
+
+	delete(srv.conns, s)
+
+	for _, sub := range c.subs {
+		srv.unsubscribe(sub)
+	}
+}
+
+func (srv *Server) subscribe(sub *subscription) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	subs, ok := srv.destinations[sub.destination]
+	if !ok {
+		subs = make(map[*subscription]struct{})
+		srv.destinations[sub.destination] = subs
+	}
+
+	subs[sub] = struct{}{}
+}
+
+// unsubscribe must be called with srv.mu held.
+func (srv *Server) unsubscribe(sub *subscription) {
+	subs := srv.destinations[sub.destination]
+	delete(subs, sub)
+
+	if len(subs) == 0 {
+		delete(srv.destinations, sub.destination)
+	}
+}
+
+func (srv *Server) message(s *kuromi.Session, msg []byte) {
+	srv.mu.RLock()
+	c, ok := srv.conns[s]
+	srv.mu.RUnlock()
+
+	if !ok {
+		return
+	}
+
+	frames, err := parseFrames(msg)
+	if err != nil {
+		c.fail(nil, err)
+		return
+	}
+
+	for _, f := range frames {
+		if err := c.handle(f); err != nil {
+			c.fail(f, err)
+			return
+		}
+
+		if receipt := f.Header("receipt"); receipt != "" {
+			c.write(&Frame{Command: CommandReceipt, Headers: map[string]string{"receipt-id": receipt}})
+		}
+	}
+}
+
+type subscription struct {
+	id          string
+	destination string
+	ack         string
+	conn        *conn
+	pending     []string // IDs of messages waiting for an ACK, oldest first, guarded by conn.mu.
+}
+
+// conn is the STOMP state of a session.
+type conn struct {
+	session *kuromi.Session
+	server  *Server
+
+	mu        sync.Mutex
+	connected bool
+	subs      map[string]*subscription
+	acks      map[string]*subscription
+}
+
+func (c *conn) write(f *Frame) error {
+	data := f.Bytes()
+
+	if utf8.Valid(f.Body) {
+		return c.session.Write(data)
+	}
+
+	return c.session.WriteBinary(data)
+}
+
+// fail sends an ERROR frame for err and closes the session, as required by STOMP.
+func (c *conn) fail(f *Frame, err error) {
+	e := &Frame{Command: CommandError, Headers: map[string]string{"message": err.Error()}}
+
+	if f != nil {
+		if receipt := f.Header("receipt"); receipt != "" {
+			e.Headers["receipt-id"] = receipt
+		}
+	}
+
+	c.write(e)
+	c.session.Close()
+}
+
+func (c *conn) pending(id string, sub *subscription) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(sub.pending) >= MaxPendingAcks {
+		delete(c.acks, sub.pending[0])
+		sub.pending = sub.pending[1:]
+	}
+
+	sub.pending = append(sub.pending, id)
+	c.acks[id] = sub
+}
+
+// acknowledge removes the message id from the messages waiting for an ACK, with
+// the earlier messages of its subscription in AckClient mode, and reports
+// whether it was waiting.
+func (c *conn) acknowledge(id string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	sub, ok := c.acks[id]
+	if !ok {
+		return false
+	}
+
+	i := slices.Index(sub.pending, id)
+
+	if sub.ack == AckClient {
+		for _, acked := range sub.pending[:i+1] {
+			delete(c.acks, acked)
+		}
+
+		sub.pending = sub.pending[i+1:]
+
+		return true
+	}
+
+	delete(c.acks, id)
+	sub.pending = slices.Delete(sub.pending, i, i+1)
+
+	return true
+}
+
+// forget drops the messages of sub waiting for an ACK, c.mu must be held.
+func (c *conn) forget(sub *subscription) {
+	for _, id := range sub.pending {
+		delete(c.acks, id)
+	}
+
+	sub.pending = nil
+}
+
+func (c *conn) handle(f *Frame) error {
+	c.mu.Lock()
+	connected := c.connected
+	c.mu.Unlock()
+
+	if f.Command == CommandConnect || f.Command == CommandStomp {
+		return c.handleConnect(f)
+	}
+
+	if !connected {
+		return ErrNotConnected
+	}
+
+	switch f.Command {
+	case CommandSend:
+		destination := f.Header("destination")
+		if destination == "" {
+			return ErrMissingHeader
+		}
+
+		if c.server.send != nil {
+			return c.server.send(c.session, f)
+		}
+
+		headers := make(map[string]string)
+		for name, value := range f.Headers {
+			if name != "destination" && name != "receipt" && name != "content-length" {
+				headers[name] = value
+			}
+		}
+
+		c.server.Publish(destination, f.Body, headers)
+	case CommandSubscribe:
+		id, destination := f.Header("id"), f.Header("destination")
+		if id == "" || destination == "" {
+			return ErrMissingHeader
+		}
+
+		ack := f.Header("ack")
+		if ack == "" {
+			ack = AckAuto
+		}
+
+		sub := &subscription{id: id, destination: destination, ack: ack, conn: c}
+
+		c.mu.Lock()
+		old, exists := c.subs[id]
+		c.subs[id] = sub
+		if exists {
+			c.forget(old)
+		}
+		c.mu.Unlock()
+
+		if exists {
+			c.server.mu.Lock()
+			c.server.unsubscribe(old)
+			c.server.mu.Unlock()
+		}
+
+		c.server.subscribe(sub)
+	case CommandUnsubscribe:
+		c.mu.Lock()
+		sub, ok := c.subs[f.Header("id")]
+		delete(c.subs, f.Header("id"))
+		if ok {
+			c.forget(sub)
+		}
+		c.mu.Unlock()
+
+		if !ok {
+			return ErrUnknownSubscription
+		}
+
+		c.server.mu.Lock()
+		c.server.unsubscribe(sub)
+		c.server.mu.Unlock()
+	case CommandAck, CommandNack:
+		if !c.acknowledge(f.Header("id")) {
+			return ErrUnknownSubscription
+		}
+
+		if f.Command == CommandNack && c.server.nack != nil {
+			c.server.nack(c.session, f)
+		}
+	case CommandBegin, CommandCommit, CommandAbort:
+		return ErrTransactions
+	case CommandDisconnect:
+		if receipt := f.Header("receipt"); receipt != "" {
+			c.write(&Frame{Command: CommandReceipt, Headers: map[string]string{"receipt-id": receipt}})
+			delete(f.Headers, "receipt")
+		}
+
+		c.session.Close()
+	default:
+		return ErrUnknownCommand
+	}
+
+	return nil
+}
+
+func (c *conn) handleConnect(f *Frame) error {
+	if versions := f.Header("accept-version"); versions != "" && !acceptsVersion(versions) {
+		return ErrUnsupportedVersion
+	}
+
+	if c.server.authenticate != nil {
+		if err := c.server.authenticate(c.session, f.Header("login"), f.Header("passcode")); err != nil {
+			return err
+		}
+	}
+
+	c.mu.Lock()
+	c.connected = true
+	c.mu.Unlock()
+
+	return c.write(&Frame{Command: CommandConnected, Headers: map[string]string{
+		"version":    "1.2",
+		"heart-beat": "0,0",
+	}})
+}
+
+func acceptsVersion(versions string) bool {
+	for _, v := range strings.Split(versions, ",") {
+		if strings.TrimSpace(v) == "1.2" {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,65 @@
+package kuromi
+
+// Subprotocol is a set of handlers for the sessions that negotiated a websocket
+// subprotocol, e.g. "json.v1", so one endpoint can serve clients speaking
+// different protocols. Handlers that are not set on the subprotocol fall back
+// to the handlers of the kuromi instance. Sessions of a namespace or route use
+// the handlers of the namespace or route instead.
+type Subprotocol struct {
+	handlers
+	name   string
+	kuromi *Kuromi
+}
+
+// Subprotocol returns the handler set for the subprotocol name, creating it if
+// it does not exist yet. Registered subprotocols are offered to clients when
+// accepting connections, in addition to AcceptOptions.Subprotocols.
+func (k *Kuromi) Subprotocol(name string) *Subprotocol {
+	k.protocolsMu.Lock()
+	defer k.protocolsMu.Unlock()
+
+	if sp, ok := k.protocols[name]; ok {
+		return sp
+	}
+
+	sp := &Subprotocol{
+		handlers: handlers{parent: &k.handlers},
+		name:     name,
+		kuromi:   k,
+	}
+	k.protocols[name] = sp
+	k.protocolNames = append(k.protocolNames, name)
+
+	return sp
+}
+
+func (k *Kuromi) lookupSubprotocol(name string) *Subprotocol {
+	k.protocolsMu.RLock()
+	defer k.protocolsMu.RUnlock()
+
+	return k.protocols[name]
+}
+
+// Name returns the name of the subprotocol.
+func (sp *Subprotocol) Name() string {
+	return sp.name
+}
+
+// Sessions returns all sessions that negotiated the subprotocol.
+func (sp *Subprotocol) Sessions() []*Session {
+	var sessions []*Session
+
+	sp.kuromi.hub.sessions.each(func(s *Session) {
+		if s.subprotocol == sp.name {
+			sessions = append(sessions, s)
+		}
+	})
+
+	return sessions
+}
+
+// Subprotocol returns the websocket subprotocol negotiated by the session, or an
+// empty string if none was negotiated.
+func (s *Session) Subprotocol() string {
+	return s.subprotocol
+}
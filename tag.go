@@ -0,0 +1,97 @@
+package kuromi
+
+import (
+	"context"
+
+	"github.com/coder/websocket"
+)
+
+// AddTag tags the session with tag, e.g. "admin", so it can be targeted with BroadcastTag.
+func (s *Session) AddTag(tag string) error {
+	s.rwmutex.Lock()
+	defer s.rwmutex.Unlock()
+
+	if !s.open {
+		return ErrSessionClosed
+	}
+
+	if s.tags == nil {
+		s.tags = make(map[string]struct{})
+	}
+	s.tags[tag] = struct{}{}
+	s.kuromi.tags.add(tag, s)
+
+	return nil
+}
+
+// RemoveTag removes tag from the session.
+func (s *Session) RemoveTag(tag string) {
+	s.rwmutex.Lock()
+	delete(s.tags, tag)
+	s.rwmutex.Unlock()
+
+	s.kuromi.tags.del(tag, s)
+}
+
+// HasTag reports whether the session is tagged with tag.
+func (s *Session) HasTag(tag string) bool {
+	s.rwmutex.RLock()
+	defer s.rwmutex.RUnlock()
+
+	_, ok := s.tags[tag]
+
+	return ok
+}
+
+// Tags returns the tags of the session.
+func (s *Session) Tags() []string {
+	s.rwmutex.RLock()
+	defer s.rwmutex.RUnlock()
+
+	tags := make([]string, 0, len(s.tags))
+	for tag := range s.tags {
+		tags = append(tags, tag)
+	}
+
+	return tags
+}
+
+// TaggedSessions returns the sessions tagged with tag.
+func (k *Kuromi) TaggedSessions(tag string) []*Session {
+	return k.tags.get(tag)
+}
+
+// BroadcastTag broadcasts a text message to all sessions tagged with tag.
+// Unlike BroadcastFilter it only visits the tagged sessions.
+func (k *Kuromi) BroadcastTag(tag string, msg []byte) error {
+	return k.broadcastIndexed(k.tags, tag, envelope{t: websocket.MessageText, msg: msg})
+}
+
+// BroadcastTagBinary broadcasts a binary message to all sessions tagged with tag.
+func (k *Kuromi) BroadcastTagBinary(tag string, msg []byte) error {
+	return k.broadcastIndexed(k.tags, tag, envelope{t: websocket.MessageBinary, msg: msg})
+}
+
+// broadcastIndexed writes message to the sessions stored under key in ix,
+// without going through the hub.
+func (k *Kuromi) broadcastIndexed(ix *sessionIndex[string], key string, message envelope) error {
+	if k.hub.closed() {
+		return ErrClosed
+	}
+
+	if ok, err := k.admitBroadcast(context.Background(), len(message.msg)); !ok {
+		return err
+	}
+
+	for _, s := range ix.get(key) {
+		s.writeMessage(message)
+	}
+
+	return nil
+}
+
+func (k *Kuromi) untag(s *Session) {
+	for _, tag := range s.Tags() {
+		k.tags.del(tag, s)
+	}
+}
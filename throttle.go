@@ -0,0 +1,59 @@
+package kuromi
+
+import (
+	"math"
+	"sync/atomic"
+)
+
+// egressLimit is the outbound byte rate of a session, set with SetEgressRate.
+type egressLimit struct {
+	rate   atomic.Uint64 // Float64 bits, 0 uses Config.EgressByteRate.
+	bucket tokenBucket   // Only used by the write pump.
+}
+
+// SetEgressRate limits the bytes per second written to the session, e.g. to
+// what the link of a mobile client can handle, overriding Config.EgressByteRate.
+// A rate of 0 restores Config.EgressByteRate and a negative rate removes the limit.
+// Messages wait in the output queue of the session while it is throttled.
+func (s *Session) SetEgressRate(rate float64) {
+	s.egress.rate.Store(math.Float64bits(rate))
+}
+
+// EgressRate returns the bytes per second written to the session at most, or 0
+// if they are not limited.
+func (s *Session) EgressRate() float64 {
+	rate := math.Float64frombits(s.egress.rate.Load())
+	if rate == 0 {
+		rate = s.kuromi.Config.EgressByteRate
+	}
+
+	return max(rate, 0)
+}
+
+// throttle blocks the write pump until size bytes may be written to the session.
+// It returns false if the session is closed while waiting.
+func (s *Session) throttle(size int) bool {
+	rate := s.EgressRate()
+	if rate == 0 {
+		return true
+	}
+
+	burst := burstOf(rate, s.kuromi.Config.EgressByteBurst)
+
+	for {
+		wait := s.egress.bucket.wait(float64(size), rate, burst, s.kuromi.now())
+		if wait == 0 {
+			s.egress.bucket.take(float64(size))
+			return true
+		}
+
+		timer := s.kuromi.clock().NewTimer(wait)
+
+		select {
+		case <-timer.C():
+		case <-s.outputDone:
+			timer.Stop()
+			return false
+		}
+	}
+}
@@ -0,0 +1,240 @@
+package kuromi
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/coder/websocket"
+)
+
+// Topic patterns are dot separated levels, e.g. "orders.eu.created". In
+// subscriptions "*" matches exactly one level and "#", which must be the
+// last level, matches any number of remaining levels, including none.
+const (
+	topicSeparator      = "."
+	topicWildcard       = "*"
+	topicWildcardSuffix = "#"
+)
+
+type topicNode struct {
+	children map[string]*topicNode
+	subs     map[*Session]struct{}
+}
+
+func newTopicNode() *topicNode {
+	return &topicNode{
+		children: make(map[string]*topicNode),
+		subs:     make(map[*Session]struct{}),
+	}
+}
+
+// topicTree routes published topics to the sessions subscribed to matching patterns.
+type topicTree struct {
+	mu   sync.RWMutex
+	root *topicNode
+}
+
+func newTopicTree() *topicTree {
+	return &topicTree{root: newTopicNode()}
+}
+
+func validTopicPattern(pattern string) bool {
+	if pattern == "" {
+		return false
+	}
+
+	levels := strings.Split(pattern, topicSeparator)
+	for i, level := range levels {
+		if level == "" {
+			return false
+		}
+
+		if level == topicWildcardSuffix && i != len(levels)-1 {
+			return false
+		}
+	}
+
+	return true
+}
+
+func validTopic(topic string) bool {
+	if topic == "" {
+		return false
+	}
+
+	for _, level := range strings.Split(topic, topicSeparator) {
+		if level == "" || level == topicWildcard || level == topicWildcardSuffix {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (t *topicTree) subscribe(pattern string, s *Session) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	node := t.root
+	for _, level := range strings.Split(pattern, topicSeparator) {
+		child, ok := node.children[level]
+		if !ok {
+			child = newTopicNode()
+			node.children[level] = child
+		}
+		node = child
+	}
+
+	node.subs[s] = struct{}{}
+}
+
+func (t *topicTree) unsubscribe(pattern string, s *Session) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	levels := strings.Split(pattern, topicSeparator)
+	path := make([]*topicNode, 0, len(levels)+1)
+	path = append(path, t.root)
+
+	node := t.root
+	for _, level := range levels {
+		child, ok := node.children[level]
+		if !ok {
+			return
+		}
+		node = child
+		path = append(path, node)
+	}
+
+	delete(node.subs, s)
+
+	// Prune nodes left without subscriptions or children.
+	for i := len(levels) - 1; i >= 0; i-- {
+		n := path[i+1]
+		if len(n.subs) > 0 || len(n.children) > 0 {
+			break
+		}
+		delete(path[i].children, levels[i])
+	}
+}
+
+func (t *topicTree) match(topic string) []*Session {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	matched := make(map[*Session]struct{})
+	t.collect(t.root, strings.Split(topic, topicSeparator), matched)
+
+	sessions := make([]*Session, 0, len(matched))
+	for s := range matched {
+		sessions = append(sessions, s)
+	}
+
+	return sessions
+}
+
+func (t *topicTree) collect(node *topicNode, levels []string, matched map[*Session]struct{}) {
+	if suffix, ok := node.children[topicWildcardSuffix]; ok {
+		for s := range suffix.subs {
+			matched[s] = struct{}{}
+		}
+	}
+
+	if len(levels) == 0 {
+		for s := range node.subs {
+			matched[s] = struct{}{}
+		}
+		return
+	}
+
+	if child, ok := node.children[levels[0]]; ok {
+		t.collect(child, levels[1:], matched)
+	}
+
+	if child, ok := node.children[topicWildcard]; ok {
+		t.collect(child, levels[1:], matched)
+	}
+}
+
+// Subscribe subscribes the session to the topics matching pattern, see Kuromi.Publish.
+func (s *Session) Subscribe(pattern string) error {
+	if !validTopicPattern(pattern) {
+		return ErrInvalidTopic
+	}
+
+	s.rwmutex.Lock()
+	defer s.rwmutex.Unlock()
+
+	if !s.open {
+		return ErrSessionClosed
+	}
+
+	if s.subscriptions == nil {
+		s.subscriptions = make(map[string]struct{})
+	}
+	s.subscriptions[pattern] = struct{}{}
+	s.kuromi.topics.subscribe(pattern, s)
+
+	return nil
+}
+
+// Unsubscribe removes the subscription of the session to pattern.
+func (s *Session) Unsubscribe(pattern string) {
+	s.rwmutex.Lock()
+	delete(s.subscriptions, pattern)
+	s.rwmutex.Unlock()
+
+	s.kuromi.topics.unsubscribe(pattern, s)
+}
+
+// Subscriptions returns the topic patterns the session is subscribed to.
+func (s *Session) Subscriptions() []string {
+	s.rwmutex.RLock()
+	defer s.rwmutex.RUnlock()
+
+	patterns := make([]string, 0, len(s.subscriptions))
+	for pattern := range s.subscriptions {
+		patterns = append(patterns, pattern)
+	}
+
+	return patterns
+}
+
+// Publish writes a text message to all sessions subscribed to a pattern matching topic.
+// Topics are dot separated levels, e.g. "orders.created", which is matched by the
+// patterns "orders.created", "orders.*" and "orders.#".
+func (k *Kuromi) Publish(topic string, msg []byte) error {
+	return k.publish(topic, envelope{t: websocket.MessageText, msg: msg})
+}
+
+// PublishBinary writes a binary message to all sessions subscribed to a pattern matching topic.
+func (k *Kuromi) PublishBinary(topic string, msg []byte) error {
+	return k.publish(topic, envelope{t: websocket.MessageBinary, msg: msg})
+}
+
+func (k *Kuromi) publish(topic string, message envelope) error {
+	if !validTopic(topic) {
+		return ErrInvalidTopic
+	}
+
+	if k.hub.closed() {
+		return ErrClosed
+	}
+
+	if ok, err := k.admitBroadcast(context.Background(), len(message.msg)); !ok {
+		return err
+	}
+
+	for _, s := range k.topics.match(topic) {
+		s.writeMessage(message)
+	}
+
+	return nil
+}
+
+func (k *Kuromi) unsubscribeAll(s *Session) {
+	for _, pattern := range s.Subscriptions() {
+		k.topics.unsubscribe(pattern, s)
+	}
+}
@@ -0,0 +1,71 @@
+package kuromi
+
+import (
+	"net/http"
+
+	"github.com/coder/websocket"
+)
+
+// Transform is a stage of the message pipeline transforming the messages of
+// sessions, e.g. to encrypt or sign them, see Config.Transforms, AESGCM and HMACSigner.
+type Transform interface {
+	// Handshake is called with every request before it is upgraded and returns
+	// the transformer of the session. It may set response headers on w, e.g.
+	// for a key exchange. An error rejects the request with 400 Bad Request.
+	Handshake(w http.ResponseWriter, r *http.Request) (SessionTransform, error)
+}
+
+// SessionTransform transforms the messages of a single session.
+type SessionTransform interface {
+	// Outbound transforms a message written to the session.
+	Outbound(t websocket.MessageType, msg []byte) (websocket.MessageType, []byte, error)
+	// Inbound reverses Outbound for a message sent by the session. Messages it
+	// returns an error for are dropped, see HandleVerifyFailure.
+	Inbound(t websocket.MessageType, msg []byte) (websocket.MessageType, []byte, error)
+}
+
+// handshakeTransforms returns the transformers of a session upgraded from r.
+func (k *Kuromi) handshakeTransforms(w http.ResponseWriter, r *http.Request) ([]SessionTransform, error) {
+	if len(k.Config.Transforms) == 0 {
+		return nil, nil
+	}
+
+	transforms := make([]SessionTransform, 0, len(k.Config.Transforms))
+
+	for _, tr := range k.Config.Transforms {
+		st, err := tr.Handshake(w, r)
+		if err != nil {
+			return nil, err
+		}
+
+		transforms = append(transforms, st)
+	}
+
+	return transforms, nil
+}
+
+// transformOutbound runs a message written to the session through its transformers in order.
+func (s *Session) transformOutbound(t websocket.MessageType, msg []byte) (websocket.MessageType, []byte, error) {
+	var err error
+
+	for _, st := range s.transforms {
+		if t, msg, err = st.Outbound(t, msg); err != nil {
+			return 0, nil, err
+		}
+	}
+
+	return t, msg, nil
+}
+
+// transformInbound runs a message sent by the session through its transformers in reverse order.
+func (s *Session) transformInbound(t websocket.MessageType, msg []byte) (websocket.MessageType, []byte, error) {
+	var err error
+
+	for i := len(s.transforms) - 1; i >= 0; i-- {
+		if t, msg, err = s.transforms[i].Inbound(t, msg); err != nil {
+			return 0, nil, err
+		}
+	}
+
+	return t, msg, nil
+}
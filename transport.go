@@ -0,0 +1,161 @@
+package kuromi
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coder/websocket"
+)
+
+// Transport is the connection a session reads messages from and writes messages to.
+// *websocket.Conn is the default transport, see Config.WrapTransport for wrapping it.
+type Transport interface {
+	Read(ctx context.Context) (websocket.MessageType, []byte, error)
+	Write(ctx context.Context, typ websocket.MessageType, p []byte) error
+	Ping(ctx context.Context) error
+	Close(code websocket.StatusCode, reason string) error
+	SetReadLimit(n int64)
+}
+
+// unwrapTransport returns the innermost transport of t, following the Unwrap
+// method of transports wrapped with Config.WrapTransport.
+func unwrapTransport(t Transport) Transport {
+	for {
+		u, ok := t.(interface{ Unwrap() Transport })
+		if !ok {
+			return t
+		}
+
+		t = u.Unwrap()
+	}
+}
+
+// isEventStream reports whether r asks for a Server-Sent Events stream rather than a websocket.
+func isEventStream(r *http.Request) bool {
+	return r.Method == http.MethodGet &&
+		r.Header.Get("Upgrade") == "" &&
+		strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+}
+
+// sseTransport is a write-only transport sending messages as Server-Sent Events.
+// Text messages are sent as message events, binary messages as base64 encoded
+// binary events and closing the session sends a close event with the status code
+// and reason.
+type sseTransport struct {
+	w      http.ResponseWriter
+	rc     *http.ResponseController
+	reqCtx context.Context
+
+	mu     sync.Mutex
+	closed bool
+	done   chan struct{}
+	code   websocket.StatusCode
+	reason string
+}
+
+func acceptSSE(w http.ResponseWriter, r *http.Request) (*sseTransport, error) {
+	rc := http.NewResponseController(w)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	if err := rc.Flush(); err != nil {
+		return nil, err
+	}
+
+	return &sseTransport{
+		w:      w,
+		rc:     rc,
+		reqCtx: r.Context(),
+		done:   make(chan struct{}),
+	}, nil
+}
+
+// Read blocks until the session is closed, since clients cannot send messages over SSE.
+func (t *sseTransport) Read(ctx context.Context) (websocket.MessageType, []byte, error) {
+	select {
+	case <-t.done:
+		return 0, nil, websocket.CloseError{Code: t.code, Reason: t.reason}
+	case <-t.reqCtx.Done():
+		return 0, nil, websocket.CloseError{Code: websocket.StatusGoingAway}
+	case <-ctx.Done():
+		return 0, nil, ctx.Err()
+	}
+}
+
+func (t *sseTransport) Write(ctx context.Context, typ websocket.MessageType, p []byte) error {
+	switch typ {
+	case websocket.MessageText:
+		return t.send(ctx, "", string(p))
+	case websocket.MessageBinary:
+		return t.send(ctx, "binary", base64.StdEncoding.EncodeToString(p))
+	}
+
+	return fmt.Errorf("unsupported message type %v", typ)
+}
+
+// Ping sends a comment line, which keeps proxies from timing out the stream.
+func (t *sseTransport) Ping(ctx context.Context) error {
+	return t.write(ctx, ": ping\n\n")
+}
+
+func (t *sseTransport) Close(code websocket.StatusCode, reason string) error {
+	err := t.send(context.Background(), "close", fmt.Sprintf("%d %s", code, reason))
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !t.closed {
+		t.closed = true
+		t.code, t.reason = code, reason
+		close(t.done)
+	}
+
+	return err
+}
+
+func (t *sseTransport) SetReadLimit(int64) {}
+
+func (t *sseTransport) send(ctx context.Context, event, data string) error {
+	var b strings.Builder
+
+	if event != "" {
+		b.WriteString("event: " + event + "\n")
+	}
+
+	for _, line := range strings.Split(data, "\n") {
+		b.WriteString("data: " + line + "\n")
+	}
+
+	b.WriteString("\n")
+
+	return t.write(ctx, b.String())
+}
+
+func (t *sseTransport) write(ctx context.Context, s string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.closed {
+		return errors.New("sse stream closed")
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		t.rc.SetWriteDeadline(deadline)
+		defer t.rc.SetWriteDeadline(time.Time{})
+	}
+
+	if _, err := t.w.Write([]byte(s)); err != nil {
+		return err
+	}
+
+	return t.rc.Flush()
+}
@@ -0,0 +1,32 @@
+package kuromi
+
+import (
+	"context"
+	"time"
+
+	"github.com/coder/websocket"
+)
+
+// WriteWithTTL writes a text message to the session that is dropped, and passed to the
+// dead-letter handler, instead of written if it is still queued after ttl.
+func (s *Session) WriteWithTTL(msg []byte, ttl time.Duration) error {
+	return s.write(envelope{t: websocket.MessageText, msg: msg, expires: s.kuromi.now().Add(ttl)})
+}
+
+// WriteBinaryWithTTL writes a binary message to the session that is dropped if it is still queued after ttl.
+func (s *Session) WriteBinaryWithTTL(msg []byte, ttl time.Duration) error {
+	return s.write(envelope{t: websocket.MessageBinary, msg: msg, expires: s.kuromi.now().Add(ttl)})
+}
+
+// BroadcastWithTTL broadcasts a text message to all sessions that is dropped for
+// sessions it is still queued for after ttl, e.g. for price ticks that are
+// useless once stale.
+func (k *Kuromi) BroadcastWithTTL(msg []byte, ttl time.Duration) error {
+	return k.broadcast(context.Background(), envelope{t: websocket.MessageText, msg: msg, expires: k.now().Add(ttl)})
+}
+
+// BroadcastBinaryWithTTL broadcasts a binary message to all sessions that is dropped
+// for sessions it is still queued for after ttl.
+func (k *Kuromi) BroadcastBinaryWithTTL(msg []byte, ttl time.Duration) error {
+	return k.broadcast(context.Background(), envelope{t: websocket.MessageBinary, msg: msg, expires: k.now().Add(ttl)})
+}
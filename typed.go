@@ -0,0 +1,28 @@
+package kuromi
+
+// Typed is a kuromi instance whose sessions each carry a state of type T,
+// replacing type assertions on Session.Keys with a strongly typed struct.
+type Typed[T any] struct {
+	*Kuromi
+}
+
+// NewTyped creates a new kuromi instance like New, giving every session a
+// new zero *T state. The state of a resumed session is restored with it.
+func NewTyped[T any](opts ...Option) *Typed[T] {
+	k := New(opts...)
+	k.newData = func() any { return new(T) }
+
+	return &Typed[T]{k}
+}
+
+// Data returns the state of session s.
+func (t *Typed[T]) Data(s *Session) *T {
+	return Data[T](s)
+}
+
+// Data returns the state of session s created by a kuromi instance made with
+// NewTyped[T], or nil if s has no state of type T.
+func Data[T any](s *Session) *T {
+	d, _ := s.data.(*T)
+	return d
+}
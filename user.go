@@ -0,0 +1,102 @@
+package kuromi
+
+import "github.com/coder/websocket"
+
+// BindUser associates the session with the user id, replacing any previous binding.
+// A user can have several sessions, e.g. one per device.
+// Messages queued for the user in Config.Outbox are written to the session.
+// Binding a banned user closes the session.
+func (s *Session) BindUser(id string) {
+	s.rwmutex.Lock()
+	old := s.user
+	s.user = id
+	s.rwmutex.Unlock()
+
+	if old != "" {
+		s.kuromi.users.del(old, s)
+	}
+
+	if id != "" && s.kuromi.bans.banned(s.kuromi.bans.users, id, s.kuromi.now()) {
+		s.CloseWithMsg(StatusPolicyViolation, ErrBanned.Error())
+		return
+	}
+
+	if id == "" {
+		return
+	}
+
+	// Index the session under the same lock closing it takes, so a session
+	// closed concurrently is not indexed after untrack removed it.
+	s.rwmutex.Lock()
+	open := s.open
+	if open {
+		s.kuromi.users.add(id, s)
+	}
+	s.rwmutex.Unlock()
+
+	if open {
+		s.kuromi.deliverOutbox(s, id)
+	}
+}
+
+// UnbindUser removes the association of the session with its user.
+func (s *Session) UnbindUser() {
+	s.BindUser("")
+}
+
+// UserID returns the id of the user bound to the session, or an empty string if there is none.
+func (s *Session) UserID() string {
+	s.rwmutex.RLock()
+	defer s.rwmutex.RUnlock()
+
+	return s.user
+}
+
+// UserSessions returns the sessions bound to the user id.
+func (k *Kuromi) UserSessions(id string) []*Session {
+	return k.users.get(id)
+}
+
+// SendToUser writes a text message to all sessions of the user id.
+// It returns ErrUserNotConnected if the user has no sessions, unless Config.Outbox
+// is set, in which case the message is queued until the user connects.
+func (k *Kuromi) SendToUser(id string, msg []byte) error {
+	return k.sendToUser(id, envelope{t: websocket.MessageText, msg: msg})
+}
+
+// SendToUserBinary writes a binary message to all sessions of the user id.
+// It returns ErrUserNotConnected if the user has no sessions and Config.Outbox is not set.
+func (k *Kuromi) SendToUserBinary(id string, msg []byte) error {
+	return k.sendToUser(id, envelope{t: websocket.MessageBinary, msg: msg})
+}
+
+func (k *Kuromi) sendToUser(id string, message envelope) error {
+	if k.users.len(id) == 0 {
+		if k.Config.Outbox != nil {
+			return k.enqueue(id, message)
+		}
+
+		return ErrUserNotConnected
+	}
+
+	return k.broadcastIndexed(k.users, id, message)
+}
+
+// DisconnectUser closes all sessions of the user id.
+func (k *Kuromi) DisconnectUser(id string) error {
+	return k.DisconnectUserWithMsg(id, websocket.StatusNormalClosure, "")
+}
+
+// DisconnectUserWithMsg closes all sessions of the user id with the given close code and reason.
+func (k *Kuromi) DisconnectUserWithMsg(id string, code websocket.StatusCode, reason string) error {
+	sessions := k.users.get(id)
+	if len(sessions) == 0 {
+		return ErrUserNotConnected
+	}
+
+	for _, s := range sessions {
+		s.CloseWithMsg(code, reason)
+	}
+
+	return nil
+}
@@ -0,0 +1,78 @@
+package kuromi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/coder/websocket"
+)
+
+// ValidationError can be returned by a validator to reject a message, naming
+// the offending field.
+type ValidationError struct {
+	Field   string // Optional path of the invalid field, e.g. "user.name".
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	if e.Field != "" {
+		return e.Field + ": " + e.Message
+	}
+
+	return e.Message
+}
+
+// validationFrame is the error frame written to sessions whose message was rejected.
+type validationFrame struct {
+	Type    string `json:"type"`
+	Error   string `json:"error"`
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+}
+
+// Validate sets fn to validate messages before they reach the message handler,
+// e.g. with a JSON Schema validator. If fn returns an error the message is
+// rejected: the handler is not called and the session is sent a JSON text
+// message like
+//
+//	{"type":"error","error":"validation_failed","field":"name","message":"is required"}
+//
+// with the field and message of a *ValidationError, or the error text otherwise.
+func (h *handlers) Validate(fn func(*Session, []byte) error) {
+	h.validator = fn
+}
+
+func (h *handlers) onValidate(s *Session, msg []byte) error {
+	for ; h != nil; h = h.parent {
+		if h.validator != nil {
+			return h.validator(s, msg)
+		}
+	}
+
+	return nil
+}
+
+// processMessage validates message and passes it to the message handler.
+func (s *Session) processMessage(ctx context.Context, t websocket.MessageType, message []byte) error {
+	if err := s.handlers.onValidate(s, message); err != nil {
+		s.rejectMessage(err)
+		return nil
+	}
+
+	return s.handlers.onMessage(ctx, s, t, message)
+}
+
+func (s *Session) rejectMessage(err error) {
+	frame := validationFrame{Type: "error", Error: "validation_failed", Message: err.Error()}
+
+	var ve *ValidationError
+	if errors.As(err, &ve) {
+		frame.Field, frame.Message = ve.Field, ve.Message
+	}
+
+	msg, _ := json.Marshal(frame)
+
+	s.writeMessage(envelope{t: websocket.MessageText, msg: msg})
+	s.recordFault()
+}
@@ -0,0 +1,140 @@
+package kuromi
+
+import (
+	"bytes"
+	"context"
+	"strings"
+
+	"github.com/coder/websocket"
+)
+
+// Clients negotiate a protocol version with the first message, see
+// Config.VersionHandshake, by sending VersionPrefix followed by the versions
+// they support in order of preference, e.g. "kuromi.version:3,2". The server
+// answers with VersionPrefix followed by the chosen version.
+const VersionPrefix = "kuromi.version:"
+
+// Version is a set of handlers for the sessions that negotiated an application
+// protocol version, e.g. "2", so message formats can change while old and new
+// clients are connected during a rolling upgrade. Handlers that are not set on
+// the version fall back to the handlers of the kuromi instance. Sessions of a
+// namespace, route or subprotocol use the handlers of those instead.
+//
+// Versions are negotiated either with a subprotocol, see Config.VersionSubprotocol,
+// or with the first message, see Config.VersionHandshake.
+type Version struct {
+	handlers
+	name   string
+	kuromi *Kuromi
+}
+
+// Version returns the handler set for the protocol version name, creating it if
+// it does not exist yet. Versions are preferred in the order they are registered
+// when clients offer several with a subprotocol.
+func (k *Kuromi) Version(name string) *Version {
+	k.protocolsMu.Lock()
+	defer k.protocolsMu.Unlock()
+
+	if v, ok := k.versions[name]; ok {
+		return v
+	}
+
+	v := &Version{
+		handlers: handlers{parent: &k.handlers},
+		name:     name,
+		kuromi:   k,
+	}
+	k.versions[name] = v
+	k.versionNames = append(k.versionNames, name)
+
+	return v
+}
+
+func (k *Kuromi) lookupVersion(name string) *Version {
+	k.protocolsMu.RLock()
+	defer k.protocolsMu.RUnlock()
+
+	return k.versions[name]
+}
+
+// versionOfSubprotocol returns the version negotiated with subprotocol.
+func (k *Kuromi) versionOfSubprotocol(subprotocol string) (string, bool) {
+	prefix := k.Config.VersionSubprotocol
+
+	if prefix == "" || !strings.HasPrefix(subprotocol, prefix) {
+		return "", false
+	}
+
+	name := subprotocol[len(prefix):]
+
+	return name, k.lookupVersion(name) != nil
+}
+
+// Name returns the name of the version.
+func (v *Version) Name() string {
+	return v.name
+}
+
+// Sessions returns all sessions that negotiated the version.
+func (v *Version) Sessions() []*Session {
+	var sessions []*Session
+
+	v.kuromi.hub.sessions.each(func(s *Session) {
+		if s.version == v.name {
+			sessions = append(sessions, s)
+		}
+	})
+
+	return sessions
+}
+
+// Version returns the protocol version negotiated by the session, or an empty
+// string if none was negotiated.
+func (s *Session) Version() string {
+	return s.version
+}
+
+// setVersion sets the version of the session and its handlers, unless the
+// session uses the handlers of a namespace, route or subprotocol.
+func (s *Session) setVersion(v *Version) {
+	s.version = v.name
+
+	if s.handlers == &s.kuromi.handlers {
+		s.handlers = &v.handlers
+	}
+}
+
+// negotiateVersion reads the versions offered by the session from its first
+// message and answers with the first one registered. It must be called before
+// the session is registered with the hub and its pumps are started.
+func (s *Session) negotiateVersion() error {
+	rejected := &StatusError{Code: StatusPolicyViolation, Reason: ErrVersionMismatch.Error(), Err: ErrVersionMismatch}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.kuromi.Config.PongWait)
+	defer cancel()
+
+	t, message, err := s.conn.Read(ctx)
+	if err != nil {
+		return err
+	}
+
+	if len(s.transforms) > 0 {
+		if t, message, err = s.transformInbound(t, message); err != nil {
+			return err
+		}
+	}
+
+	if t != websocket.MessageText || !bytes.HasPrefix(message, []byte(VersionPrefix)) {
+		return rejected
+	}
+
+	for _, name := range strings.Split(string(message[len(VersionPrefix):]), ",") {
+		if v := s.kuromi.lookupVersion(strings.TrimSpace(name)); v != nil {
+			s.setVersion(v)
+
+			return s.Write([]byte(VersionPrefix + v.name))
+		}
+	}
+
+	return rejected
+}
@@ -0,0 +1,41 @@
+package kuromi
+
+// watch arms the watchdog of the session before the write pump blocks on the
+// connection and returns a func disarming it. If the call is still blocked
+// Config.WriteWait plus Config.WriteWatchdogGrace later, e.g. on a half-dead
+// TCP connection ignoring deadlines, the connection is force-closed so the
+// pumps of the session return.
+func (s *Session) watch() func() {
+	grace := s.kuromi.Config.WriteWatchdogGrace
+	if grace <= 0 {
+		return func() {}
+	}
+
+	d := s.kuromi.Config.WriteWait + grace
+
+	if s.watchdog == nil {
+		s.watchdog = s.kuromi.clock().AfterFunc(d, s.stalled)
+	} else {
+		s.watchdog.Reset(d)
+	}
+
+	return func() { s.watchdog.Stop() }
+}
+
+// stalled force-closes the connection of a session whose write pump is stuck.
+func (s *Session) stalled() {
+	s.rwmutex.Lock()
+	if s.closeCode == 0 {
+		s.closeCode, s.closeReason = StatusAbnormalClosure, ErrWriteStalled.Error()
+	}
+	s.rwmutex.Unlock()
+
+	s.handlers.onError(s, ErrWriteStalled)
+
+	if c, ok := unwrapTransport(s.conn).(interface{ CloseNow() error }); ok {
+		c.CloseNow()
+		return
+	}
+
+	go s.conn.Close(StatusAbnormalClosure, ErrWriteStalled.Error())
+}
@@ -0,0 +1,210 @@
+package kuromi
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Webhook event types.
+const (
+	WebhookConnect    = "connect"
+	WebhookDisconnect = "disconnect"
+	WebhookRoomJoin   = "room.join"
+	WebhookRoomLeave  = "room.leave"
+)
+
+// WebhookSignatureHeader is the header carrying the HMAC-SHA256 signature of
+// the request body as "sha256=<hex>" when Webhook.Secret is set.
+const WebhookSignatureHeader = "X-Kuromi-Signature"
+
+// Webhook describes an endpoint that lifecycle events are posted to as JSON, see AddWebhook.
+type Webhook struct {
+	URL        string        // Endpoint the events are posted to.
+	Secret     []byte        // Optional key used to sign requests, see WebhookSignatureHeader.
+	Events     []string      // Event types posted to the endpoint, all of them if empty.
+	MaxRetries int           // How many times a failed request is retried.
+	Backoff    time.Duration // Delay before the first retry, doubled for every further one.
+	QueueSize  int           // Maximum number of events waiting to be posted, further events are dropped.
+	Timeout    time.Duration // Deadline of each request, 10 seconds if 0.
+	Client     *http.Client  // Client used for requests, http.DefaultClient if nil.
+
+	// OnError is an optional function called with events that were dropped
+	// because the queue was full or could not be posted after all retries.
+	OnError func(WebhookEvent, error)
+}
+
+// WebhookEvent is the JSON body of webhook requests.
+type WebhookEvent struct {
+	Type          string    `json:"type"`
+	SessionID     string    `json:"session_id"`
+	CorrelationID string    `json:"correlation_id,omitempty"`
+	UserID        string    `json:"user_id,omitempty"`
+	ClientIP      string    `json:"client_ip,omitempty"`
+	Room          string    `json:"room,omitempty"`
+	Time          time.Time `json:"time"`
+}
+
+type webhookSender struct {
+	hook  Webhook
+	queue chan WebhookEvent
+}
+
+type webhooks struct {
+	mu      sync.RWMutex
+	senders []*webhookSender
+	closed  bool
+}
+
+// AddWebhook posts lifecycle events to the endpoint described by w. Requests are
+// sent in the background and retried on network errors and non-2xx responses.
+func (k *Kuromi) AddWebhook(w Webhook) {
+	if w.Client == nil {
+		w.Client = http.DefaultClient
+	}
+
+	if w.QueueSize <= 0 {
+		w.QueueSize = 1024
+	}
+
+	if w.Backoff <= 0 {
+		w.Backoff = time.Second
+	}
+
+	if w.Timeout <= 0 {
+		w.Timeout = 10 * time.Second
+	}
+
+	ws := &webhookSender{hook: w, queue: make(chan WebhookEvent, w.QueueSize)}
+
+	k.webhooks.mu.Lock()
+	defer k.webhooks.mu.Unlock()
+
+	if k.webhooks.closed {
+		return
+	}
+
+	k.webhooks.senders = append(k.webhooks.senders, ws)
+
+	go ws.run()
+}
+
+// emit records a lifecycle event of type typ about s and queues it for all
+// webhooks subscribed to it.
+func (k *Kuromi) emit(typ string, s *Session, room string) {
+	k.auditSession(typ, s, room, "")
+
+	k.webhooks.mu.RLock()
+	defer k.webhooks.mu.RUnlock()
+
+	if k.webhooks.closed || len(k.webhooks.senders) == 0 {
+		return
+	}
+
+	ev := WebhookEvent{
+		Type:          typ,
+		SessionID:     s.ID(),
+		CorrelationID: s.CorrelationID(),
+		UserID:        s.UserID(),
+		ClientIP:      s.ClientIP(),
+		Room:          room,
+		Time:          k.now(),
+	}
+
+	for _, ws := range k.webhooks.senders {
+		if len(ws.hook.Events) > 0 && !slices.Contains(ws.hook.Events, typ) {
+			continue
+		}
+
+		select {
+		case ws.queue <- ev:
+		default:
+			ws.fail(ev, ErrWebhookQueueFull)
+		}
+	}
+}
+
+// stopWebhooks stops posting events once the queued ones are sent.
+func (k *Kuromi) stopWebhooks() {
+	k.webhooks.mu.Lock()
+	defer k.webhooks.mu.Unlock()
+
+	if k.webhooks.closed {
+		return
+	}
+
+	k.webhooks.closed = true
+
+	for _, ws := range k.webhooks.senders {
+		close(ws.queue)
+	}
+}
+
+func (ws *webhookSender) run() {
+	for ev := range ws.queue {
+		body, err := json.Marshal(ev)
+		if err != nil {
+			continue
+		}
+
+		backoff := ws.hook.Backoff
+
+		for attempt := 0; ; attempt++ {
+			err = ws.post(body)
+			if err == nil || attempt >= ws.hook.MaxRetries {
+				break
+			}
+
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+
+		if err != nil {
+			ws.fail(ev, err)
+		}
+	}
+}
+
+func (ws *webhookSender) fail(ev WebhookEvent, err error) {
+	if ws.hook.OnError != nil {
+		ws.hook.OnError(ev, err)
+	}
+}
+
+func (ws *webhookSender) post(body []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), ws.hook.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, ws.hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	if len(ws.hook.Secret) > 0 {
+		mac := hmac.New(sha256.New, ws.hook.Secret)
+		mac.Write(body)
+		req.Header.Set(WebhookSignatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	res, err := ws.hook.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("%w: %s responded %s", ErrWebhookFailed, ws.hook.URL, res.Status)
+	}
+
+	return nil
+}